@@ -0,0 +1,172 @@
+// Package blob stores large values outside SSTables.
+//
+// A blob file is an append-only sequence of records:
+//
+//	[crc32 uint32][key len uvarint][value len uvarint][key][value]
+//
+// The CRC covers everything after it. An SSTable entry refers to a record
+// through an Index, so compaction moves the small index instead of the value.
+package blob
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+var ErrCorrupt = errors.New("corrupt blob record")
+
+// Index locates one record in a blob file.
+type Index struct {
+	FileNum uint64
+	Offset  uint64
+	Length  uint64 // Whole record, header included.
+}
+
+// Encode serializes the index as three uvarints.
+func (idx Index) Encode() []byte {
+	buf := make([]byte, 0, 3*binary.MaxVarintLen64)
+	buf = binary.AppendUvarint(buf, idx.FileNum)
+	buf = binary.AppendUvarint(buf, idx.Offset)
+	return binary.AppendUvarint(buf, idx.Length)
+}
+
+// DecodeIndex parses an index written by Encode.
+func DecodeIndex(b []byte) (Index, error) {
+	var idx Index
+	for _, field := range []*uint64{&idx.FileNum, &idx.Offset, &idx.Length} {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return Index{}, ErrCorrupt
+		}
+		*field = v
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		return Index{}, ErrCorrupt
+	}
+	return idx, nil
+}
+
+// Writer appends records to a new blob file.
+type Writer struct {
+	file    *os.File
+	writer  *bufio.Writer
+	fileNum uint64
+	offset  uint64
+	count   uint64
+	closed  bool
+}
+
+// NewWriter creates the blob file fileNum at path.
+func NewWriter(path string, fileNum uint64) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		file:    f,
+		writer:  bufio.NewWriter(f),
+		fileNum: fileNum,
+	}, nil
+}
+
+// Add appends a record and returns its index.
+func (w *Writer) Add(key, value []byte) (Index, error) {
+	body := make([]byte, 0, 2*binary.MaxVarintLen64+len(key)+len(value))
+	body = binary.AppendUvarint(body, uint64(len(key)))
+	body = binary.AppendUvarint(body, uint64(len(value)))
+	body = append(body, key...)
+	body = append(body, value...)
+
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(body))
+	if _, err := w.writer.Write(crc[:]); err != nil {
+		return Index{}, err
+	}
+	if _, err := w.writer.Write(body); err != nil {
+		return Index{}, err
+	}
+
+	idx := Index{
+		FileNum: w.fileNum,
+		Offset:  w.offset,
+		Length:  uint64(len(crc) + len(body)),
+	}
+	w.offset += idx.Length
+	w.count++
+	return idx, nil
+}
+
+// Count returns the number of records written.
+func (w *Writer) Count() uint64 {
+	return w.count
+}
+
+// Size returns the number of bytes written.
+func (w *Writer) Size() uint64 {
+	return w.offset
+}
+
+// Close flushes buffered records and closes the file.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Reader reads records from a blob file. It is safe for concurrent use.
+type Reader struct {
+	file *os.File
+}
+
+// NewReader opens the blob file at path.
+func NewReader(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{file: f}, nil
+}
+
+// Get returns the value of the record at idx, verifying its checksum.
+func (r *Reader) Get(idx Index) ([]byte, error) {
+	if idx.Length < 4 {
+		return nil, ErrCorrupt
+	}
+	data := make([]byte, idx.Length)
+	if _, err := r.file.ReadAt(data, int64(idx.Offset)); err != nil {
+		return nil, err
+	}
+
+	body := data[4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data) {
+		return nil, ErrCorrupt
+	}
+	keyLen, n := binary.Uvarint(body)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	body = body[n:]
+	valueLen, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) != keyLen+valueLen {
+		return nil, ErrCorrupt
+	}
+	return body[n+int(keyLen):], nil
+}
+
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
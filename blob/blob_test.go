@@ -0,0 +1,82 @@
+package blob
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000007.blob")
+
+	w, err := NewWriter(path, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]byte{
+		bytes.Repeat([]byte("a"), 1000),
+		{},
+		bytes.Repeat([]byte("c"), 70000),
+	}
+	var indexes []Index
+	for i, v := range values {
+		idx, err := w.Add([]byte{byte('k' + i)}, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, idx)
+	}
+	if w.Count() != 3 {
+		t.Fatalf("expected 3 records, got %d", w.Count())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i, idx := range indexes {
+		decoded, err := DecodeIndex(idx.Encode())
+		if err != nil || decoded != idx {
+			t.Fatalf("index round trip: got %+v, %v; want %+v", decoded, err, idx)
+		}
+		v, err := r.Get(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, values[i]) {
+			t.Fatalf("record %d: value mismatch", i)
+		}
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.blob")
+	w, err := NewWriter(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, _ := w.Add([]byte("k"), []byte("value"))
+	w.Close()
+
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xFF
+	os.WriteFile(path, data, 0644)
+
+	r, err := NewReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Get(idx); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if _, err := DecodeIndex([]byte{0x80}); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt for truncated index, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"vern_kv0.8/engine"
+)
+
+// loadBatchSize bounds the bytes buffered in one write batch during LOAD.
+const loadBatchSize = 1 << 20
+
+// dumpRecord is one line of a dump file. Keys and values that are valid
+// UTF-8 are written as text; others are base64 under the *_b64 field.
+// Exactly one of Key/KeyB64 and one of Value/ValueB64 is set.
+type dumpRecord struct {
+	Key      *string `json:"key,omitempty"`
+	KeyB64   *string `json:"key_b64,omitempty"`
+	Value    *string `json:"value,omitempty"`
+	ValueB64 *string `json:"value_b64,omitempty"`
+}
+
+func encodeField(b []byte) (text, b64 *string) {
+	s := string(b)
+	if utf8.ValidString(s) {
+		return &s, nil
+	}
+	s = base64.StdEncoding.EncodeToString(b)
+	return nil, &s
+}
+
+func decodeField(text, b64 *string) ([]byte, error) {
+	switch {
+	case text != nil && b64 == nil:
+		return []byte(*text), nil
+	case b64 != nil && text == nil:
+		return base64.StdEncoding.DecodeString(*b64)
+	default:
+		return nil, errors.New("expected exactly one of the text and base64 fields")
+	}
+}
+
+// dumpDB writes every live key beginning with prefix to w as JSON lines, in
+// key order. An empty prefix dumps the whole DB. It returns the number of
+// records written.
+func dumpDB(db *engine.DB, w io.Writer, prefix []byte) (int, error) {
+	var it engine.Iterator
+	if len(prefix) > 0 {
+		it = db.NewPrefixIterator(prefix, nil)
+	} else {
+		it = db.NewIterator(nil)
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		var rec dumpRecord
+		rec.Key, rec.KeyB64 = encodeField(it.Key())
+		rec.Value, rec.ValueB64 = encodeField(it.Value())
+		if err := enc.Encode(&rec); err != nil {
+			it.Close()
+			return n, err
+		}
+		n++
+	}
+	// Close reports a blob or merge failure that ended the scan early.
+	if err := it.Close(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// loadDB reads JSON lines written by dumpDB from r and stores them through
+// write batches. Records already written stay when a later one is malformed.
+// It returns the number of records loaded.
+func loadDB(db *engine.DB, r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.DisallowUnknownFields()
+
+	batch := engine.NewWriteBatch()
+	n := 0
+	for {
+		var rec dumpRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("record %d: %v", n+batch.Count()+1, err)
+		}
+
+		key, err := decodeField(rec.Key, rec.KeyB64)
+		if err != nil {
+			return n, fmt.Errorf("record %d: key: %v", n+batch.Count()+1, err)
+		}
+		if len(key) == 0 {
+			return n, fmt.Errorf("record %d: %v", n+batch.Count()+1, engine.ErrEmptyKey)
+		}
+		value, err := decodeField(rec.Value, rec.ValueB64)
+		if err != nil {
+			return n, fmt.Errorf("record %d: value: %v", n+batch.Count()+1, err)
+		}
+		batch.Put(key, value)
+
+		if batch.ApproximateSize() >= loadBatchSize {
+			if err := db.Write(batch); err != nil {
+				return n, err
+			}
+			n += batch.Count()
+			batch.Clear()
+		}
+	}
+
+	if batch.Count() > 0 {
+		if err := db.Write(batch); err != nil {
+			return n, err
+		}
+		n += batch.Count()
+	}
+	return n, nil
+}
+
+// dumpToFile dumps into path; "-" is standard output.
+func dumpToFile(db *engine.DB, path string, prefix []byte) (int, error) {
+	if path == "-" {
+		return dumpDB(db, os.Stdout, prefix)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dumpDB(db, f, prefix)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// loadFromFile loads from path; "-" is standard input.
+func loadFromFile(db *engine.DB, path string) (int, error) {
+	if path == "-" {
+		return loadDB(db, os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return loadDB(db, f)
+}
+
+// runSubcommand handles "vern-cli dump|load ..." without the interactive
+// shell. It reports whether args named a subcommand and the exit code.
+func runSubcommand(args []string) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+
+	switch args[0] {
+	case "dump":
+		if len(args) < 3 || len(args) > 4 {
+			fmt.Fprintln(os.Stderr, "Usage: vern-cli dump <path> <file|-> [prefix]")
+			return true, 2
+		}
+		var prefix []byte
+		if len(args) == 4 {
+			prefix = []byte(args[3])
+		}
+		return true, withDB(args[1], func(db *engine.DB) error {
+			n, err := dumpToFile(db, args[2], prefix)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "Dumped %d keys.\n", n)
+			}
+			return err
+		})
+	case "load":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: vern-cli load <path> <file|->")
+			return true, 2
+		}
+		return true, withDB(args[1], func(db *engine.DB) error {
+			n, err := loadFromFile(db, args[2])
+			fmt.Fprintf(os.Stderr, "Loaded %d keys.\n", n)
+			return err
+		})
+	}
+	return false, 0
+}
+
+// withDB opens the database at path, as OPEN does, runs fn and closes it.
+func withDB(path string, fn func(db *engine.DB) error) int {
+	db, err := openDataDir(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] System Error: Failed to open database: %v\n", err)
+		return 1
+	}
+	err = fn(db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func execDump(parts []string) {
+	if !ensureOpen() {
+		return
+	}
+	if len(parts) < 2 || len(parts) > 3 {
+		printError("[ERROR] Syntax Error: Invalid arguments.")
+		fmt.Printf("%sUsage:%s DUMP <file> [prefix]\n", ColorRed, ColorReset)
+		return
+	}
+	var prefix []byte
+	if len(parts) == 3 {
+		prefix = []byte(parts[2])
+	}
+
+	n, err := dumpToFile(db, parts[1], prefix)
+	if err != nil {
+		printError(fmt.Sprintf("[ERROR] System Error: Dump failed (%v)", err))
+		return
+	}
+	printSuccess(fmt.Sprintf("OK (%d keys)", n))
+}
+
+func execLoad(parts []string) {
+	if !ensureOpen() {
+		return
+	}
+	if len(parts) != 2 {
+		printError("[ERROR] Syntax Error: Missing file argument.")
+		fmt.Printf("%sUsage:%s LOAD <file>\n", ColorRed, ColorReset)
+		return
+	}
+
+	n, err := loadFromFile(db, parts[1])
+	if err != nil {
+		printError(fmt.Sprintf("[ERROR] System Error: Load failed after %d keys (%v)", n, err))
+		return
+	}
+	printSuccess(fmt.Sprintf("OK (%d keys)", n))
+}
@@ -38,6 +38,11 @@ var (
 var termState *term.State
 
 func main() {
+	// Non-interactive subcommands run and exit without the shell.
+	if ok, code := runSubcommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	// Catch interrupt signals for graceful shutdown.
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -269,6 +274,10 @@ func handleCommand(line string) {
 		execDelete(parts)
 	case "SCAN":
 		execScan(parts)
+	case "DUMP":
+		execDump(parts)
+	case "LOAD":
+		execLoad(parts)
 	case "CLEAR":
 		execClear()
 	case "HELP":
@@ -304,16 +313,11 @@ func execOpen(parts []string) {
 
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		fmt.Println("Creating data directory...")
-		// Ensure data directory exists.
-		if err := os.MkdirAll(dataDir, 0755); err != nil {
-			printError(fmt.Sprintf("[ERROR] System Error: Cannot create data directory (%v)", err))
-			return
-		}
 	}
 
 	fmt.Println("Initializing storage engine...")
 	var err error
-	db, err = engine.Open(dataDir)
+	db, err = openDataDir(path)
 	if err != nil {
 		printError(fmt.Sprintf("[ERROR] System Error: Failed to open database: %v", err))
 		return
@@ -323,6 +327,15 @@ func execOpen(parts []string) {
 	fmt.Println("Server is ready.")
 }
 
+// openDataDir opens the database kept under path/data, creating it if needed.
+func openDataDir(path string) (*engine.DB, error) {
+	dataDir := filepath.Join(path, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	return engine.Open(dataDir)
+}
+
 func execPut(line string, parts []string) {
 	if !ensureOpen() {
 		return
@@ -459,6 +472,7 @@ func execScan(parts []string) {
 
 func runPrefixScan(prefix string) {
 	it := db.NewPrefixIterator([]byte(prefix), nil)
+	defer it.Close()
 	defer printEnd()
 
 	for it.SeekToFirst(); it.Valid(); it.Next() {
@@ -468,6 +482,7 @@ func runPrefixScan(prefix string) {
 
 func runRangeScan(start, end string) {
 	it := db.NewRangeIterator([]byte(start), []byte(end), nil)
+	defer it.Close()
 	defer printEnd()
 
 	for it.SeekToFirst(); it.Valid(); it.Next() {
@@ -489,9 +504,11 @@ func execHelp() {
 	fmt.Println()
 	fmt.Println("  CLEAR                    - Clear the terminal screen")
 	fmt.Println("  DELETE <key>             - Delete a key-value pair")
+	fmt.Println("  DUMP <file> [prefix]     - Write all keys, or those with prefix, to file as JSON lines")
 	fmt.Println("  EXIT                     - Exit the CLI")
 	fmt.Println("  GET <key>                - Retrieve the value for a key")
 	fmt.Println("  HELP                     - Display available commands")
+	fmt.Println("  LOAD <file>              - Load keys from a DUMP file")
 	fmt.Println("  OPEN <path>              - Open a database at the specified path")
 	fmt.Println("  PUT <key> <value>        - Insert or update a key-value pair")
 	fmt.Println("  SCAN <keyN> <keyM>       - Range scan from keyN to keyM")
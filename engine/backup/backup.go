@@ -0,0 +1,458 @@
+// Package backup keeps numbered, incremental backups of a live DB.
+//
+// A backup directory holds:
+//
+//	meta/NNNNNN                 one file per backup, listing what it restores
+//	shared/NNNNNN_CCCCCCCC.sst  tables and blob files, by file number and CRC32
+//	private/NNNNNN/...          the backup's MANIFEST and WAL segments
+//	tmp/                        checkpoints being copied
+//
+// Tables and blob files never change once written, so a file already in
+// shared/ is reused by every later backup that contains it.
+package backup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vern_kv0.8/engine"
+)
+
+var (
+	ErrBackupNotFound     = errors.New("backup not found")
+	ErrBackupCorrupt      = errors.New("backup file missing or corrupt")
+	ErrRestoreDirNotEmpty = errors.New("restore directory is not empty")
+)
+
+// Info describes a backup.
+type Info struct {
+	ID        uint32
+	Timestamp time.Time
+	Size      int64 // Bytes restored, counting shared files in full.
+	NumFiles  int
+}
+
+// backupFile is a file of a backup.
+type backupFile struct {
+	stored string // Relative to the backup directory.
+	target string // Relative to the restored DB directory.
+	crc    uint32
+	size   int64
+}
+
+// Engine manages the backups in one directory.
+type Engine struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Open opens the backup directory dir, creating it if needed.
+func Open(dir string) (*Engine, error) {
+	for _, sub := range []string{"meta", "shared", "private", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Engine{dir: dir}, nil
+}
+
+// CreateBackup backs up db under the next backup ID. Writes to db continue
+// meanwhile. Only tables and blob files not already shared are copied.
+func (e *Engine) CreateBackup(db *engine.DB) (Info, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.idsLocked()
+	if err != nil {
+		return Info{}, err
+	}
+	id := uint32(1)
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	ckpt := filepath.Join(e.dir, "tmp", idName(id))
+	if err := os.RemoveAll(ckpt); err != nil {
+		return Info{}, err
+	}
+	defer os.RemoveAll(ckpt)
+	if err := db.Checkpoint(ckpt); err != nil {
+		return Info{}, err
+	}
+
+	private := filepath.Join("private", idName(id))
+	if err := os.RemoveAll(filepath.Join(e.dir, private)); err != nil {
+		return Info{}, err
+	}
+
+	var files []backupFile
+	err = filepath.WalkDir(ckpt, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		target, err := filepath.Rel(ckpt, path)
+		if err != nil {
+			return err
+		}
+		crc, size, err := checksumFile(path)
+		if err != nil {
+			return err
+		}
+
+		f := backupFile{target: filepath.ToSlash(target), crc: crc, size: size}
+		if ext := filepath.Ext(target); ext == ".sst" || ext == ".blob" {
+			name := fmt.Sprintf("%s_%08x%s", strings.TrimSuffix(filepath.Base(target), ext), crc, ext)
+			f.stored = filepath.ToSlash(filepath.Join("shared", name))
+			if _, err := os.Stat(filepath.Join(e.dir, f.stored)); err == nil {
+				files = append(files, f)
+				return nil
+			}
+		} else {
+			f.stored = filepath.ToSlash(filepath.Join(private, target))
+		}
+
+		if _, err := copyFile(path, filepath.Join(e.dir, f.stored)); err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(filepath.Join(e.dir, private))
+		return Info{}, err
+	}
+
+	// The backup exists once its metadata does.
+	info := Info{ID: id, Timestamp: time.Now()}
+	if err := e.writeMeta(info, files); err != nil {
+		os.RemoveAll(filepath.Join(e.dir, private))
+		return Info{}, err
+	}
+	return infoOf(info, files), nil
+}
+
+// List returns every backup, oldest first.
+func (e *Engine) List() ([]Info, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.idsLocked()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(ids))
+	for _, id := range ids {
+		info, files, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, infoOf(info, files))
+	}
+	return infos, nil
+}
+
+// Delete removes backup id, and the shared files no other backup uses.
+func (e *Engine) Delete(id uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deleteLocked(id)
+}
+
+// PurgeOld deletes the oldest backups until at most keep remain.
+func (e *Engine) PurgeOld(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.idsLocked()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(ids)-keep; i++ {
+		if err := e.deleteLocked(ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks the size and checksum of every file of backup id.
+// It returns ErrBackupCorrupt if any is missing or damaged.
+func (e *Engine) Verify(id uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, files, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		crc, size, err := checksumFile(filepath.Join(e.dir, f.stored))
+		if os.IsNotExist(err) {
+			return ErrBackupCorrupt
+		}
+		if err != nil {
+			return err
+		}
+		if crc != f.crc || size != f.size {
+			return ErrBackupCorrupt
+		}
+	}
+	return nil
+}
+
+// RestoreFromBackup writes backup id to dbDir, which must be empty or not
+// exist. The result opens with engine.Open under the Config of the backed-up DB.
+// Files are verified as they are copied; on ErrBackupCorrupt dbDir is left
+// without a MANIFEST.
+func (e *Engine) RestoreFromBackup(id uint32, dbDir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, files, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dbDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrRestoreDirNotEmpty
+	}
+
+	// The manifest goes last, so a failed restore is not a DB.
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[j].target == "MANIFEST" && files[i].target != "MANIFEST"
+	})
+	for _, f := range files {
+		crc, err := copyFile(filepath.Join(e.dir, f.stored), filepath.Join(dbDir, filepath.FromSlash(f.target)))
+		if os.IsNotExist(err) {
+			return ErrBackupCorrupt
+		}
+		if err != nil {
+			return err
+		}
+		if crc != f.crc {
+			os.Remove(filepath.Join(dbDir, filepath.FromSlash(f.target)))
+			return ErrBackupCorrupt
+		}
+	}
+	return syncDir(dbDir)
+}
+
+func (e *Engine) deleteLocked(id uint32) error {
+	if _, _, err := e.readMeta(id); err != nil {
+		return err
+	}
+	if err := os.Remove(e.metaPath(id)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(e.dir, "private", idName(id))); err != nil {
+		return err
+	}
+	return e.collectSharedLocked()
+}
+
+// collectSharedLocked deletes shared files no backup lists.
+func (e *Engine) collectSharedLocked() error {
+	ids, err := e.idsLocked()
+	if err != nil {
+		return err
+	}
+	inUse := make(map[string]bool)
+	for _, id := range ids {
+		_, files, err := e.readMeta(id)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			inUse[f.stored] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(e.dir, "shared"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		stored := "shared/" + entry.Name()
+		if !inUse[stored] {
+			if err := os.Remove(filepath.Join(e.dir, stored)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// idsLocked returns the IDs of complete backups, ascending.
+func (e *Engine) idsLocked() ([]uint32, error) {
+	entries, err := os.ReadDir(filepath.Join(e.dir, "meta"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue // Temporary or foreign file.
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (e *Engine) metaPath(id uint32) string {
+	return filepath.Join(e.dir, "meta", idName(id))
+}
+
+// Metadata is text: a timestamp line, then one line per file.
+//
+//	timestamp <unix nanos>
+//	file <stored path> <target path> <crc32 hex> <size>
+func (e *Engine) writeMeta(info Info, files []backupFile) error {
+	path := e.metaPath(info.ID)
+	tmpPath := path + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "timestamp %d\n", info.Timestamp.UnixNano())
+	for _, bf := range files {
+		fmt.Fprintf(w, "file %q %q %08x %d\n", bf.stored, bf.target, bf.crc, bf.size)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (e *Engine) readMeta(id uint32) (Info, []backupFile, error) {
+	data, err := os.ReadFile(e.metaPath(id))
+	if os.IsNotExist(err) {
+		return Info{}, nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return Info{}, nil, err
+	}
+
+	info := Info{ID: id}
+	var files []backupFile
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "timestamp "):
+			var nanos int64
+			if _, err := fmt.Sscanf(line, "timestamp %d", &nanos); err != nil {
+				return Info{}, nil, ErrBackupCorrupt
+			}
+			info.Timestamp = time.Unix(0, nanos)
+		case strings.HasPrefix(line, "file "):
+			var f backupFile
+			if _, err := fmt.Sscanf(line, "file %q %q %x %d", &f.stored, &f.target, &f.crc, &f.size); err != nil {
+				return Info{}, nil, ErrBackupCorrupt
+			}
+			files = append(files, f)
+		default:
+			return Info{}, nil, ErrBackupCorrupt
+		}
+	}
+	return info, files, nil
+}
+
+func infoOf(info Info, files []backupFile) Info {
+	info.NumFiles = len(files)
+	info.Size = 0
+	for _, f := range files {
+		info.Size += f.size
+	}
+	return info
+}
+
+func idName(id uint32) string {
+	return fmt.Sprintf("%06d", id)
+}
+
+func checksumFile(path string) (uint32, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return h.Sum32(), n, nil
+}
+
+// copyFile copies src to dst through a temporary file and returns the CRC32
+// of what was copied.
+func copyFile(src, dst string) (uint32, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return h.Sum32(), os.Rename(tmp, dst)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
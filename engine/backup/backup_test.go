@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"vern_kv0.8/engine"
+)
+
+func put(t *testing.T, db *engine.DB, from, to int, value string) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expect(t *testing.T, db *engine.DB, key, want string) {
+	t.Helper()
+	v, err := db.Get([]byte(key))
+	if want == "" {
+		if err != engine.ErrNotFound {
+			t.Fatalf("expected %s absent, got %q (%v)", key, v, err)
+		}
+		return
+	}
+	if err != nil || string(v) != want {
+		t.Fatalf("expected %s = %s, got %q (%v)", key, want, v, err)
+	}
+}
+
+func sharedFiles(t *testing.T, dir string) map[string]os.FileInfo {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, "shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]os.FileInfo)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = info
+	}
+	return files
+}
+
+func TestIncrementalBackupAndRestore(t *testing.T) {
+	db, err := engine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	be, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, db, 0, 100, "v1")
+	first, err := be.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := sharedFiles(t, dir)
+	if len(before) == 0 {
+		t.Fatal("expected shared tables after the first backup")
+	}
+
+	put(t, db, 100, 200, "v2")
+	db.Delete([]byte("k000"))
+	second, err := be.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID+1 {
+		t.Fatalf("expected consecutive IDs, got %d and %d", first.ID, second.ID)
+	}
+
+	// Files of the first backup are reused, not copied again.
+	after := sharedFiles(t, dir)
+	if len(after) <= len(before) {
+		t.Fatalf("expected new shared files, got %d then %d", len(before), len(after))
+	}
+	for name, info := range before {
+		if !os.SameFile(info, after[name]) {
+			t.Fatalf("expected %s reused", name)
+		}
+	}
+
+	infos, err := be.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != first.ID || infos[1].NumFiles != second.NumFiles {
+		t.Fatalf("unexpected backups %+v", infos)
+	}
+	for _, info := range infos {
+		if err := be.Verify(info.ID); err != nil {
+			t.Fatalf("verify %d: %v", info.ID, err)
+		}
+	}
+
+	restored := t.TempDir()
+	if err := be.RestoreFromBackup(first.ID, restored); err != nil {
+		t.Fatal(err)
+	}
+	r, err := engine.Open(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, r, "k000", "v1")
+	expect(t, r, "k099", "v1")
+	expect(t, r, "k100", "")
+	r.Close()
+
+	if err := be.RestoreFromBackup(first.ID, restored); err != ErrRestoreDirNotEmpty {
+		t.Fatalf("expected ErrRestoreDirNotEmpty, got %v", err)
+	}
+
+	// Deleting the first backup keeps what the second still shares.
+	if err := be.PurgeOld(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := be.List(); err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Verify(first.ID); err != ErrBackupNotFound {
+		t.Fatalf("expected ErrBackupNotFound, got %v", err)
+	}
+	if err := be.Verify(second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restored = filepath.Join(t.TempDir(), "db")
+	if err := be.RestoreFromBackup(second.ID, restored); err != nil {
+		t.Fatal(err)
+	}
+	r, err = engine.Open(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	expect(t, r, "k000", "")
+	expect(t, r, "k050", "v1")
+	expect(t, r, "k150", "v2")
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	db, err := engine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	put(t, db, 0, 50, "v")
+
+	dir := t.TempDir()
+	be, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := be.CreateBackup(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range sharedFiles(t, dir) {
+		path := filepath.Join(dir, "shared", name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[0] ^= 0xFF
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+
+	if err := be.Verify(info.ID); err != ErrBackupCorrupt {
+		t.Fatalf("expected ErrBackupCorrupt, got %v", err)
+	}
+	restored := t.TempDir()
+	if err := be.RestoreFromBackup(info.ID, restored); err != ErrBackupCorrupt {
+		t.Fatalf("expected ErrBackupCorrupt on restore, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "MANIFEST")); !os.IsNotExist(err) {
+		t.Fatal("expected no MANIFEST after a failed restore")
+	}
+	if err := be.Delete(99); err != ErrBackupNotFound {
+		t.Fatalf("expected ErrBackupNotFound, got %v", err)
+	}
+}
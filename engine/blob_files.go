@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"vern_kv0.8/blob"
+	"vern_kv0.8/internal"
+	"vern_kv0.8/manifest"
+)
+
+func blobFileName(dir string, fileNum uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.blob", fileNum))
+}
+
+// blobCache keeps blob file readers open.
+// A reader is evicted once its file is deleted, which only happens after
+// every version referencing it is released.
+type blobCache struct {
+	dir string
+
+	mu      sync.Mutex
+	readers map[uint64]*blob.Reader
+}
+
+func newBlobCache(dir string) *blobCache {
+	return &blobCache{
+		dir:     dir,
+		readers: make(map[uint64]*blob.Reader),
+	}
+}
+
+// Get resolves an encoded blob index into its value.
+func (bc *blobCache) Get(ref []byte) ([]byte, error) {
+	idx, err := blob.DecodeIndex(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.mu.Lock()
+	r, ok := bc.readers[idx.FileNum]
+	if !ok {
+		r, err = blob.NewReader(blobFileName(bc.dir, idx.FileNum))
+		if err != nil {
+			bc.mu.Unlock()
+			return nil, err
+		}
+		bc.readers[idx.FileNum] = r
+	}
+	bc.mu.Unlock()
+
+	return r.Get(idx)
+}
+
+// Evict closes the reader of a deleted blob file.
+func (bc *blobCache) Evict(fileNum uint64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if r, ok := bc.readers[fileNum]; ok {
+		r.Close()
+		delete(bc.readers, fileNum)
+	}
+}
+
+func (bc *blobCache) Close() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for fileNum, r := range bc.readers {
+		r.Close()
+		delete(bc.readers, fileNum)
+	}
+}
+
+// blobOutput is the blob file written by one flush or compaction.
+// The file is only created once a value is separated into it.
+type blobOutput struct {
+	db      *DB
+	minSize int // Values at least this long are separated; 0 disables.
+
+	w       *blob.Writer
+	fileNum uint64
+}
+
+func (db *DB) newBlobOutput(cf *columnFamily) *blobOutput {
+	return &blobOutput{db: db, minSize: cf.opts.MinBlobSize}
+}
+
+// separate moves a large plain value into the blob file, returning the
+// entry to store in its place. Other entries are returned unchanged.
+func (o *blobOutput) separate(key, value []byte) ([]byte, []byte, error) {
+	if o.minSize <= 0 || len(value) < o.minSize {
+		return key, value, nil
+	}
+	seq, typ, _ := internal.ExtractTrailer(key)
+	if typ != internal.RecordTypeValue {
+		return key, value, nil
+	}
+	ref, err := o.add(key, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return internal.EncodeInternalKey(internal.ExtractUserKey(key), seq, internal.RecordTypeBlobIndex), ref, nil
+}
+
+// relocate copies the value behind a blob index entry into the blob file
+// and returns its new index.
+func (o *blobOutput) relocate(key, ref []byte) ([]byte, error) {
+	value, err := o.db.blobs.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return o.add(key, value)
+}
+
+// add writes value to the blob file and returns its encoded index.
+func (o *blobOutput) add(key, value []byte) ([]byte, error) {
+	if o.w == nil {
+		o.db.mu.Lock()
+		o.fileNum = o.db.nextFileNum
+		o.db.nextFileNum++
+		o.db.mu.Unlock()
+
+		w, err := blob.NewWriter(blobFileName(o.db.dir, o.fileNum), o.fileNum)
+		if err != nil {
+			return nil, err
+		}
+		o.w = w
+	}
+	idx, err := o.w.Add(internal.ExtractUserKey(key), value)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Encode(), nil
+}
+
+// finish closes the blob file. It returns nil if nothing was written.
+func (o *blobOutput) finish() (*BlobFileMeta, error) {
+	if o.w == nil {
+		return nil, nil
+	}
+	if err := o.w.Close(); err != nil {
+		return nil, err
+	}
+	return &BlobFileMeta{
+		FileNum: o.fileNum,
+		Count:   o.w.Count(),
+		Size:    o.w.Size(),
+	}, nil
+}
+
+// abandon deletes the blob file of an output that will not be recorded.
+func (o *blobOutput) abandon() {
+	if o.w == nil {
+		return
+	}
+	o.w.Close()
+	os.Remove(blobFileName(o.db.dir, o.fileNum))
+}
+
+// blobGarbageOf returns the garbage left behind when the blob index entry
+// (key, value) is dropped or relocated. ok is false for other entries.
+func blobGarbageOf(key, value []byte) (g BlobGarbage, ok bool) {
+	if _, typ, _ := internal.ExtractTrailer(key); typ != internal.RecordTypeBlobIndex {
+		return BlobGarbage{}, false
+	}
+	idx, err := blob.DecodeIndex(value)
+	if err != nil {
+		return BlobGarbage{}, false
+	}
+	return BlobGarbage{FileNum: idx.FileNum, Count: 1, Size: idx.Length}, true
+}
+
+func addBlobFileRecord(cf *columnFamily, meta BlobFileMeta) manifest.Record {
+	return manifest.Record{
+		Type: manifest.RecordTypeAddBlobFile,
+		Data: manifest.AddBlobFile{
+			FileNum:      meta.FileNum,
+			Count:        meta.Count,
+			Size:         meta.Size,
+			ColumnFamily: cf.id,
+		},
+	}
+}
+
+func blobGarbageRecord(cf *columnFamily, g BlobGarbage) manifest.Record {
+	return manifest.Record{
+		Type: manifest.RecordTypeBlobGarbage,
+		Data: manifest.BlobGarbage{
+			FileNum:      g.FileNum,
+			Count:        g.Count,
+			Size:         g.Size,
+			ColumnFamily: cf.id,
+		},
+	}
+}
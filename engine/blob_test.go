@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func openBlobDB(t *testing.T, dir string, gcRatio float64) *DB {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.MinBlobSize = 1024
+	cfg.BlobGCLiveRatio = gcRatio
+	db, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func largeValue(key string) []byte {
+	return bytes.Repeat([]byte(key), 4096/len(key))
+}
+
+func expectLarge(t *testing.T, db *DB, key string) {
+	t.Helper()
+	v, err := db.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if !bytes.Equal(v, largeValue(key)) {
+		t.Fatalf("Get(%s): value mismatch", key)
+	}
+}
+
+func TestBlobSeparation(t *testing.T) {
+	dir := t.TempDir()
+	db := openBlobDB(t, dir, 0)
+
+	for i := 0; i < 4; i++ {
+		k := fmt.Sprintf("big%d", i)
+		db.Put([]byte(k), largeValue(k))
+	}
+	db.Put([]byte("small"), []byte("v"))
+	db.freezeMemtable()
+
+	blobs := db.version.BlobFiles()
+	if len(blobs) != 1 || blobs[0].Count != 4 {
+		t.Fatalf("expected one blob file with 4 records, got %+v", blobs)
+	}
+	if _, err := os.Stat(blobFileName(dir, blobs[0].FileNum)); err != nil {
+		t.Fatal(err)
+	}
+	tableSize := db.version.GetAllTables()[0].FileSize
+	if tableSize >= 4096 {
+		t.Fatalf("expected values kept out of the table, table is %d bytes", tableSize)
+	}
+
+	// Compaction moves only the references.
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	after := db.version.BlobFiles()
+	if len(after) != 1 || after[0] != blobs[0] {
+		t.Fatalf("expected blob file untouched by compaction, got %+v", after)
+	}
+
+	for i := 0; i < 4; i++ {
+		expectLarge(t, db, fmt.Sprintf("big%d", i))
+	}
+	expectValue(t, db, "small", "v")
+
+	it := db.NewIterator(nil)
+	n := 0
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if k := string(it.Key()); k != "small" && !bytes.Equal(it.Value(), largeValue(k)) {
+			t.Fatalf("iterator: value mismatch for %s", k)
+		}
+		n++
+	}
+	if err := it.Close(); err != nil || n != 5 {
+		t.Fatalf("iterator saw %d keys, err %v", n, err)
+	}
+
+	db.Close()
+	db = openBlobDB(t, dir, 0)
+	defer db.Close()
+	expectLarge(t, db, "big2")
+}
+
+func TestBlobGarbageCollection(t *testing.T) {
+	dir := t.TempDir()
+	db := openBlobDB(t, dir, 0.5)
+
+	for i := 0; i < 4; i++ {
+		k := fmt.Sprintf("k%d", i)
+		db.Put([]byte(k), largeValue(k))
+	}
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	old := db.version.BlobFiles()[0]
+
+	// Bottom-level tombstones drop three of the four references.
+	for i := 0; i < 3; i++ {
+		db.Delete([]byte(fmt.Sprintf("k%d", i)))
+	}
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	blobs := db.version.BlobFiles()
+	if len(blobs) != 1 || blobs[0].GarbageCount != 3 {
+		t.Fatalf("expected 3 garbage records, got %+v", blobs)
+	}
+
+	// The next compaction over k3 relocates it out of the sparse file.
+	db.Put([]byte("a"), []byte("v"))
+	db.Put([]byte("z"), []byte("v"))
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs = db.version.BlobFiles()
+	if len(blobs) != 1 || blobs[0].FileNum == old.FileNum || blobs[0].Count != 1 {
+		t.Fatalf("expected k3 relocated to a new blob file, got %+v", blobs)
+	}
+	if _, err := os.Stat(blobFileName(dir, old.FileNum)); !os.IsNotExist(err) {
+		t.Fatalf("expected old blob file deleted, got %v", err)
+	}
+	expectLarge(t, db, "k3")
+
+	db.Close()
+	db = openBlobDB(t, dir, 0.5)
+	defer db.Close()
+	expectLarge(t, db, "k3")
+	if _, err := db.Get([]byte("k0")); err != ErrNotFound {
+		t.Fatalf("expected k0 deleted, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"vern_kv0.8/manifest"
+	"vern_kv0.8/wal"
+)
+
+// ErrCheckpointExists is returned when the checkpoint directory already exists.
+var ErrCheckpointExists = errors.New("checkpoint directory already exists")
+
+// Checkpoint writes a consistent copy of the DB to dir, which must not exist.
+// The copy opens like any DB, with the same Config. Writes continue meanwhile:
+// it holds every write acknowledged before the call, and possibly some made
+// during it.
+//
+// Memtables are flushed first, then tables and blob files are hard-linked,
+// falling back to copies across filesystems, and the WAL segments not yet
+// covered by flushed tables are copied.
+func (db *DB) Checkpoint(dir string) error {
+	if err := db.checkBackgroundError(); err != nil {
+		return err
+	}
+	if db.primaryDir != "" {
+		return ErrReadOnly
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return ErrCheckpointExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Flush every family, so little of the WAL is needed.
+	db.mu.Lock()
+	for _, cf := range db.liveFamiliesLocked() {
+		if !cf.memtable.Empty() {
+			db.rotateMemtableLocked(cf)
+		}
+	}
+	db.mu.Unlock()
+	db.MaybeScheduleFlush()
+	if err := db.checkBackgroundError(); err != nil {
+		return err
+	}
+
+	// Flushes truncate the WAL; hold them off until the segments are copied.
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+
+	db.mu.Lock()
+	records := db.manifestSnapshotLocked()
+	walCutoff := db.walCutoffLocked()
+	var versions []*Version
+	for _, cf := range db.liveFamiliesLocked() {
+		versions = append(versions, cf.version.Current())
+	}
+	db.mu.Unlock()
+	defer func() {
+		for _, ver := range versions {
+			db.releaseVersion(ver)
+		}
+	}()
+
+	if err := db.writeCheckpoint(dir, records, versions, walCutoff); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// writeCheckpoint fills dir with the files of the pinned versions, the WAL
+// past walCutoff and, last, a manifest of records.
+func (db *DB) writeCheckpoint(dir string, records []manifest.Record, versions []*Version, walCutoff uint64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, ver := range versions {
+		for _, meta := range ver.AllTables() {
+			name := fmt.Sprintf("%06d.sst", meta.FileNum)
+			if err := linkOrCopy(filepath.Join(db.dir, name), filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		for fileNum := range ver.Blobs {
+			if err := linkOrCopy(blobFileName(db.dir, fileNum), blobFileName(dir, fileNum)); err != nil {
+				return err
+			}
+		}
+	}
+
+	walDir := filepath.Join(db.dir, db.opts.WalDir)
+	if err := wal.CopyLiveSegments(walDir, filepath.Join(dir, db.opts.WalDir), walCutoff); err != nil {
+		return err
+	}
+
+	// Without a manifest the directory is not a DB, so an interrupted
+	// checkpoint is never mistaken for a complete one.
+	if err := manifest.Rewrite(filepath.Join(dir, "MANIFEST"), records); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// linkOrCopy hard-links src to dst, copying it when linking fails.
+// Tables and blob files are immutable, so sharing them is safe.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
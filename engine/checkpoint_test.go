@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.MinBlobSize = 1024
+	db, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+	}
+	db.freezeMemtable()
+	big := bytes.Repeat([]byte("b"), 2048)
+	db.Put([]byte("big"), big)
+	db.DeleteRange([]byte("k010"), []byte("k020"))
+	db.PutCF(users, []byte("alice"), []byte("1"))
+
+	ckpt := filepath.Join(t.TempDir(), "ckpt")
+	if err := db.Checkpoint(ckpt); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(ckpt); err != ErrCheckpointExists {
+		t.Fatalf("expected ErrCheckpointExists, got %v", err)
+	}
+
+	// Tables are shared, not copied.
+	for _, meta := range db.version.GetAllTables() {
+		name := fmt.Sprintf("%06d.sst", meta.FileNum)
+		a, _ := os.Stat(filepath.Join(dir, name))
+		b, err := os.Stat(filepath.Join(ckpt, name))
+		if err != nil || !os.SameFile(a, b) {
+			t.Fatalf("expected %s hard-linked (%v)", name, err)
+		}
+	}
+
+	// Later writes stay out of the checkpoint.
+	db.Put([]byte("after"), []byte("x"))
+	db.Delete([]byte("k000"))
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(ckpt, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	expectValue(t, c, "k000", "v")
+	expectValue(t, c, "k099", "v")
+	expectValue(t, c, "big", string(big))
+	if _, err := c.Get([]byte("k015")); err != ErrNotFound {
+		t.Fatalf("expected k015 deleted, got %v", err)
+	}
+	if _, err := c.Get([]byte("after")); err != ErrNotFound {
+		t.Fatalf("expected no later write, got %v", err)
+	}
+	h, err := c.ColumnFamily("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetCF(h, []byte("alice"), nil); err != nil || string(v) != "1" {
+		t.Fatalf("expected alice in users, got %q (%v)", v, err)
+	}
+
+	// The checkpoint is a DB of its own.
+	c.Put([]byte("k050"), []byte("ckpt"))
+	expectValue(t, db, "k050", "v")
+}
+
+func TestCheckpointDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("k%06d", i)), []byte("v"))
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			db.Put([]byte(fmt.Sprintf("k%06d", i)), []byte("v"))
+		}
+	}()
+
+	ckpt := filepath.Join(t.TempDir(), "ckpt")
+	err = db.Checkpoint(ckpt)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(ckpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A single writer's keys must survive as a prefix.
+	n := 0
+	it := c.NewIterator(nil)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if want := fmt.Sprintf("k%06d", n); string(it.Key()) != want {
+			t.Fatalf("expected %s, got %s", want, it.Key())
+		}
+		n++
+	}
+	it.Close()
+	if n < 100 {
+		t.Fatalf("expected the writes made before the checkpoint, got %d keys", n)
+	}
+}
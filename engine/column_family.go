@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"errors"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/manifest"
+	"vern_kv0.8/memtable"
+)
+
+// DefaultColumnFamilyName names the column family every DB starts with.
+const DefaultColumnFamilyName = "default"
+
+const defaultColumnFamilyID uint32 = 0
+
+var (
+	ErrColumnFamilyExists   = errors.New("column family already exists")
+	ErrColumnFamilyNotFound = errors.New("column family not found")
+	ErrColumnFamilyDropped  = errors.New("column family dropped")
+	ErrDropDefaultFamily    = errors.New("cannot drop the default column family")
+)
+
+// columnFamily is one keyspace inside a DB.
+// Families share the WAL, MANIFEST, sequence numbers and table cache,
+// but each has its own memtables, levels and tuning.
+type columnFamily struct {
+	id   uint32
+	name string
+	opts *Config
+	cmp  internal.Comparator // Orders the family's keys, in memory and in its tables.
+
+	memtable   *memtable.Memtable
+	immutables []*memtable.Memtable
+	version    *VersionSet
+
+	// dropped is set once DropColumnFamily commits. Guarded by DB.mu.
+	// A dropped family lingers until pinned versions release its files.
+	dropped bool
+}
+
+func newColumnFamily(id uint32, name string, opts *Config, cmp internal.Comparator, vs *VersionSet, mt *memtable.Memtable) *columnFamily {
+	return &columnFamily{
+		id:         id,
+		name:       name,
+		opts:       opts,
+		cmp:        cmp,
+		memtable:   mt,
+		immutables: make([]*memtable.Memtable, 0),
+		version:    vs,
+	}
+}
+
+// hasUnflushedData reports whether some of the family's writes exist only in the WAL.
+func (cf *columnFamily) hasUnflushedData() bool {
+	return len(cf.immutables) > 0 || !cf.memtable.Empty()
+}
+
+// memtablesLocked returns the family's memtables, newest first. Caller holds db.mu.
+func (cf *columnFamily) memtablesLocked() []*memtable.Memtable {
+	mems := make([]*memtable.Memtable, 0, len(cf.immutables)+1)
+	mems = append(mems, cf.memtable)
+	for i := len(cf.immutables) - 1; i >= 0; i-- {
+		mems = append(mems, cf.immutables[i])
+	}
+	return mems
+}
+
+// ColumnFamilyHandle refers to a column family.
+// Handles stay usable until the family is dropped.
+type ColumnFamilyHandle struct {
+	cf *columnFamily
+}
+
+// ID returns the family's ID, as recorded in the WAL and MANIFEST.
+func (h *ColumnFamilyHandle) ID() uint32 {
+	return h.cf.id
+}
+
+// Name returns the family's name.
+func (h *ColumnFamilyHandle) Name() string {
+	return h.cf.name
+}
+
+// DefaultColumnFamily returns the handle of the default family.
+func (db *DB) DefaultColumnFamily() *ColumnFamilyHandle {
+	return &ColumnFamilyHandle{cf: db.columnFamily}
+}
+
+// ColumnFamily returns the handle of the live family called name.
+func (db *DB) ColumnFamily(name string) (*ColumnFamilyHandle, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if cf := db.familyByNameLocked(name); cf != nil {
+		return &ColumnFamilyHandle{cf: cf}, nil
+	}
+	return nil, ErrColumnFamilyNotFound
+}
+
+// ColumnFamilies lists the names of all live families.
+func (db *DB) ColumnFamilies() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var names []string
+	for _, cf := range db.liveFamiliesLocked() {
+		names = append(names, cf.name)
+	}
+	return names
+}
+
+// CreateColumnFamily adds a new, empty family.
+// cfg sets the family's comparator and tunes its memtable, compaction and
+// merge operator; nil uses the DB's Config. DB-wide settings such as WalDir
+// and FilterPolicy are ignored. The comparator is recorded in the MANIFEST,
+// so reopening the DB needs the same one in ColumnFamilyConfigs.
+func (db *DB) CreateColumnFamily(name string, cfg *Config) (*ColumnFamilyHandle, error) {
+	if err := db.checkBackgroundError(); err != nil {
+		return nil, err
+	}
+	if db.primaryDir != "" {
+		return nil, ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.familyByNameLocked(name) != nil {
+		return nil, ErrColumnFamilyExists
+	}
+	if cfg == nil {
+		cfg = db.opts
+	}
+	if err := cfg.checkCompression(); err != nil {
+		return nil, err
+	}
+
+	cmp := newComparator(cfg.Comparator)
+
+	id := db.nextFamilyID
+	rec := manifest.Record{
+		Type: manifest.RecordTypeCreateColumnFamily,
+		Data: manifest.CreateColumnFamily{ID: id, Name: name, Comparator: cmp.Name()},
+	}
+	if err := db.manifest.Append(rec); err != nil {
+		return nil, err
+	}
+	db.nextFamilyID++
+
+	cf := newColumnFamily(id, name, cfg, cmp, newVersionSet(cmp), memtable.NewWithComparator(cmp))
+	db.families[id] = cf
+	return &ColumnFamilyHandle{cf: cf}, nil
+}
+
+// DropColumnFamily removes a family and all of its data.
+// Its tables are deleted once no iterator or snapshot pins them.
+func (db *DB) DropColumnFamily(h *ColumnFamilyHandle) error {
+	if h.cf.id == defaultColumnFamilyID {
+		return ErrDropDefaultFamily
+	}
+	if db.primaryDir != "" {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	cf := h.cf
+	if cf.dropped {
+		db.mu.Unlock()
+		return ErrColumnFamilyDropped
+	}
+
+	rec := manifest.Record{
+		Type: manifest.RecordTypeDropColumnFamily,
+		Data: manifest.DropColumnFamily{ID: cf.id},
+	}
+	if err := db.manifest.Append(rec); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	cf.dropped = true
+
+	// Unflushed data is simply forgotten.
+	cf.immutables = nil
+
+	err := cf.version.Apply(cf.version.dropEdit())
+	db.mu.Unlock()
+
+	db.cleanupObsoleteFiles()
+	return err
+}
+
+// familyComparator returns the comparator of family id,
+// or the DB's when there is no such family.
+func (db *DB) familyComparator(id uint32) internal.Comparator {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if cf, ok := db.families[id]; ok {
+		return cf.cmp
+	}
+	return db.cmp
+}
+
+// familyForLocked resolves a handle to a live family. Caller holds db.mu.
+func (db *DB) familyForLocked(h *ColumnFamilyHandle) (*columnFamily, error) {
+	if h == nil {
+		return db.columnFamily, nil
+	}
+	if h.cf.dropped {
+		return nil, ErrColumnFamilyDropped
+	}
+	return h.cf, nil
+}
+
+// familyByIDLocked returns the live family with id, or nil.
+func (db *DB) familyByIDLocked(id uint32) *columnFamily {
+	cf, ok := db.families[id]
+	if !ok || cf.dropped {
+		return nil
+	}
+	return cf
+}
+
+// familyByNameLocked returns the live family called name, or nil.
+func (db *DB) familyByNameLocked(name string) *columnFamily {
+	for _, cf := range db.families {
+		if !cf.dropped && cf.name == name {
+			return cf
+		}
+	}
+	return nil
+}
+
+// liveFamiliesLocked returns every family not yet dropped, default first.
+func (db *DB) liveFamiliesLocked() []*columnFamily {
+	out := []*columnFamily{db.columnFamily}
+	for id := uint32(1); id < db.nextFamilyID; id++ {
+		if cf := db.familyByIDLocked(id); cf != nil {
+			out = append(out, cf)
+		}
+	}
+	return out
+}
+
+// allFamiliesLocked returns every family, dropped ones included.
+func (db *DB) allFamiliesLocked() []*columnFamily {
+	out := make([]*columnFamily, 0, len(db.families))
+	for id := uint32(0); id < db.nextFamilyID; id++ {
+		if cf, ok := db.families[id]; ok {
+			out = append(out, cf)
+		}
+	}
+	return out
+}
+
+// walCutoffLocked returns the newest sequence whose WAL records are no longer needed:
+// every live family has flushed its writes up to it.
+func (db *DB) walCutoffLocked() uint64 {
+	cutoff := db.nextSeq - 1
+	for _, cf := range db.liveFamiliesLocked() {
+		if cf.hasUnflushedData() && cf.version.WALCutoffSeq < cutoff {
+			cutoff = cf.version.WALCutoffSeq
+		}
+	}
+	return cutoff
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rotateFamily queues h's active memtable for flush.
+func rotateFamily(db *DB, h *ColumnFamilyHandle) {
+	db.mu.Lock()
+	db.rotateMemtableLocked(h.cf)
+	db.mu.Unlock()
+}
+
+// flushFamily moves h's active memtable to disk.
+func flushFamily(db *DB, h *ColumnFamilyHandle) {
+	rotateFamily(db, h)
+	db.MaybeScheduleFlush()
+}
+
+func TestColumnFamilyIsolation(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateColumnFamily("users", nil); err != ErrColumnFamilyExists {
+		t.Fatalf("expected ErrColumnFamilyExists, got %v", err)
+	}
+
+	if err := db.Put([]byte("k"), []byte("default")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutCF(users, []byte("k"), []byte("users")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutCF(users, []byte("only-users"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	flushFamily(db, users)
+
+	if v, err := db.Get([]byte("k")); err != nil || string(v) != "default" {
+		t.Fatalf("default Get: %q, %v", v, err)
+	}
+	if v, err := db.GetCF(users, []byte("k"), nil); err != nil || string(v) != "users" {
+		t.Fatalf("users Get: %q, %v", v, err)
+	}
+	if _, err := db.Get([]byte("only-users")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound in default family, got %v", err)
+	}
+
+	it, err := db.NewIteratorCF(users, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "k" || keys[1] != "only-users" {
+		t.Fatalf("unexpected users keys: %v", keys)
+	}
+}
+
+func TestColumnFamilyAtomicWrite(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	meta, err := db.CreateColumnFamily("meta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.PutCF(meta, []byte("a"), []byte("2"))
+	batch.PutCF(&ColumnFamilyHandle{cf: &columnFamily{id: 99}}, []byte("b"), []byte("3"))
+	if err := db.Write(batch); err != ErrColumnFamilyNotFound {
+		t.Fatalf("expected ErrColumnFamilyNotFound, got %v", err)
+	}
+	if _, err := db.Get([]byte("a")); err != ErrNotFound {
+		t.Fatalf("rejected batch was partially applied: %v", err)
+	}
+
+	batch.Clear()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.PutCF(meta, []byte("a"), []byte("2"))
+	if err := db.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("default Get: %q, %v", v, err)
+	}
+	if v, err := db.GetCF(meta, []byte("a"), nil); err != nil || string(v) != "2" {
+		t.Fatalf("meta Get: %q, %v", v, err)
+	}
+}
+
+func TestColumnFamilyReopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flushed, err := db.CreateColumnFamily("flushed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged, err := db.CreateColumnFamily("logged", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.PutCF(flushed, []byte("f"), []byte("on-disk"))
+	flushFamily(db, flushed)
+	db.PutCF(logged, []byte("l"), []byte("in-wal"))
+	db.Put([]byte("d"), []byte("default"))
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	names := db.ColumnFamilies()
+	if len(names) != 3 || names[0] != DefaultColumnFamilyName || names[1] != "flushed" || names[2] != "logged" {
+		t.Fatalf("unexpected families: %v", names)
+	}
+
+	flushed, _ = db.ColumnFamily("flushed")
+	logged, _ = db.ColumnFamily("logged")
+	if v, err := db.GetCF(flushed, []byte("f"), nil); err != nil || string(v) != "on-disk" {
+		t.Fatalf("flushed Get: %q, %v", v, err)
+	}
+	if v, err := db.GetCF(logged, []byte("l"), nil); err != nil || string(v) != "in-wal" {
+		t.Fatalf("logged Get: %q, %v", v, err)
+	}
+	if v, err := db.Get([]byte("d")); err != nil || string(v) != "default" {
+		t.Fatalf("default Get: %q, %v", v, err)
+	}
+}
+
+func TestDropColumnFamily(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := db.CreateColumnFamily("tmp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.PutCF(tmp, []byte("k"), []byte("v"))
+	flushFamily(db, tmp)
+	tables := tmp.cf.version.GetAllTables()
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(tables))
+	}
+	path := filepath.Join(dir, "000001.sst")
+	if tables[0].FileNum != 1 {
+		t.Fatalf("unexpected file number %d", tables[0].FileNum)
+	}
+
+	if err := db.DropColumnFamily(db.DefaultColumnFamily()); err != ErrDropDefaultFamily {
+		t.Fatalf("expected ErrDropDefaultFamily, got %v", err)
+	}
+	if err := db.DropColumnFamily(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("dropped family's table still exists: %v", err)
+	}
+	if err := db.PutCF(tmp, []byte("k"), []byte("v")); err != ErrColumnFamilyDropped {
+		t.Fatalf("expected ErrColumnFamilyDropped, got %v", err)
+	}
+	if _, err := db.GetCF(tmp, []byte("k"), nil); err != ErrColumnFamilyDropped {
+		t.Fatalf("expected ErrColumnFamilyDropped, got %v", err)
+	}
+
+	// The name is free again, but the new family starts empty.
+	again, err := db.CreateColumnFamily("tmp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID() == tmp.ID() {
+		t.Fatal("family ID reused")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	again, err = db.ColumnFamily("tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetCF(again, []byte("k"), nil); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after reopen, got %v", err)
+	}
+}
@@ -18,20 +18,33 @@ func (db *DB) PickCompaction() (int, bool) {
 	return db.version.PickCompaction(db.opts.L0CompactionTrigger, db.opts.L1MaxBytes)
 }
 
-// Run compaction for this level.
+// Run compaction for this level of the default column family.
 func (db *DB) CompactLevel(level int) error {
+	return db.compactLevel(db.columnFamily, level)
+}
+
+// compactLevel compacts one level of cf.
+func (db *DB) compactLevel(cf *columnFamily, level int) error {
+	if db.primaryDir != "" {
+		return ErrReadOnly
+	}
 	if level >= NumLevels-1 {
 		return fmt.Errorf("cannot compact max level")
 	}
 
 	// Pick inputs (hold lock).
+	// The pinned version keeps the inputs on disk while they are merged.
 	db.mu.Lock()
 	oldestSnapshotSeq := db.getOldestSnapshotSeq()
+	snapshots := db.snapshotSeqs()
+	now := db.now().UnixNano()
+	ver := cf.version.Current()
+	defer db.releaseVersion(ver)
 	var inputs []SSTableMeta
 
 	if level == 0 {
 		// L0 to L1.
-		l0 := db.version.Levels[0]
+		l0 := ver.Levels[0]
 		if len(l0) == 0 {
 			db.mu.Unlock()
 			return nil
@@ -43,8 +56,6 @@ func (db *DB) CompactLevel(level int) error {
 		// Find L0 range.
 		var smallest, largest []byte
 		first := true
-		cmp := internal.Comparator{}
-
 		for _, f := range l0 {
 			if first {
 				smallest = f.SmallestKey
@@ -52,20 +63,20 @@ func (db *DB) CompactLevel(level int) error {
 				first = false
 				continue
 			}
-			if cmp.Compare(f.SmallestKey, smallest) < 0 {
+			if cf.cmp.Compare(f.SmallestKey, smallest) < 0 {
 				smallest = f.SmallestKey
 			}
-			if cmp.Compare(f.LargestKey, largest) > 0 {
+			if cf.cmp.Compare(f.LargestKey, largest) > 0 {
 				largest = f.LargestKey
 			}
 		}
 
 		// Get L1 overlaps.
-		l1 := db.version.GetOverlappingInputs(1, smallest, largest)
+		l1 := ver.OverlappingInputs(1, smallest, largest)
 		inputs = append(inputs, l1...)
 	} else {
 		// Standard level compaction.
-		currentLevel := db.version.Levels[level]
+		currentLevel := ver.Levels[level]
 		if len(currentLevel) == 0 {
 			db.mu.Unlock()
 			return nil
@@ -74,24 +85,37 @@ func (db *DB) CompactLevel(level int) error {
 		inputs = append(inputs, picked)
 
 		// Grab overlaps from next level.
-		overlaps := db.version.GetOverlappingInputs(level+1, picked.SmallestKey, picked.LargestKey)
+		overlaps := ver.OverlappingInputs(level+1, picked.SmallestKey, picked.LargestKey)
 		inputs = append(inputs, overlaps...)
 	}
 
+	// Blob files sparse enough to be rewritten.
+	gcBlobs := make(map[uint64]bool)
+	if ratio := cf.opts.BlobGCLiveRatio; ratio > 0 {
+		for fileNum, meta := range ver.Blobs {
+			if meta.LiveRatio() < ratio {
+				gcBlobs[fileNum] = true
+			}
+		}
+	}
+
 	db.mu.Unlock()
 
 	// Spin up iterators.
 	var iters []iterators.InternalIterator
+	var tombstones []internal.RangeTombstone
 	for _, meta := range inputs {
-		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
-		sstIt, err := sstable.NewIterator(path, db.cache)
+		sstIt, release, err := db.tableCache.NewIterator(meta.FileNum, cf.cmp)
 		if err != nil {
 			return err
 		}
+		defer release()
 		iters = append(iters, sstIt)
+		tombstones = append(tombstones, sstIt.RangeTombstones()...)
 	}
 
 	merge := iterators.NewMergeIterator(iters, false)
+	merge.SetComparator(cf.cmp)
 	merge.SeekToFirst()
 
 	// Next level down.
@@ -102,6 +126,20 @@ func (db *DB) CompactLevel(level int) error {
 
 	var newFiles []SSTableMeta
 
+	// Large values are separated into one blob file per compaction.
+	// Blob index entries that are dropped or relocated leave garbage behind.
+	blobs := db.newBlobOutput(cf)
+	garbage := make(map[uint64]BlobGarbage)
+	dropBlob := func(key, value []byte) {
+		if g, ok := blobGarbageOf(key, value); ok {
+			acc := garbage[g.FileNum]
+			acc.FileNum = g.FileNum
+			acc.Count += g.Count
+			acc.Size += g.Size
+			garbage[g.FileNum] = acc
+		}
+	}
+
 	// Out file state.
 	var (
 		builder     *sstable.Builder
@@ -109,11 +147,27 @@ func (db *DB) CompactLevel(level int) error {
 		first       bool
 	)
 
+	// Out file key range, in user keys; nil is unbounded.
+	// Range tombstones are clipped to it so outputs stay disjoint.
+	var lower []byte
+
+	// Range tombstones written to outputs.
+	var kept []internal.RangeTombstone
+
 	// Close current file.
-	finishFile := func() error {
+	finishFile := func(upper []byte) error {
 		if builder == nil {
 			return nil
 		}
+		var clipped []internal.RangeTombstone
+		for _, t := range kept {
+			if t, ok := clipRangeTombstone(cf.cmp, t, lower, upper); ok {
+				clipped = append(clipped, t)
+			}
+		}
+		if err := addRangeTombstones(cf.cmp, builder, &currentMeta, clipped); err != nil {
+			return err
+		}
 		if err := builder.Close(); err != nil {
 			return err
 		}
@@ -130,17 +184,21 @@ func (db *DB) CompactLevel(level int) error {
 	}
 
 	// Make new file.
-	startFile := func() error {
+	startFile := func(start []byte) error {
 		db.mu.Lock()
 		fileNum := db.nextFileNum
 		db.nextFileNum++
 		db.mu.Unlock()
 
 		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", fileNum))
-		b, err := sstable.NewBuilder(path)
+		b, err := sstable.NewBuilder(path, db.builderOptions(cf, targetLevel))
 		if err != nil {
 			return err
 		}
+		b.SetComparator(cf.cmp)
+		if p := cf.opts.PrefixExtractor; p != nil {
+			b.SetPrefixExtractor(p)
+		}
 
 		builder = b
 		currentMeta = SSTableMeta{
@@ -149,60 +207,26 @@ func (db *DB) CompactLevel(level int) error {
 			SmallestSeq: math.MaxUint64,
 		}
 		first = true
+		lower = start
 		return nil
 	}
 
 	// First file.
-	if err := startFile(); err != nil {
+	if err := startFile(nil); err != nil {
 		return err
 	}
 
-	for merge.Valid() {
-		key := merge.Key()
-		val := merge.Value()
-
-		// Too big? Rotate.
-		if builder.Size() >= 20*1024*1024 { // 20MB split.
-			if err := finishFile(); err != nil {
-				return err
-			}
-			if err := startFile(); err != nil {
-				return err
-			}
-		}
-
-		seq, typ, _ := internal.ExtractTrailer(key)
-
-		// GC Tombstones.
-		// Drop if bottom-most AND invisible to snapshots.
-		if typ == internal.RecordTypeTombstone && seq <= oldestSnapshotSeq {
-			isBottom := true
-			for l := targetLevel + 1; l < NumLevels; l++ {
-				if len(db.version.Levels[l]) > 0 {
-					// Has overlap below? Keep it.
-					isBottom = false
-					break
-				}
-			}
-			if isBottom {
-				// Safe to drop.
-				// Also skip shadowed versions.
-				userKey := internal.ExtractUserKey(key)
-				for {
-					merge.Next()
-					if !merge.Valid() {
-						break
-					}
-					nextKey := internal.ExtractUserKey(merge.Key())
-					if !bytes.Equal(userKey, nextKey) {
-						break
-					}
-					// Drop shadowed.
-				}
-				continue
-			}
+	// Nothing below the output level? Then no older versions exist elsewhere.
+	isBottom := true
+	for l := targetLevel + 1; l < NumLevels; l++ {
+		if len(ver.Levels[l]) > 0 {
+			isBottom = false
+			break
 		}
+	}
 
+	// Write one entry to the current output.
+	emit := func(key, val []byte) error {
 		if err := builder.Add(key, val); err != nil {
 			return err
 		}
@@ -216,17 +240,104 @@ func (db *DB) CompactLevel(level int) error {
 		copy(currentMeta.LargestKey, key)
 
 		// Update seq bounds.
+		seq, _, _ := internal.ExtractTrailer(key)
 		if seq < currentMeta.SmallestSeq {
 			currentMeta.SmallestSeq = seq
 		}
 		if seq > currentMeta.LargestSeq {
 			currentMeta.LargestSeq = seq
 		}
+		return nil
+	}
+
+	// A range tombstone drops the versions it covers within its snapshot stripe.
+	// Bottom-most tombstones invisible to snapshots have nothing left to cover.
+	covered := func(userKey []byte, seq uint64) bool {
+		for _, t := range tombstones {
+			if t.Covers(cf.cmp, userKey, seq) && snapshotStripe(t.Seq, snapshots) == snapshotStripe(seq, snapshots) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range tombstones {
+		if isBottom && t.Seq <= oldestSnapshotSeq {
+			continue
+		}
+		kept = append(kept, t)
+	}
 
-		merge.Next()
+	for merge.Valid() {
+		// Gather every version of this user key, newest first.
+		userKey := append([]byte(nil), internal.ExtractUserKey(merge.Key())...)
+		var entries []compactionEntry
+		for merge.Valid() && bytes.Equal(internal.ExtractUserKey(merge.Key()), userKey) {
+			seq, _, _ := internal.ExtractTrailer(merge.Key())
+			if covered(userKey, seq) {
+				dropBlob(merge.Key(), merge.Value())
+			} else {
+				e := compactionEntry{
+					key:   append([]byte(nil), merge.Key()...),
+					value: merge.Value(),
+				}
+				// Expired TTL entries become tombstones, collected like any other.
+				if expired(e.key, e.value, now) {
+					e = compactionEntry{key: expiredAsTombstone(e.key)}
+				}
+				entries = append(entries, e)
+			}
+			merge.Next()
+		}
+
+		// Collapse merge operand stacks.
+		entries = collapseMerges(cf.opts.MergeOperator, entries, snapshots, isBottom)
+
+		// Too big? Rotate. Files split between user keys.
+		if builder.Size() >= 20*1024*1024 { // 20MB split.
+			if err := finishFile(userKey); err != nil {
+				return err
+			}
+			if err := startFile(userKey); err != nil {
+				return err
+			}
+		}
+
+		for i, e := range entries {
+			seq, typ, _ := internal.ExtractTrailer(e.key)
+
+			// GC Tombstones.
+			// Drop if bottom-most AND invisible to snapshots.
+			// Shadowed versions go with it.
+			if typ == internal.RecordTypeTombstone && seq <= oldestSnapshotSeq && isBottom {
+				for _, shadowed := range entries[i+1:] {
+					dropBlob(shadowed.key, shadowed.value)
+				}
+				break
+			}
+
+			key, value := e.key, e.value
+			var err error
+			if g, ok := blobGarbageOf(key, value); ok && gcBlobs[g.FileNum] {
+				value, err = blobs.relocate(key, value)
+				dropBlob(e.key, e.value)
+			} else {
+				key, value, err = blobs.separate(key, value)
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := emit(key, value); err != nil {
+				return err
+			}
+		}
 	}
 
-	if err := finishFile(); err != nil {
+	if err := finishFile(nil); err != nil {
+		return err
+	}
+	blobMeta, err := blobs.finish()
+	if err != nil {
 		return err
 	}
 
@@ -234,16 +345,36 @@ func (db *DB) CompactLevel(level int) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if cf.dropped {
+		// The family went away mid-compaction; its outputs were never recorded.
+		for _, meta := range newFiles {
+			os.Remove(filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum)))
+		}
+		if blobMeta != nil {
+			os.Remove(blobFileName(db.dir, blobMeta.FileNum))
+		}
+		return nil
+	}
+
+	// Log the new blob file before any table refers to it.
+	var versionEdit VersionEdit
+	if blobMeta != nil {
+		if err := db.manifest.Append(addBlobFileRecord(cf, *blobMeta)); err != nil {
+			return err
+		}
+		versionEdit.AddedBlobs = append(versionEdit.AddedBlobs, *blobMeta)
+	}
+
 	// Log deletions.
 	for _, in := range inputs {
 		edit := manifest.Record{
 			Type: manifest.RecordTypeRemoveSSTable,
-			Data: manifest.RemoveSSTable{FileNum: in.FileNum},
+			Data: manifest.RemoveSSTable{FileNum: in.FileNum, ColumnFamily: cf.id},
 		}
 		if err := db.manifest.Append(edit); err != nil {
 			return err
 		}
-		db.version.RemoveTable(in.FileNum)
+		versionEdit.Removed = append(versionEdit.Removed, in.FileNum)
 	}
 
 	// Log additions.
@@ -251,35 +382,51 @@ func (db *DB) CompactLevel(level int) error {
 		edit := manifest.Record{
 			Type: manifest.RecordTypeAddSSTable,
 			Data: manifest.AddSSTable{
-				FileNum:     meta.FileNum,
-				Level:       meta.Level,
-				SmallestKey: meta.SmallestKey,
-				LargestKey:  meta.LargestKey,
-				SmallestSeq: meta.SmallestSeq,
-				LargestSeq:  meta.LargestSeq,
-				FileSize:    meta.FileSize,
+				FileNum:      meta.FileNum,
+				Level:        meta.Level,
+				SmallestKey:  meta.SmallestKey,
+				LargestKey:   meta.LargestKey,
+				SmallestSeq:  meta.SmallestSeq,
+				LargestSeq:   meta.LargestSeq,
+				FileSize:     meta.FileSize,
+				ColumnFamily: cf.id,
 			},
 		}
 		if err := db.manifest.Append(edit); err != nil {
 			return err
 		}
-		if err := db.version.AddTable(meta); err != nil {
+		versionEdit.Added = append(versionEdit.Added, meta)
+	}
+
+	// Log blob garbage.
+	for _, g := range garbage {
+		if err := db.manifest.Append(blobGarbageRecord(cf, g)); err != nil {
 			return err
 		}
+		versionEdit.BlobGarbage = append(versionEdit.BlobGarbage, g)
 	}
 
-	return nil
+	// Install inputs and outputs as one version so readers never see a partial swap.
+	return cf.version.Apply(versionEdit)
 }
 
 func (db *DB) MaybeScheduleCompaction() {
+	// A secondary's tables belong to its primary.
+	if db.primaryDir != "" {
+		return
+	}
 	db.compactionMu.Lock()
 	defer db.compactionMu.Unlock()
+	defer db.signalRoom()
 
 	db.mu.RLock()
-	level, needs := db.PickCompaction()
+	families := db.liveFamiliesLocked()
 	db.mu.RUnlock()
 
-	if needs {
-		db.CompactLevel(level)
+	for _, cf := range families {
+		level, needs := cf.version.PickCompaction(cf.opts.L0CompactionTrigger, cf.opts.L1MaxBytes)
+		if needs {
+			db.compactLevel(cf, level)
+		}
 	}
 }
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"errors"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/manifest"
+)
+
+var ErrComparatorMismatch = errors.New("comparator does not match the one the DB was created with")
+
+// UserComparator orders user keys. See Config.Comparator.
+type UserComparator = internal.UserComparator
+
+// BytewiseComparator orders user keys lexicographically by byte. It is the default.
+var BytewiseComparator = internal.BytewiseComparator
+
+// newComparator wraps user, nil meaning bytewise.
+func newComparator(user UserComparator) internal.Comparator {
+	return internal.Comparator{User: user}
+}
+
+// newFamilyComparators wraps the comparators of column families, by name.
+func newFamilyComparators(users map[string]UserComparator) map[string]internal.Comparator {
+	cmps := make(map[string]internal.Comparator, len(users))
+	for name, user := range users {
+		cmps[name] = newComparator(user)
+	}
+	return cmps
+}
+
+func comparatorRecord(cmp internal.Comparator) manifest.Record {
+	return manifest.Record{
+		Type: manifest.RecordTypeSetComparator,
+		Data: manifest.SetComparator{Name: cmp.Name()},
+	}
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// reverseComparator orders user keys in descending byte order.
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+
+func (reverseComparator) Name() string { return "test.ReverseComparator" }
+
+func (reverseComparator) FindShortestSeparator(start, limit []byte) []byte { return start }
+
+func (reverseComparator) FindShortSuccessor(key []byte) []byte { return key }
+
+func openWithComparator(dir string, cmp UserComparator) (*DB, error) {
+	cfg := DefaultConfig()
+	cfg.Comparator = cmp
+	return Open(dir, cfg)
+}
+
+func TestUserComparator(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openWithComparator(dir, reverseComparator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two disjoint L1 tables, then newer data in L0 and the memtable.
+	for _, from := range []int{100, 0} {
+		for i := from; i < from+100; i++ {
+			db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+		}
+		db.freezeMemtable()
+		if err := db.CompactLevel(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l1 := db.version.LevelFiles(1); len(l1) != 2 || l1[0].FileNum > l1[1].FileNum {
+		t.Fatalf("expected the table of k1xx keys first in L1, got %+v", l1)
+	}
+	db.Put([]byte("k007"), []byte("l0"))
+	db.freezeMemtable()
+	db.Put([]byte("k008"), []byte("mem"))
+
+	// In descending order, [k150, k100) holds k150 down to k101.
+	if err := db.DeleteRange([]byte("k150"), []byte("k100")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRange([]byte("k000"), []byte("k100")); err != ErrInvalidRange {
+		t.Fatalf("expected ErrInvalidRange for an ascending range, got %v", err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		expectValue(t, db, "k007", "l0")
+		expectValue(t, db, "k008", "mem")
+		expectValue(t, db, "k100", "v")
+		expectValue(t, db, "k151", "v")
+		if _, err := db.Get([]byte("k120")); err != ErrNotFound {
+			t.Fatalf("expected k120 deleted, got %v", err)
+		}
+
+		var keys []string
+		it := db.NewRangeIterator([]byte("k152"), []byte("k098"), nil)
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		it.Close()
+		want := []string{"k152", "k151", "k100", "k099"}
+		if fmt.Sprint(keys) != fmt.Sprint(want) {
+			t.Fatalf("expected %v, got %v", want, keys)
+		}
+	}
+	check(db)
+
+	db.Close()
+	if _, err := openWithComparator(dir, nil); err != ErrComparatorMismatch {
+		t.Fatalf("expected ErrComparatorMismatch under the default comparator, got %v", err)
+	}
+	db, err = openWithComparator(dir, reverseComparator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+
+	// The name survives a manifest rewrite.
+	if err := db.CompactManifest(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = openWithComparator(dir, reverseComparator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
+
+func TestComparatorMismatchOnBytewiseDB(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("k"), []byte("v"))
+	db.Close()
+
+	if _, err := openWithComparator(dir, reverseComparator{}); err != ErrComparatorMismatch {
+		t.Fatalf("expected ErrComparatorMismatch, got %v", err)
+	}
+	db, err = openWithComparator(dir, BytewiseComparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expectValue(t, db, "k", "v")
+}
+
+func TestColumnFamilyComparator(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revCfg := DefaultConfig()
+	revCfg.Comparator = reverseComparator{}
+	rev, err := db.CreateColumnFamily("rev", revCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tables in L0 and L1, plus the memtable.
+	for i := 0; i < 20; i++ {
+		db.PutCF(rev, []byte(fmt.Sprintf("k%02d", i)), []byte("v"))
+		db.Put([]byte(fmt.Sprintf("k%02d", i)), []byte("v"))
+	}
+	flushFamily(db, rev)
+	if err := db.compactLevel(rev.cf, 0); err != nil {
+		t.Fatal(err)
+	}
+	db.PutCF(rev, []byte("k05"), []byte("l0"))
+	flushFamily(db, rev)
+	db.PutCF(rev, []byte("k06"), []byte("mem"))
+
+	// In descending order, [k15, k10) holds k15 down to k11.
+	b := NewWriteBatch()
+	b.DeleteRangeCF(rev, []byte("k15"), []byte("k10"))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	b = NewWriteBatch()
+	b.DeleteRangeCF(rev, []byte("k10"), []byte("k15"))
+	if err := db.Write(b); err != ErrInvalidRange {
+		t.Fatalf("expected ErrInvalidRange for an ascending range, got %v", err)
+	}
+
+	check := func(db *DB, rev *ColumnFamilyHandle) {
+		t.Helper()
+		for key, want := range map[string]string{"k05": "l0", "k06": "mem", "k10": "v", "k19": "v"} {
+			if got, err := db.GetCF(rev, []byte(key), nil); err != nil || string(got) != want {
+				t.Fatalf("get %q = %q, %v; want %q", key, got, err, want)
+			}
+		}
+		if _, err := db.GetCF(rev, []byte("k12"), nil); err != ErrNotFound {
+			t.Fatalf("expected k12 deleted, got %v", err)
+		}
+
+		it, err := db.NewIteratorCF(rev, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		it.Close()
+		if len(keys) != 15 || keys[0] != "k19" || keys[len(keys)-1] != "k00" {
+			t.Fatalf("expected k19 down to k00 without k11-k15, got %v", keys)
+		}
+
+		// The default family stays bytewise.
+		first := db.NewIterator(nil)
+		first.SeekToFirst()
+		if !first.Valid() || string(first.Key()) != "k00" {
+			t.Fatalf("expected the default family to start at k00")
+		}
+		first.Close()
+	}
+	check(db, rev)
+
+	// The family's comparator is recorded, even across a manifest rewrite.
+	if err := db.CompactManifest(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := Open(dir); err != ErrComparatorMismatch {
+		t.Fatalf("expected ErrComparatorMismatch without the family's config, got %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.ColumnFamilyConfigs = map[string]*Config{"rev": revCfg}
+	db, err = Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rev, err = db.ColumnFamily("rev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db, rev)
+}
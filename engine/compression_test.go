@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"vern_kv0.8/sstable"
+)
+
+func TestCompressionPerLevel(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CompressionPerLevel = []int{sstable.NoCompression, sstable.LZ4Compression, sstable.SnappyCompression}
+	cfg.BlockSize = 1024
+	cfg.BlockRestartInterval = 8
+	cfg.L0CompactionTrigger = 100
+	db, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("compressible "), 20)
+	for i := 0; i < 500; i++ {
+		db.Put([]byte(fmt.Sprintf("k%04d", i)), value)
+	}
+	db.freezeMemtable()
+
+	l0 := db.version.LevelFiles(0)
+	if len(l0) != 1 {
+		t.Fatalf("expected one L0 table, got %d", len(l0))
+	}
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	l1 := db.version.LevelFiles(1)
+	if len(l1) != 1 {
+		t.Fatalf("expected one L1 table, got %d", len(l1))
+	}
+
+	// L0 is stored raw, L1 with LZ4.
+	if l1[0].FileSize*4 > l0[0].FileSize {
+		t.Fatalf("expected the L1 table compressed, sizes L0 %d, L1 %d", l0[0].FileSize, l1[0].FileSize)
+	}
+	for _, i := range []int{0, 250, 499} {
+		expectValue(t, db, fmt.Sprintf("k%04d", i), string(value))
+	}
+
+	if got := cfg.compressionForLevel(6); got != sstable.SnappyCompression {
+		t.Fatalf("expected levels past the list to use its last entry, got %d", got)
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CompressionType = sstable.ZstdCompression
+	if _, err := Open(t.TempDir(), cfg); err != sstable.ErrUnsupportedCompression {
+		t.Fatalf("expected ErrUnsupportedCompression, got %v", err)
+	}
+
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cf := DefaultConfig()
+	cf.CompressionPerLevel = []int{sstable.NoCompression, 42}
+	if _, err := db.CreateColumnFamily("bad", cf); err != sstable.ErrUnsupportedCompression {
+		t.Fatalf("expected ErrUnsupportedCompression, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/wal"
+)
+
+// ErrConditionFailed is returned when a conditional write finds the key
+// in a state other than the one it required. Nothing is written.
+var ErrConditionFailed = errors.New("write condition not met")
+
+// CompareAndSwap sets key to value if its current value equals expected.
+// A missing key never matches; use PutIfAbsent to create one.
+func (db *DB) CompareAndSwap(key, expected, value []byte) error {
+	return db.writeIf(wal.LogicalRecord{
+		Key:   key,
+		Value: value,
+		Type:  wal.LogicalTypePut,
+	}, func(current []byte, found bool) bool {
+		return found && bytes.Equal(current, expected)
+	})
+}
+
+// PutIfAbsent sets key to value if the key does not exist.
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	return db.writeIf(wal.LogicalRecord{
+		Key:   key,
+		Value: value,
+		Type:  wal.LogicalTypePut,
+	}, func(_ []byte, found bool) bool {
+		return !found
+	})
+}
+
+// DeleteIfEquals deletes key if its current value equals expected.
+func (db *DB) DeleteIfEquals(key, expected []byte) error {
+	return db.writeIf(wal.LogicalRecord{
+		Key:  key,
+		Type: wal.LogicalTypeDelete,
+	}, func(current []byte, found bool) bool {
+		return found && bytes.Equal(current, expected)
+	})
+}
+
+// writeIf writes r if cond accepts the latest visible value of r.Key.
+// The condition is evaluated as the writer's check, under db.mu and in a
+// commit group of its own, so no other write lands between read and apply.
+func (db *DB) writeIf(r wal.LogicalRecord, cond func(current []byte, found bool) bool) error {
+	w := db.newWriter([]wal.LogicalRecord{r}, nil)
+	w.check = func() error {
+		cf, err := db.familyForWriteLocked(r.ColumnFamily)
+		if err != nil {
+			return err
+		}
+		current, err := db.getLatestLocked(cf, r.Key)
+		found := err == nil
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if !cond(current, found) {
+			return ErrConditionFailed
+		}
+		return nil
+	}
+	return db.write(w)
+}
+
+// getLatestLocked reads the latest visible value of key in cf. Caller holds db.mu,
+// which keeps the current version installed while its tables are probed.
+func (db *DB) getLatestLocked(cf *columnFamily, key []byte) ([]byte, error) {
+	ver := cf.version.Current()
+	defer ver.Unref()
+	return db.get(cf, cf.memtablesLocked(), ver, key, internal.MaxSequenceNumber, false)
+}
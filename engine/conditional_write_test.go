@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.PutIfAbsent([]byte("k"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutIfAbsent([]byte("k"), []byte("v2")); err != ErrConditionFailed {
+		t.Fatalf("PutIfAbsent on existing key: expected ErrConditionFailed, got %v", err)
+	}
+	expectValue(t, db, "k", "v1")
+
+	// The condition sees values that were flushed to tables.
+	db.freezeMemtable()
+
+	if err := db.CompareAndSwap([]byte("k"), []byte("wrong"), []byte("v2")); err != ErrConditionFailed {
+		t.Fatalf("CompareAndSwap mismatch: expected ErrConditionFailed, got %v", err)
+	}
+	if err := db.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, "k", "v2")
+
+	if err := db.CompareAndSwap([]byte("missing"), nil, []byte("v")); err != ErrConditionFailed {
+		t.Fatalf("CompareAndSwap on missing key: expected ErrConditionFailed, got %v", err)
+	}
+
+	if err := db.DeleteIfEquals([]byte("k"), []byte("v1")); err != ErrConditionFailed {
+		t.Fatalf("DeleteIfEquals mismatch: expected ErrConditionFailed, got %v", err)
+	}
+	if err := db.DeleteIfEquals([]byte("k"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected k deleted, got %v", err)
+	}
+
+	// A deleted key counts as absent again.
+	if err := db.PutIfAbsent([]byte("k"), []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, "k", "v3")
+}
+
+func TestCompareAndSwapConcurrentIncrements(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("n"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, perWorker = 8, 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for done := 0; done < perWorker; {
+				cur, err := db.Get([]byte("n"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := strconv.Atoi(string(cur))
+				switch err := db.CompareAndSwap([]byte("n"), cur, []byte(strconv.Itoa(n+1))); err {
+				case nil:
+					done++
+				case ErrConditionFailed:
+					// Lost the race; retry with the new value.
+				default:
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	expectValue(t, db, "n", strconv.Itoa(workers*perWorker))
+}
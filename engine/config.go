@@ -1,42 +1,190 @@
 package engine
 
-import "vern_kv0.8/sstable"
+import (
+	"time"
+
+	"vern_kv0.8/sstable"
+	"vern_kv0.8/wal"
+)
 
 // Config holds the configuration for the database.
 type Config struct {
 	// WalDir is the directory for WAL files.
 	WalDir string
 
+	// WALSegmentSize is the size at which the WAL starts a new segment (bytes).
+	// Segments are the unit of truncation and retention. Zero uses 64MB.
+	WALSegmentSize int64
+
+	// WALRetention keeps WAL segments after flushes make them obsolete, so
+	// GetUpdatesSince can still read them. The zero value removes them at once.
+	WALRetention WALRetention
+
 	// MemtableSizeLimit is the size threshold for flushing memtable (bytes).
 	MemtableSizeLimit int
 
 	// CompressionType specifies the block compression algorithm.
 	CompressionType int
 
+	// CompressionPerLevel overrides CompressionType by output level: tables
+	// written to level i use entry i, and levels past the end use the last
+	// entry. For example {None, None, Snappy, Snappy, Snappy, Snappy, Zlib}
+	// keeps flushes cheap and compresses the bottom level hard.
+	CompressionPerLevel []int
+
 	// BlockSize is the size of uncompressed SSTable blocks (bytes).
 	BlockSize int
 
+	// BlockRestartInterval is the number of keys between restart points in
+	// SSTable data blocks. Zero uses 16.
+	BlockRestartInterval int
+
+	// FilterPolicy builds SSTable filters; nil uses a 10 bits-per-key bloom
+	// filter. Tables are read with the policy the DB is opened with, so it
+	// applies to every column family.
+	FilterPolicy sstable.FilterPolicy
+
 	// L0CompactionTrigger is the number of L0 files to trigger compaction.
 	L0CompactionTrigger int
 
+	// L0StopWritesTrigger stalls writes while a column family has this many L0 files.
+	// It never applies below L0CompactionTrigger. Zero disables the stall.
+	L0StopWritesTrigger int
+
+	// MaxImmutableMemtables stalls writes while a column family has this many
+	// memtables waiting to be flushed. Zero disables the stall.
+	MaxImmutableMemtables int
+
 	// L1MaxBytes is the max total size for L1 (bytes).
 	L1MaxBytes int64
 
+	// MaxOpenFiles bounds the number of SSTable readers kept open by the table cache.
+	MaxOpenFiles int
+
+	// Comparator orders user keys; nil orders them bytewise.
+	// Its name is recorded when the DB is created, and the DB refuses to open
+	// under a comparator with a different name. A column family created with
+	// its own Config is ordered by that Config's Comparator, recorded likewise.
+	Comparator UserComparator
+
+	// PrefixExtractor adds key prefixes to table filters, so prefix iterators
+	// skip tables that hold none of their keys. nil disables prefix filtering.
+	PrefixExtractor PrefixExtractor
+
+	// MergeOperator resolves operands written with DB.Merge.
+	// Merge is rejected while it is nil.
+	MergeOperator MergeOperator
+
+	// MinBlobSize separates values at least this many bytes long into blob
+	// files when they are flushed or compacted; tables keep only a reference.
+	// Zero keeps every value inline.
+	MinBlobSize int
+
+	// BlobGCLiveRatio makes compaction relocate values out of blob files whose
+	// live fraction has fallen below it. Zero disables blob garbage collection.
+	BlobGCLiveRatio float64
+
+	// ColumnFamilyConfigs tunes existing column families by name when the DB is reopened.
+	// Families without an entry use this Config. Each entry's Comparator must
+	// match the one its family was created with.
+	ColumnFamilyConfigs map[string]*Config
+
 	// SyncWrites controls whether each write is fsynced to WAL.
 	// When true (default), every Put/Delete is durable after return.
 	// When false, writes are buffered and may be lost on crash.
+	// WriteOptions overrides it per write.
 	SyncWrites bool
+
+	// Clock supplies the current time for TTL expiry. nil uses time.Now.
+	Clock func() time.Time
+}
+
+// WriteOptions controls a single write.
+// Writes without WriteOptions sync according to Config.SyncWrites.
+type WriteOptions struct {
+	// Sync fsyncs the WAL before the write returns.
+	Sync bool
+
+	// DisableWAL skips the WAL. The write is lost on crash unless it was
+	// flushed first, so use it only for loads that can be redone.
+	DisableWAL bool
+
+	// NoSlowdown fails the write with ErrWriteStall instead of waiting
+	// while writes are stalled.
+	NoSlowdown bool
 }
 
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		WalDir:              "wal",
-		MemtableSizeLimit:   4 * 1024 * 1024, // 4MB
-		CompressionType:     sstable.NoCompression,
-		BlockSize:           4 * 1024, // 4KB
-		L0CompactionTrigger: 4,
-		L1MaxBytes:          64 * 1024 * 1024, // 64MB
-		SyncWrites:          true,
+		WalDir:                "wal",
+		MemtableSizeLimit:     4 * 1024 * 1024, // 4MB
+		CompressionType:       sstable.NoCompression,
+		BlockSize:             4 * 1024, // 4KB
+		L0CompactionTrigger:   4,
+		L0StopWritesTrigger:   12,
+		L1MaxBytes:            64 * 1024 * 1024, // 64MB
+		MaxImmutableMemtables: 4,
+		MaxOpenFiles:          DefaultMaxOpenFiles,
+		SyncWrites:            true,
+	}
+}
+
+// compressionForLevel returns the codec for tables written to level.
+func (c *Config) compressionForLevel(level int) int {
+	if n := len(c.CompressionPerLevel); n > 0 {
+		if level >= n {
+			level = n - 1
+		}
+		return c.CompressionPerLevel[level]
+	}
+	return c.CompressionType
+}
+
+// builderOptions returns the options for tables written to level.
+func (c *Config) builderOptions(level int) *sstable.BuilderOptions {
+	return &sstable.BuilderOptions{
+		Compression:     c.compressionForLevel(level),
+		BlockSize:       c.BlockSize,
+		RestartInterval: c.BlockRestartInterval,
+		FilterPolicy:    c.FilterPolicy,
+	}
+}
+
+// checkCompression fails with sstable.ErrUnsupportedCompression if any
+// configured codec cannot be written.
+func (c *Config) checkCompression() error {
+	if err := sstable.CheckCompression(c.CompressionType); err != nil {
+		return err
+	}
+	for _, codec := range c.CompressionPerLevel {
+		if err := sstable.CheckCompression(codec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// familyComparators returns the Comparator of each family ColumnFamilyConfigs
+// configures, by name.
+func (c *Config) familyComparators() map[string]UserComparator {
+	cmps := make(map[string]UserComparator, len(c.ColumnFamilyConfigs))
+	for name, o := range c.ColumnFamilyConfigs {
+		if o != nil {
+			cmps[name] = o.Comparator
+		}
 	}
+	return cmps
 }
+
+// PrefixExtractor maps user keys to the prefixes indexed by table filters.
+type PrefixExtractor = sstable.PrefixExtractor
+
+// NewFixedPrefixExtractor returns an extractor taking the first n bytes of each key.
+func NewFixedPrefixExtractor(n int) PrefixExtractor {
+	return sstable.NewFixedPrefixExtractor(n)
+}
+
+// WALRetention bounds how long obsolete WAL segments are kept, by age or by
+// total size. See wal.Retention.
+type WALRetention = wal.Retention
@@ -1,7 +1,6 @@
 package engine
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"os"
@@ -10,17 +9,20 @@ import (
 	"sort"
 
 	"sync"
+	"time"
 
 	"vern_kv0.8/internal"
 	"vern_kv0.8/internal/cache"
 	"vern_kv0.8/iterators"
 	"vern_kv0.8/manifest"
 	"vern_kv0.8/memtable"
-	"vern_kv0.8/sstable"
 	"vern_kv0.8/wal"
 )
 
-var ErrNotFound = errors.New("key not found")
+var (
+	ErrNotFound     = errors.New("key not found")
+	ErrInvalidRange = errors.New("range start is after range end")
+)
 
 // DB represents the database instance.
 type DB struct {
@@ -28,17 +30,36 @@ type DB struct {
 	flushMu      sync.Mutex
 	compactionMu sync.Mutex
 	wal          *wal.WAL
-	memtable     *memtable.Memtable
-	immutables   []*memtable.Memtable
 
-	version *VersionSet
+	// Write queue; the writer at its head commits on behalf of a group.
+	writeMu sync.Mutex
+	writers []*writer
+
+	// roomCond wakes stalled writers when a flush or compaction finishes. Uses mu.
+	roomCond *sync.Cond
+
+	// Default column family; its memtable, immutables and version are promoted.
+	*columnFamily
+
+	// Every column family by ID, including dropped ones still pinning files.
+	families     map[uint32]*columnFamily
+	nextFamilyID uint32
+
 	nextSeq uint64
 	dir     string
 	opts    *Config // Configuration
+	cmp     internal.Comparator
+
+	// primaryDir is set on a secondary: the DB whose MANIFEST and WAL it
+	// follows. Its tables are linked into dir, and it has no WAL or MANIFEST.
+	primaryDir string
+	walTail    walPosition // How far a secondary has replayed the WAL. Guarded by flushMu.
 
 	manifest    *manifest.Manifest
 	nextFileNum uint64
 	cache       cache.Cache
+	tableCache  *TableCache
+	blobs       *blobCache
 
 	snapshots *Snapshot // Head of snapshot list
 
@@ -52,9 +73,21 @@ func Open(dir string, options ...*Config) (*DB, error) {
 	if len(options) > 0 && options[0] != nil {
 		opts = options[0]
 	}
+	if err := opts.checkCompression(); err != nil {
+		return nil, err
+	}
+	for _, o := range opts.ColumnFamilyConfigs {
+		if o == nil {
+			continue
+		}
+		if err := o.checkCompression(); err != nil {
+			return nil, err
+		}
+	}
 
 	manifestPath := filepath.Join(dir, "MANIFEST")
 	walDir := filepath.Join(dir, opts.WalDir)
+	cmp := newComparator(opts.Comparator)
 
 	var state *RecoveredState
 
@@ -65,26 +98,26 @@ func Open(dir string, options ...*Config) (*DB, error) {
 		}
 
 		state = &RecoveredState{
-			VersionSet: NewVersionSet(),
-			Memtable:   memtable.New(),
-			NextSeq:    1,
+			VersionSet:         newVersionSet(cmp),
+			Memtable:           memtable.NewWithComparator(cmp),
+			NextSeq:            1,
+			NextColumnFamilyID: 1,
 		}
 
-		f, err := os.Create(manifestPath)
-		if err != nil {
+		// The comparator is recorded before anything it orders.
+		if err := manifest.Rewrite(manifestPath, []manifest.Record{comparatorRecord(cmp)}); err != nil {
 			return nil, err
 		}
-		f.Close()
 	} else {
 		// Recover existing state.
 		var err error
-		state, err = Recover(dir, walDir, opts.MemtableSizeLimit)
+		state, err = Recover(dir, walDir, opts.MemtableSizeLimit, opts.Comparator, opts.familyComparators(), opts.builderOptions(0))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	w, err := wal.OpenWAL(walDir, 64*1024*1024)
+	w, err := wal.OpenWAL(walDir, opts.WALSegmentSize)
 	if err != nil {
 		return nil, err
 	}
@@ -95,266 +128,382 @@ func Open(dir string, options ...*Config) (*DB, error) {
 	}
 
 	db := &DB{
-		wal:        w,
-		memtable:   state.Memtable,
-		immutables: make([]*memtable.Memtable, 0),
+		wal: w,
+
+		columnFamily: newColumnFamily(defaultColumnFamilyID, DefaultColumnFamilyName, opts, cmp, state.VersionSet, state.Memtable),
+		families:     make(map[uint32]*columnFamily),
+		nextFamilyID: state.NextColumnFamilyID,
 
-		version: state.VersionSet,
 		nextSeq: state.NextSeq,
 		dir:     dir,
 		opts:    opts,
+		cmp:     cmp,
 
 		manifest:    m,
 		nextFileNum: state.NextFileNum + 1,
 	}
 
+	db.roomCond = sync.NewCond(&db.mu)
+	db.families[defaultColumnFamilyID] = db.columnFamily
+	for _, rcf := range state.ColumnFamilies {
+		cfOpts := opts
+		if o, ok := opts.ColumnFamilyConfigs[rcf.Name]; ok && o != nil {
+			cfOpts = o
+		}
+		cf := newColumnFamily(rcf.ID, rcf.Name, cfOpts, newComparator(cfOpts.Comparator), rcf.VersionSet, rcf.Memtable)
+		cf.dropped = rcf.Dropped
+		db.families[rcf.ID] = cf
+	}
+
 	if db.nextFileNum == 0 {
 		db.nextFileNum = 1
 	}
 
-	// Double check that all SSTables exist.
-	for _, meta := range db.version.GetAllTables() {
-		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			db.Close()
-			return nil, fmt.Errorf("missing sstable: %s", path)
+	// 8MB cache.
+	db.cache = cache.NewLRUCache(8 * 1024 * 1024)
+	db.tableCache = NewTableCache(dir, opts.MaxOpenFiles, db.cache)
+	db.tableCache.filter = opts.FilterPolicy
+	db.blobs = newBlobCache(dir)
+
+	// Double check that all SSTables and blob files exist.
+	for _, cf := range db.liveFamiliesLocked() {
+		for _, meta := range cf.version.GetAllTables() {
+			path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				db.Close()
+				return nil, fmt.Errorf("missing sstable: %s", path)
+			}
+		}
+		for _, meta := range cf.version.BlobFiles() {
+			path := blobFileName(db.dir, meta.FileNum)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				db.Close()
+				return nil, fmt.Errorf("missing blob file: %s", path)
+			}
 		}
 	}
 
-	// 8MB cache.
-	db.cache = cache.NewLRUCache(8 * 1024 * 1024)
+	// Files of families dropped before the last close.
+	db.cleanupObsoleteFiles()
 
 	return db, nil
 }
 
 func (db *DB) Close() error {
-	if err := db.wal.Close(); err != nil {
-		return err
+	if db.wal != nil {
+		if err := db.wal.Close(); err != nil {
+			return err
+		}
 	}
 	// One last cleanup.
 	db.cleanupObsoleteFiles()
+	db.tableCache.Close()
+	db.blobs.Close()
+	if db.manifest == nil {
+		return nil
+	}
 	return db.manifest.Close()
 }
 
-// Delete unused SSTables.
+// Delete unused SSTables and blob files.
+// A removed file is only deleted once no pinned version references it.
+// Caller must not hold db.mu.
 func (db *DB) cleanupObsoleteFiles() {
-	// Find them.
-	db.version.mu.RLock()
-	var obsolete []uint64
-	for fileNum := range db.version.Obsolete {
-		obsolete = append(obsolete, fileNum)
-	}
-	db.version.mu.RUnlock()
+	db.mu.RLock()
+	families := db.allFamiliesLocked()
+	db.mu.RUnlock()
+
+	for _, cf := range families {
+		// Find them.
+		obsolete := cf.version.DeletableFiles()
+
+		// Nuke them.
+		for _, fileNum := range obsolete {
+			db.tableCache.Evict(fileNum)
+			path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", fileNum))
+			if err := os.Remove(path); err == nil || os.IsNotExist(err) {
+				// Clear from map.
+				cf.version.ForgetObsolete(fileNum)
+			}
+		}
 
-	// Nuke them.
-	for _, fileNum := range obsolete {
-		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", fileNum))
-		if err := os.Remove(path); err == nil || os.IsNotExist(err) {
-			// Clear from map.
-			db.version.mu.Lock()
-			delete(db.version.Obsolete, fileNum)
-			db.version.mu.Unlock()
+		for _, fileNum := range cf.version.DeletableBlobFiles() {
+			db.blobs.Evict(fileNum)
+			if err := os.Remove(blobFileName(db.dir, fileNum)); err == nil || os.IsNotExist(err) {
+				cf.version.ForgetObsolete(fileNum)
+			}
 		}
 	}
 }
 
 func (db *DB) Put(key, value []byte) error {
-	if err := db.checkBackgroundError(); err != nil {
-		return err
-	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	seq := db.nextSeq
-
-	batch := wal.Batch{
-		SeqStart: seq,
-		Records: []wal.LogicalRecord{
-			{
-				Key:   key,
-				Value: value,
-				Type:  wal.LogicalTypePut,
-			},
-		},
-	}
+	return db.PutWithOptions(key, value, nil)
+}
 
-	if err := db.wal.Append(batch); err != nil {
-		return err
-	}
-	if db.opts.SyncWrites {
-		if err := db.wal.Sync(); err != nil {
-			return err
-		}
-	}
+// PutWithOptions sets key as controlled by opts.
+func (db *DB) PutWithOptions(key, value []byte, opts *WriteOptions) error {
+	return db.writeRecord(nil, wal.LogicalRecord{
+		Key:   key,
+		Value: value,
+		Type:  wal.LogicalTypePut,
+	}, opts)
+}
 
-	ikey := internal.EncodeInternalKey(key, seq, internal.RecordTypeValue)
-	db.memtable.Insert(ikey, value)
+// PutCF sets key in the column family h.
+func (db *DB) PutCF(h *ColumnFamilyHandle, key, value []byte) error {
+	return db.writeRecord(h, wal.LogicalRecord{
+		Key:   key,
+		Value: value,
+		Type:  wal.LogicalTypePut,
+	}, nil)
+}
 
-	if db.memtable.ApproximateSize() >= db.opts.MemtableSizeLimit {
-		db.rotateMemtableLocked()
-		go db.MaybeScheduleFlush()
+// writeRecord applies a single record to the column family h (nil is the default family).
+func (db *DB) writeRecord(h *ColumnFamilyHandle, r wal.LogicalRecord, opts *WriteOptions) error {
+	if h != nil {
+		r.ColumnFamily = h.ID()
 	}
-
-	db.nextSeq++
-	return nil
+	return db.writeRecords([]wal.LogicalRecord{r}, opts)
 }
 
 // Write applies a batch.
-func (db *DB) Write(batch *wal.Batch) error {
-	if err := db.checkBackgroundError(); err != nil {
-		return err
+// Records may target different column families; the batch is atomic across them.
+// Nothing is logged unless every record is valid.
+func (db *DB) Write(b *WriteBatch) error {
+	return db.WriteWithOptions(b, nil)
+}
+
+// WriteWithOptions applies a batch as controlled by opts.
+func (db *DB) WriteWithOptions(b *WriteBatch, opts *WriteOptions) error {
+	if b.Count() == 0 {
+		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.writeRecords(b.records, opts)
+}
 
-	batch.SeqStart = db.nextSeq
+func (db *DB) writeRecords(records []wal.LogicalRecord, opts *WriteOptions) error {
+	return db.write(db.newWriter(records, opts))
+}
 
-	// Log it.
-	if err := db.wal.Append(*batch); err != nil {
-		return err
-	}
-	if db.opts.SyncWrites {
-		if err := db.wal.Sync(); err != nil {
-			return err
-		}
+// newWriter queues records for the write path. nil opts syncs per Config.SyncWrites.
+func (db *DB) newWriter(records []wal.LogicalRecord, opts *WriteOptions) *writer {
+	w := &writer{records: records, sync: db.opts.SyncWrites}
+	if opts != nil {
+		w.sync = opts.Sync
+		w.disableWAL = opts.DisableWAL
+		w.noSlowdown = opts.NoSlowdown
 	}
+	return w
+}
 
-	// Apply to memtable.
-	seq := batch.SeqStart
-	for _, r := range batch.Records {
-		typ := internal.RecordTypeValue
-		if r.Type == wal.LogicalTypeDelete {
-			typ = internal.RecordTypeTombstone
-		}
-		ikey := internal.EncodeInternalKey(r.Key, seq, typ)
-		db.memtable.Insert(ikey, r.Value)
-		seq++
+// familyForWriteLocked resolves a record's column family ID.
+func (db *DB) familyForWriteLocked(id uint32) (*columnFamily, error) {
+	cf, ok := db.families[id]
+	if !ok {
+		return nil, ErrColumnFamilyNotFound
 	}
-
-	if db.memtable.ApproximateSize() >= db.opts.MemtableSizeLimit {
-		db.rotateMemtableLocked()
-		go db.MaybeScheduleFlush()
+	if cf.dropped {
+		return nil, ErrColumnFamilyDropped
 	}
-
-	db.nextSeq = seq
-	return nil
+	return cf, nil
 }
 
 func (db *DB) Delete(key []byte) error {
-	if err := db.checkBackgroundError(); err != nil {
-		return err
-	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	seq := db.nextSeq
+	return db.DeleteWithOptions(key, nil)
+}
 
-	batch := wal.Batch{
-		SeqStart: seq,
-		Records: []wal.LogicalRecord{
-			{
-				Key:  key,
-				Type: wal.LogicalTypeDelete,
-			},
-		},
-	}
+// DeleteWithOptions deletes key as controlled by opts.
+func (db *DB) DeleteWithOptions(key []byte, opts *WriteOptions) error {
+	return db.writeRecord(nil, wal.LogicalRecord{
+		Key:  key,
+		Type: wal.LogicalTypeDelete,
+	}, opts)
+}
 
-	if err := db.wal.Append(batch); err != nil {
-		return err
-	}
-	if db.opts.SyncWrites {
-		if err := db.wal.Sync(); err != nil {
-			return err
-		}
-	}
+// DeleteCF deletes key from the column family h.
+func (db *DB) DeleteCF(h *ColumnFamilyHandle, key []byte) error {
+	return db.writeRecord(h, wal.LogicalRecord{
+		Key:  key,
+		Type: wal.LogicalTypeDelete,
+	}, nil)
+}
 
-	ikey := internal.EncodeInternalKey(key, seq, internal.RecordTypeTombstone)
-	db.memtable.Insert(ikey, nil)
+// DeleteRange deletes every key in [start, end) with a single range tombstone.
+// An empty range is a no-op.
+func (db *DB) DeleteRange(start, end []byte) error {
+	switch c := db.cmp.CompareUser(start, end); {
+	case c > 0:
+		return ErrInvalidRange
+	case c == 0:
+		return nil
+	}
+	return db.writeRecord(nil, wal.LogicalRecord{
+		Key:   start,
+		Value: end,
+		Type:  wal.LogicalTypeDeleteRange,
+	}, nil)
+}
 
-	db.nextSeq++
-	return nil
+// Merge records operand against key.
+// Operands are folded into the key's value by Config.MergeOperator on read and during compaction.
+func (db *DB) Merge(key, operand []byte) error {
+	return db.writeRecord(nil, wal.LogicalRecord{
+		Key:   key,
+		Value: operand,
+		Type:  wal.LogicalTypeMerge,
+	}, nil)
 }
 
+// GetWithOptions is a point lookup.
+// Sources are probed newest-first: active memtable, immutables, L0 files,
+// then the files covering the key in each L1+ level. Probing stops at the
+// first visible Value or Tombstone; merge operands above it are folded in.
 func (db *DB) GetWithOptions(key []byte, opts *ReadOptions) ([]byte, error) {
+	return db.GetCF(nil, key, opts)
+}
+
+// GetCF looks key up in the column family h.
+func (db *DB) GetCF(h *ColumnFamilyHandle, key []byte, opts *ReadOptions) ([]byte, error) {
 	if err := db.checkBackgroundError(); err != nil {
 		return nil, err
 	}
-	db.mu.RLock()
-
-	var iters []iterators.InternalIterator
 
-	// Check active memtable.
-	var mtIt iterators.InternalIterator = iterators.NewMemtableIterator(db.memtable)
+	readSeq := internal.MaxSequenceNumber
 	if opts != nil && opts.Snapshot != nil {
-		mtIt = iterators.NewVersionFilterIterator(
-			mtIt,
-			opts.Snapshot.ReadSeq,
-		)
+		readSeq = opts.Snapshot.ReadSeq
 	}
-	iters = append(iters, mtIt)
+	includeExpired := opts != nil && opts.IncludeExpired
 
-	// Check immutable memtables.
-	for _, im := range db.immutables {
-		var imIt iterators.InternalIterator = iterators.NewMemtableIterator(im)
-		if opts != nil && opts.Snapshot != nil {
-			imIt = iterators.NewVersionFilterIterator(
-				imIt,
-				opts.Snapshot.ReadSeq,
-			)
-		}
-		iters = append(iters, imIt)
+	db.mu.RLock()
+	cf, err := db.familyForLocked(h)
+	if err != nil {
+		db.mu.RUnlock()
+		return nil, err
 	}
+	mems := cf.memtablesLocked()
+	ver := cf.version.Current()
+	db.mu.RUnlock()
+	defer db.releaseVersion(ver)
 
-	// Filter SSTables (bloom/range check).
-	sstables := db.getSortedCandidatedTables()
+	return db.get(cf, mems, ver, key, readSeq, includeExpired)
+}
 
-	for _, meta := range sstables {
-		// Key range check.
-		if len(meta.SmallestKey) > 0 && len(meta.LargestKey) > 0 {
-			smallestUser := internal.ExtractUserKey(meta.SmallestKey)
-			largestUser := internal.ExtractUserKey(meta.LargestKey)
-			if bytes.Compare(key, smallestUser) < 0 || bytes.Compare(key, largestUser) > 0 {
-				continue
-			}
+// get resolves key as of readSeq from mems (newest first) and the tables of ver.
+func (db *DB) get(
+	cf *columnFamily,
+	mems []*memtable.Memtable,
+	ver *Version,
+	key []byte,
+	readSeq uint64,
+	includeExpired bool,
+) ([]byte, error) {
+	pl := db.newPointLookup(cf, key, readSeq, db.now().UnixNano(), includeExpired)
+
+	// Memtables.
+	for _, mt := range mems {
+		pl.cover(mt.RangeTombstones())
+		mt.Versions(pl.lookup, pl.collect)
+		if pl.state.done() {
+			return pl.state.result()
 		}
+	}
 
-		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
-		sstIt, err := sstable.NewIterator(path, db.cache)
-		if err != nil {
-			db.mu.RUnlock()
+	// L0 files overlap; newest first.
+	l0 := append([]SSTableMeta(nil), ver.Levels[0]...)
+	sort.Slice(l0, func(i, j int) bool {
+		return l0[i].FileNum > l0[j].FileNum
+	})
+	for _, meta := range l0 {
+		if !meta.containsUserKey(cf.cmp, key) {
+			continue
+		}
+		if err := db.probeTable(cf, meta.FileNum, pl.lookup, pl.cover, pl.collect); err != nil {
 			return nil, err
 		}
-
-		var it iterators.InternalIterator = sstIt
-		if opts != nil && opts.Snapshot != nil {
-			it = iterators.NewVersionFilterIterator(it, opts.Snapshot.ReadSeq)
+		if pl.state.done() {
+			return pl.state.result()
 		}
-		iters = append(iters, it)
 	}
 
-	db.mu.RUnlock()
-
-	merge := iterators.NewMergeIterator(iters, true)
-	merge.SeekToFirst()
-
-	// Stop when we pass the key.
-	for merge.Valid() {
-		userKey := internal.ExtractUserKey(merge.Key())
-		cmp := bytes.Compare(userKey, key)
-		if cmp == 0 {
-			_, typ, _ := internal.ExtractTrailer(merge.Key())
-			if typ == internal.RecordTypeTombstone {
-				return nil, ErrNotFound
+	// L1+ files are sorted and disjoint; binary search for the first candidate.
+	// A key's versions may continue into the next file of the same level.
+	for l := 1; l < NumLevels; l++ {
+		files := ver.Levels[l]
+		i := sort.Search(len(files), func(i int) bool {
+			return cf.cmp.Compare(files[i].LargestKey, pl.lookup) >= 0
+		})
+		for ; i < len(files) && files[i].containsUserKey(cf.cmp, key); i++ {
+			if err := db.probeTable(cf, files[i].FileNum, pl.lookup, pl.cover, pl.collect); err != nil {
+				return nil, err
+			}
+			if pl.state.done() {
+				return pl.state.result()
 			}
-			return merge.Value(), nil
-		}
-		if cmp > 0 {
-			break
 		}
-		merge.Next()
 	}
 
-	return nil, ErrNotFound
+	return pl.state.result()
+}
+
+// pointLookup resolves one key from its versions, fed source by source newest first.
+type pointLookup struct {
+	key     []byte
+	lookup  []byte
+	readSeq uint64
+
+	now            int64
+	includeExpired bool
+
+	cmp internal.Comparator
+
+	// coverSeq is the newest range tombstone over key seen so far;
+	// versions older than it read as deleted.
+	coverSeq uint64
+	state    mergeState
+}
+
+func (db *DB) newPointLookup(cf *columnFamily, key []byte, readSeq uint64, now int64, includeExpired bool) *pointLookup {
+	return &pointLookup{
+		key:            key,
+		lookup:         internal.SeekKey(key, readSeq),
+		cmp:            cf.cmp,
+		readSeq:        readSeq,
+		now:            now,
+		includeExpired: includeExpired,
+		state:          mergeState{op: cf.opts.MergeOperator, key: key, blobs: db.blobs},
+	}
+}
+
+// cover applies a source's range tombstones. Call it before collect for that source.
+func (pl *pointLookup) cover(ts []internal.RangeTombstone) {
+	if seq := coveringSeq(pl.cmp, ts, pl.key, pl.readSeq); seq > pl.coverSeq {
+		pl.coverSeq = seq
+	}
+}
+
+// collect feeds the next older version. It returns false once the key is resolved.
+func (pl *pointLookup) collect(k, v []byte) bool {
+	seq, typ, _ := internal.ExtractTrailer(k)
+	if seq < pl.coverSeq {
+		typ = internal.RecordTypeTombstone
+	}
+	return pl.state.add(resolveExpiry(typ, v, pl.now, pl.includeExpired))
+}
+
+// probeTable applies one table's range tombstones, then feeds its versions of lookup to collect.
+func (db *DB) probeTable(
+	cf *columnFamily,
+	fileNum uint64,
+	lookup []byte,
+	cover func([]internal.RangeTombstone),
+	collect func(key, value []byte) bool,
+) error {
+	ts, err := db.tableCache.RangeTombstones(fileNum, cf.cmp)
+	if err != nil {
+		return err
+	}
+	cover(ts)
+	return db.tableCache.Versions(fileNum, cf.cmp, lookup, collect)
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
@@ -369,6 +518,9 @@ func (db *DB) GetSnapshot() *Snapshot {
 		ReadSeq: db.nextSeq - 1,
 		db:      db,
 	}
+	for _, cf := range db.liveFamiliesLocked() {
+		s.versions = append(s.versions, cf.version.Current())
+	}
 
 	// Add to list.
 	s.next = db.snapshots
@@ -383,7 +535,6 @@ func (db *DB) GetSnapshot() *Snapshot {
 
 func (db *DB) ReleaseSnapshot(s *Snapshot) {
 	db.mu.Lock()
-	defer db.mu.Unlock()
 
 	if s.prev != nil {
 		s.prev.next = s.next
@@ -394,9 +545,29 @@ func (db *DB) ReleaseSnapshot(s *Snapshot) {
 		s.next.prev = s.prev
 	}
 
+	cleanup := false
+	for _, ver := range s.versions {
+		if ver.Unref() {
+			cleanup = true
+		}
+	}
+
 	s.db = nil
 	s.prev = nil
 	s.next = nil
+	s.versions = nil
+	db.mu.Unlock()
+
+	if cleanup {
+		db.cleanupObsoleteFiles()
+	}
+}
+
+// releaseVersion unpins ver, deleting files only it was keeping alive.
+func (db *DB) releaseVersion(ver *Version) {
+	if ver.Unref() {
+		db.cleanupObsoleteFiles()
+	}
 }
 
 func (db *DB) getOldestSnapshotSeq() uint64 {
@@ -410,13 +581,53 @@ func (db *DB) getOldestSnapshotSeq() uint64 {
 	return oldest
 }
 
+// snapshotSeqs returns the read sequences of live snapshots, ascending.
+func (db *DB) snapshotSeqs() []uint64 {
+	// Caller holds lock.
+	var seqs []uint64
+	for s := db.snapshots; s != nil; s = s.next {
+		seqs = append(seqs, s.ReadSeq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
 func (db *DB) NewIterator(opts *ReadOptions) Iterator {
+	it, _ := db.NewIteratorCF(nil, opts)
+	return it
+}
+
+// NewIteratorCF iterates over the column family h.
+func (db *DB) NewIteratorCF(h *ColumnFamilyHandle, opts *ReadOptions) (Iterator, error) {
+	return db.newIterator(h, opts, nil)
+}
+
+// newIterator iterates over the column family h. With a scan prefix in the
+// family's PrefixExtractor domain, tables whose filter rules it out are skipped.
+func (db *DB) newIterator(h *ColumnFamilyHandle, opts *ReadOptions, prefix []byte) (Iterator, error) {
 	db.mu.RLock()
+	cf, err := db.familyForLocked(h)
+	if err != nil {
+		db.mu.RUnlock()
+		return nil, err
+	}
+
+	var filterPrefix []byte
+	extractor := cf.opts.PrefixExtractor
+	if prefix != nil && extractor != nil && extractor.InDomain(prefix) {
+		filterPrefix = extractor.Transform(prefix)
+	}
 
 	var iters []iterators.InternalIterator
 
+	readSeq := internal.MaxSequenceNumber
+	if opts != nil && opts.Snapshot != nil {
+		readSeq = opts.Snapshot.ReadSeq
+	}
+	tombstones := cf.memtable.RangeTombstones()
+
 	// Active memtable.
-	var mtIt iterators.InternalIterator = iterators.NewMemtableIterator(db.memtable)
+	var mtIt iterators.InternalIterator = iterators.NewMemtableIterator(cf.memtable)
 	if opts != nil && opts.Snapshot != nil {
 		mtIt = iterators.NewVersionFilterIterator(
 			mtIt,
@@ -426,7 +637,9 @@ func (db *DB) NewIterator(opts *ReadOptions) Iterator {
 	iters = append(iters, mtIt)
 
 	// Immutables.
-	for _, im := range db.immutables {
+	for _, im := range cf.immutables {
+		tombstones = append(tombstones, im.RangeTombstones()...)
+
 		var imIt iterators.InternalIterator = iterators.NewMemtableIterator(im)
 		if opts != nil && opts.Snapshot != nil {
 			imIt = iterators.NewVersionFilterIterator(
@@ -438,16 +651,34 @@ func (db *DB) NewIterator(opts *ReadOptions) Iterator {
 	}
 
 	// SSTables.
-	sstables := db.getSortedCandidatedTables()
+	ver := cf.version.Current()
+	sstables := sortedTables(ver)
 	validSSTs := make([]iterators.InternalIterator, 0)
+	var releases []func()
 
 	for _, meta := range sstables {
-		path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
-		sstIt, err := sstable.NewIterator(path, db.cache)
+		// A skipped table still contributes its range tombstones.
+		if filterPrefix != nil {
+			mayMatch, ts, err := db.tableCache.PrefixMayMatch(meta.FileNum, cf.cmp, extractor.Name(), filterPrefix)
+			if err == nil && !mayMatch {
+				tombstones = append(tombstones, ts...)
+				continue
+			}
+		}
+
+		sstIt, release, err := db.tableCache.NewIterator(meta.FileNum, cf.cmp)
 		if err != nil {
+			path := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", meta.FileNum))
 			db.mu.RUnlock()
+			for _, r := range releases {
+				r()
+			}
+			db.releaseVersion(ver)
 			panic(fmt.Sprintf("failed to open sstable %s: %v", path, err))
 		}
+		releases = append(releases, release)
+
+		tombstones = append(tombstones, sstIt.RangeTombstones()...)
 
 		var it iterators.InternalIterator = sstIt
 		if opts != nil && opts.Snapshot != nil {
@@ -459,19 +690,25 @@ func (db *DB) NewIterator(opts *ReadOptions) Iterator {
 
 	db.mu.RUnlock()
 
-	merge := iterators.NewMergeIterator(iters, true)
+	// Keep every version; dbIterator resolves tombstones and merge operands.
+	merge := iterators.NewMergeIterator(iters, false)
+	merge.SetComparator(cf.cmp)
 
 	return &dbIterator{
-		inner: merge,
-	}
+		inner:          merge,
+		merge:          cf.opts.MergeOperator,
+		rangeDels:      newRangeDelSet(cf.cmp, tombstones, readSeq),
+		now:            db.now().UnixNano(),
+		includeExpired: opts != nil && opts.IncludeExpired,
+		db:             db,
+		version:        ver,
+		release:        releases,
+	}, nil
 }
 
 // Return tables sorted by FileNum (descending).
-func (db *DB) getSortedCandidatedTables() []SSTableMeta {
-	var metas []SSTableMeta
-	for _, m := range db.version.GetAllTables() {
-		metas = append(metas, m)
-	}
+func sortedTables(ver *Version) []SSTableMeta {
+	metas := ver.AllTables()
 
 	sort.Slice(metas, func(i, j int) bool {
 		return metas[i].FileNum > metas[j].FileNum
@@ -488,33 +725,55 @@ func (db *DB) NewRangeIterator(
 	base := db.NewIterator(opts)
 	return &scanIterator{
 		inner: base,
+		cmp:   db.cmp,
 		start: start,
 		end:   end,
 	}
 }
 
+// NewPrefixIterator iterates over the keys beginning with prefix.
+// Under the bytewise comparator those keys are contiguous, so the scan seeks
+// straight to them and ends after the last one.
 func (db *DB) NewPrefixIterator(
 	prefix []byte,
 	opts *ReadOptions,
 ) Iterator {
-	base := db.NewIterator(opts)
-	return &scanIterator{
+	base, _ := db.newIterator(nil, opts, prefix)
+	it := &scanIterator{
 		inner:  base,
+		cmp:    db.cmp,
 		prefix: prefix,
 	}
+	if db.cmp.Name() == BytewiseComparator.Name() {
+		it.start, it.end = prefix, prefixSuccessor(prefix)
+	}
+	return it
 }
 
-// Move active memtable to immutable list.
-func (db *DB) rotateMemtableLocked() {
-	frozen := db.memtable
-	db.immutables = append(db.immutables, frozen)
-	db.memtable = memtable.New()
+// prefixSuccessor returns the smallest key after every key beginning with
+// prefix, or nil if there is none.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			succ := append([]byte(nil), prefix[:i+1]...)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
 }
 
-// Rotate and schedule flush.
+// Move a family's active memtable to its immutable list.
+func (db *DB) rotateMemtableLocked(cf *columnFamily) {
+	frozen := cf.memtable
+	cf.immutables = append(cf.immutables, frozen)
+	cf.memtable = memtable.NewWithComparator(cf.cmp)
+}
+
+// Rotate the default family and schedule flush.
 func (db *DB) freezeMemtable() {
 	db.mu.Lock()
-	db.rotateMemtableLocked()
+	db.rotateMemtableLocked(db.columnFamily)
 	db.mu.Unlock()
 
 	db.MaybeScheduleFlush()
@@ -524,6 +783,7 @@ func (db *DB) MaybeScheduleFlush() {
 
 	db.flushMu.Lock()
 	defer db.flushMu.Unlock()
+	defer db.signalRoom()
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -534,18 +794,19 @@ func (db *DB) MaybeScheduleFlush() {
 	for {
 		runtime.Gosched()
 		db.mu.Lock()
-		if len(db.immutables) == 0 {
+		cf := db.nextFlushLocked()
+		if cf == nil {
 			db.mu.Unlock()
 			break
 		}
-		im := db.immutables[0]
+		im := cf.immutables[0]
 
 		fileNum := db.nextFileNum
 		db.nextFileNum++
 		db.mu.Unlock()
 
 		// Flush it.
-		meta, err := db.flushMemtable(im, fileNum)
+		meta, blobMeta, err := db.flushMemtable(cf, im, fileNum)
 		if err != nil {
 			db.setBackgroundError(err)
 			return
@@ -554,7 +815,27 @@ func (db *DB) MaybeScheduleFlush() {
 		// Commit.
 		db.mu.Lock()
 
-		if err := db.version.AddTable(meta); err != nil {
+		if cf.dropped {
+			// Dropped mid-flush; the table was never recorded.
+			db.mu.Unlock()
+			os.Remove(filepath.Join(db.dir, fmt.Sprintf("%06d.sst", fileNum)))
+			if blobMeta != nil {
+				os.Remove(blobFileName(db.dir, blobMeta.FileNum))
+			}
+			continue
+		}
+
+		// The blob file is logged first so no replayed table refers to an unknown file.
+		versionEdit := VersionEdit{Added: []SSTableMeta{meta}}
+		if blobMeta != nil {
+			if err := db.manifest.Append(addBlobFileRecord(cf, *blobMeta)); err != nil {
+				db.mu.Unlock()
+				db.setBackgroundError(err)
+				return
+			}
+			versionEdit.AddedBlobs = []BlobFileMeta{*blobMeta}
+		}
+		if err := cf.version.Apply(versionEdit); err != nil {
 			db.mu.Unlock()
 			db.setBackgroundError(err)
 			return
@@ -563,13 +844,14 @@ func (db *DB) MaybeScheduleFlush() {
 		edit := manifest.Record{
 			Type: manifest.RecordTypeAddSSTable,
 			Data: manifest.AddSSTable{
-				FileNum:     meta.FileNum,
-				Level:       meta.Level,
-				SmallestKey: meta.SmallestKey,
-				LargestKey:  meta.LargestKey,
-				SmallestSeq: meta.SmallestSeq,
-				LargestSeq:  meta.LargestSeq,
-				FileSize:    meta.FileSize,
+				FileNum:      meta.FileNum,
+				Level:        meta.Level,
+				SmallestKey:  meta.SmallestKey,
+				LargestKey:   meta.LargestKey,
+				SmallestSeq:  meta.SmallestSeq,
+				LargestSeq:   meta.LargestSeq,
+				FileSize:     meta.FileSize,
+				ColumnFamily: cf.id,
 			},
 		}
 		if err := db.manifest.Append(edit); err != nil {
@@ -580,22 +862,25 @@ func (db *DB) MaybeScheduleFlush() {
 
 		cutOffEdit := manifest.Record{
 			Type: manifest.RecordTypeSetWALCutoff,
-			Data: manifest.SetWALCutoff{Seq: meta.LargestSeq},
+			Data: manifest.SetWALCutoff{Seq: meta.LargestSeq, ColumnFamily: cf.id},
 		}
 		if err := db.manifest.Append(cutOffEdit); err != nil {
 			db.mu.Unlock()
 			db.setBackgroundError(err)
 			return
 		}
-		db.version.SetWALCutoff(meta.LargestSeq)
+		cf.version.SetWALCutoff(meta.LargestSeq)
+
+		cf.immutables = cf.immutables[1:]
 
-		db.immutables = db.immutables[1:]
+		// The shared WAL can only drop what every family has flushed.
+		walCutoff := db.walCutoffLocked()
 		db.mu.Unlock()
 
 		// Truncate WAL.
-		if meta.LargestSeq > 0 {
+		if walCutoff > 0 {
 			walDir := filepath.Join(db.dir, db.opts.WalDir)
-			wal.Truncate(walDir, meta.LargestSeq)
+			wal.TruncateRetaining(walDir, walCutoff, db.opts.WALRetention, time.Now())
 		}
 	}
 
@@ -606,6 +891,16 @@ func (db *DB) MaybeScheduleFlush() {
 	db.cleanupObsoleteFiles()
 }
 
+// nextFlushLocked returns a live family with an immutable memtable, or nil.
+func (db *DB) nextFlushLocked() *columnFamily {
+	for _, cf := range db.liveFamiliesLocked() {
+		if len(cf.immutables) > 0 {
+			return cf
+		}
+	}
+	return nil
+}
+
 func (db *DB) setBackgroundError(err error) {
 	db.bgErrMu.Lock()
 	defer db.bgErrMu.Unlock()
@@ -622,33 +917,13 @@ func (db *DB) checkBackgroundError() error {
 
 // CompactManifest rewrites the manifest.
 func (db *DB) CompactManifest() error {
+	if db.primaryDir != "" {
+		return ErrReadOnly
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// Snapshot state.
-	var records []manifest.Record
-
-	// Tables.
-	for _, meta := range db.version.GetAllTables() {
-		records = append(records, manifest.Record{
-			Type: manifest.RecordTypeAddSSTable,
-			Data: manifest.AddSSTable{
-				FileNum:     meta.FileNum,
-				Level:       meta.Level,
-				SmallestKey: meta.SmallestKey,
-				LargestKey:  meta.LargestKey,
-				SmallestSeq: meta.SmallestSeq,
-				LargestSeq:  meta.LargestSeq,
-				FileSize:    meta.FileSize,
-			},
-		})
-	}
-
-	// WAL Cutoff.
-	records = append(records, manifest.Record{
-		Type: manifest.RecordTypeSetWALCutoff,
-		Data: manifest.SetWALCutoff{Seq: db.version.WALCutoffSeq},
-	})
+	records := db.manifestSnapshotLocked()
 
 	// Rewrite.
 	manifestPath := filepath.Join(db.dir, "MANIFEST")
@@ -675,3 +950,63 @@ func (db *DB) CompactManifest() error {
 
 	return nil
 }
+
+// manifestSnapshotLocked returns manifest records rebuilding the current state.
+// Caller holds db.mu.
+func (db *DB) manifestSnapshotLocked() []manifest.Record {
+	// The comparator goes first, ahead of anything it orders.
+	records := []manifest.Record{comparatorRecord(db.cmp)}
+
+	for _, cf := range db.allFamiliesLocked() {
+		// Families. Dropped ones are kept so their IDs are never reused.
+		if cf.id != defaultColumnFamilyID {
+			records = append(records, manifest.Record{
+				Type: manifest.RecordTypeCreateColumnFamily,
+				Data: manifest.CreateColumnFamily{ID: cf.id, Name: cf.name, Comparator: cf.cmp.Name()},
+			})
+		}
+		if cf.dropped {
+			records = append(records, manifest.Record{
+				Type: manifest.RecordTypeDropColumnFamily,
+				Data: manifest.DropColumnFamily{ID: cf.id},
+			})
+			continue
+		}
+
+		// Blob files, with the garbage they have accumulated.
+		for _, meta := range cf.version.BlobFiles() {
+			records = append(records, addBlobFileRecord(cf, meta))
+			if meta.GarbageCount > 0 {
+				records = append(records, blobGarbageRecord(cf, BlobGarbage{
+					FileNum: meta.FileNum,
+					Count:   meta.GarbageCount,
+					Size:    meta.GarbageSize,
+				}))
+			}
+		}
+
+		// Tables.
+		for _, meta := range cf.version.GetAllTables() {
+			records = append(records, manifest.Record{
+				Type: manifest.RecordTypeAddSSTable,
+				Data: manifest.AddSSTable{
+					FileNum:      meta.FileNum,
+					Level:        meta.Level,
+					SmallestKey:  meta.SmallestKey,
+					LargestKey:   meta.LargestKey,
+					SmallestSeq:  meta.SmallestSeq,
+					LargestSeq:   meta.LargestSeq,
+					FileSize:     meta.FileSize,
+					ColumnFamily: cf.id,
+				},
+			})
+		}
+
+		// WAL Cutoff.
+		records = append(records, manifest.Record{
+			Type: manifest.RecordTypeSetWALCutoff,
+			Data: manifest.SetWALCutoff{Seq: cf.version.WALCutoffSeq, ColumnFamily: cf.id},
+		})
+	}
+	return records
+}
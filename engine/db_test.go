@@ -141,3 +141,58 @@ func TestImmutableMemtableReadVisibility(t *testing.T) {
 		t.Fatalf("expected to read from active memtable")
 	}
 }
+
+func TestGetAcrossLevels(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// L1: k=v1, gone=x.
+	db.Put([]byte("k"), []byte("v1"))
+	db.Put([]byte("gone"), []byte("x"))
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	snapL1 := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapL1)
+
+	// L0: k=v2, gone deleted.
+	db.Put([]byte("k"), []byte("v2"))
+	db.Delete([]byte("gone"))
+	db.freezeMemtable()
+	snapL0 := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapL0)
+
+	// Memtable: k=v3.
+	db.Put([]byte("k"), []byte("v3"))
+
+	cases := []struct {
+		snap *Snapshot
+		want string
+	}{
+		{nil, "v3"},
+		{snapL0, "v2"},
+		{snapL1, "v1"},
+	}
+	for _, c := range cases {
+		v, err := db.GetWithOptions([]byte("k"), &ReadOptions{Snapshot: c.snap})
+		if err != nil || string(v) != c.want {
+			t.Errorf("want %s, got %q (%v)", c.want, v, err)
+		}
+	}
+
+	if _, err := db.Get([]byte("gone")); err != ErrNotFound {
+		t.Errorf("expected tombstone in L0 to hide L1 value, got %v", err)
+	}
+	v, err := db.GetWithOptions([]byte("gone"), &ReadOptions{Snapshot: snapL1})
+	if err != nil || string(v) != "x" {
+		t.Errorf("expected snapshot to read L1 value, got %q (%v)", v, err)
+	}
+	if _, err := db.Get([]byte("missing")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -12,13 +12,31 @@ import (
 	"vern_kv0.8/sstable"
 )
 
+// builderOptions returns the options for cf's tables written to level.
+// The filter policy is DB-wide, since the table cache reads every table with it.
+func (db *DB) builderOptions(cf *columnFamily, level int) *sstable.BuilderOptions {
+	opts := cf.opts.builderOptions(level)
+	opts.FilterPolicy = db.opts.FilterPolicy
+	return opts
+}
+
 // flushMemtable flushes memtable to disk.
-func (db *DB) flushMemtable(mt *memtable.Memtable, fileNum uint64) (SSTableMeta, error) {
+// Large values go to a blob file, returned alongside the table when one was written.
+func (db *DB) flushMemtable(cf *columnFamily, mt *memtable.Memtable, fileNum uint64) (SSTableMeta, *BlobFileMeta, error) {
 	filename := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", fileNum))
 
-	builder, err := sstable.NewBuilder(filename)
+	builder, err := sstable.NewBuilder(filename, db.builderOptions(cf, 0))
 	if err != nil {
-		return SSTableMeta{}, err
+		return SSTableMeta{}, nil, err
+	}
+	builder.SetComparator(cf.cmp)
+	if p := cf.opts.PrefixExtractor; p != nil {
+		builder.SetPrefixExtractor(p)
+	}
+	blobs := db.newBlobOutput(cf)
+	fail := func(err error) (SSTableMeta, *BlobFileMeta, error) {
+		blobs.abandon()
+		return SSTableMeta{}, nil, err
 	}
 
 	it := iterators.NewMemtableIterator(mt)
@@ -30,13 +48,21 @@ func (db *DB) flushMemtable(mt *memtable.Memtable, fileNum uint64) (SSTableMeta,
 	var count uint64
 
 	first := true
+	now := db.now().UnixNano()
 
 	for it.Valid() {
 		key := it.Key()
 		value := it.Value()
+		if expired(key, value, now) {
+			key, value = expiredAsTombstone(key), nil
+		}
+		key, value, err := blobs.separate(key, value)
+		if err != nil {
+			return fail(err)
+		}
 
 		if err := builder.Add(key, value); err != nil {
-			return SSTableMeta{}, err
+			return fail(err)
 		}
 
 		// Update metadata.
@@ -62,28 +88,38 @@ func (db *DB) flushMemtable(mt *memtable.Memtable, fileNum uint64) (SSTableMeta,
 		it.Next()
 	}
 
+	meta := SSTableMeta{
+		FileNum:     fileNum,
+		Level:       0,
+		SmallestKey: smallest,
+		LargestKey:  largest,
+		SmallestSeq: smallestSeq,
+		LargestSeq:  largestSeq,
+	}
+
+	// Range tombstones go to their own block.
+	tombstones := mt.RangeTombstones()
+	if err := addRangeTombstones(cf.cmp, builder, &meta, tombstones); err != nil {
+		return fail(err)
+	}
+
 	if err := builder.Close(); err != nil {
-		return SSTableMeta{}, err
+		return fail(err)
+	}
+	blobMeta, err := blobs.finish()
+	if err != nil {
+		return fail(err)
 	}
 
 	// Determine file size.
-	var fileSize int64
 	if info, err := os.Stat(filename); err == nil {
-		fileSize = info.Size()
+		meta.FileSize = info.Size()
 	}
 
-	if count == 0 {
-		smallestSeq = 0
+	if count == 0 && len(tombstones) == 0 {
+		meta.SmallestSeq = 0
 	}
 
 	// Return L0 metadata.
-	return SSTableMeta{
-		FileNum:     fileNum,
-		Level:       0,
-		SmallestKey: smallest,
-		LargestKey:  largest,
-		SmallestSeq: smallestSeq,
-		LargestSeq:  largestSeq,
-		FileSize:    fileSize,
-	}, nil
+	return meta, blobMeta, nil
 }
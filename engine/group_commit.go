@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/wal"
+)
+
+// ErrWriteStall is returned to NoSlowdown writes while writes are stalled.
+var ErrWriteStall = errors.New("writes are stalled")
+
+// writer is one caller waiting in the write queue.
+type writer struct {
+	records    []wal.LogicalRecord
+	sync       bool
+	disableWAL bool
+	noSlowdown bool
+
+	// check runs under db.mu just before sequence numbers are assigned.
+	// A writer with a check always commits in a group of its own.
+	check func() error
+
+	// run, when set, replaces the commit: the writer leads a group of its
+	// own and runs while no other write is in flight.
+	run func() error
+
+	err  error
+	done bool
+	cv   *sync.Cond
+}
+
+// write commits w through the write queue.
+//
+// The writer at the head of the queue becomes the leader: it merges the
+// batches queued behind it into one WAL record and one fsync, applies them
+// to the memtables, then wakes the followers with their results.
+// db.mu is not held across the WAL write, so reads proceed meanwhile.
+func (db *DB) write(w *writer) error {
+	if db.primaryDir != "" {
+		return ErrReadOnly
+	}
+	if err := validateRecords(db.familyComparator, w.records); err != nil {
+		return err
+	}
+	if err := db.waitForRoom(w.noSlowdown); err != nil {
+		return err
+	}
+
+	w.cv = sync.NewCond(&db.writeMu)
+	db.writeMu.Lock()
+	db.writers = append(db.writers, w)
+	for !w.done && db.writers[0] != w {
+		w.cv.Wait()
+	}
+	if w.done {
+		// A leader committed us.
+		db.writeMu.Unlock()
+		return w.err
+	}
+	group := db.buildGroupLocked()
+	db.writeMu.Unlock()
+
+	if w.run != nil {
+		w.err = w.run()
+	} else {
+		db.commitGroup(group)
+	}
+
+	db.writeMu.Lock()
+	db.writers = db.writers[len(group):]
+	for _, f := range group[1:] {
+		f.done = true
+		f.cv.Signal()
+	}
+	if len(db.writers) > 0 {
+		db.writers[0].cv.Signal()
+	}
+	db.writeMu.Unlock()
+
+	return w.err
+}
+
+// buildGroupLocked takes the leader and the writers queued behind it.
+// The group stops before a writer with a check or run, before a sync writer if
+// the leader does not sync, at a change of DisableWAL, and before
+// MaxBatchSize is exceeded.
+// Caller holds db.writeMu.
+func (db *DB) buildGroupLocked() []*writer {
+	leader := db.writers[0]
+	group := []*writer{leader}
+	if leader.check != nil || leader.run != nil {
+		return group
+	}
+
+	size := batchSize(leader.records)
+	for _, w := range db.writers[1:] {
+		if w.check != nil || w.run != nil || (w.sync && !leader.sync) || w.disableWAL != leader.disableWAL {
+			break
+		}
+		size += batchSize(w.records)
+		if size > MaxBatchSize {
+			break
+		}
+		group = append(group, w)
+	}
+	return group
+}
+
+func batchSize(records []wal.LogicalRecord) int {
+	size := 0
+	for _, r := range records {
+		size += encodedRecordSize(r)
+	}
+	return size
+}
+
+// commitGroup logs the group as one WAL record and applies it.
+// Each writer's error is left in its err field; a writer rejected by its
+// check or by a missing column family does not affect the others.
+func (db *DB) commitGroup(group []*writer) {
+	db.mu.Lock()
+	var records []wal.LogicalRecord
+	var families []*columnFamily
+	for _, w := range group {
+		if w.check != nil {
+			if w.err = w.check(); w.err != nil {
+				continue
+			}
+		}
+		fams, err := db.resolveFamiliesLocked(w.records)
+		if err != nil {
+			w.err = err
+			continue
+		}
+		records = append(records, w.records...)
+		families = append(families, fams...)
+	}
+	// Only the leader assigns sequence numbers, so they stay reserved while unlocked.
+	seqStart := db.nextSeq
+	db.mu.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+
+	// Log it. Unlogged groups still consume their sequence numbers,
+	// which leaves gaps in the WAL that recovery skips over.
+	var err error
+	if !group[0].disableWAL {
+		err = db.wal.Append(wal.Batch{SeqStart: seqStart, Records: records})
+		if err == nil && group[0].sync {
+			err = db.wal.Sync()
+		}
+	}
+	if err != nil {
+		for _, w := range group {
+			if w.err == nil {
+				w.err = err
+			}
+		}
+		return
+	}
+
+	// Apply to memtables.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	seq := seqStart
+	for i, r := range records {
+		ikey := internal.EncodeInternalKey(r.Key, seq, convertLogicalType(r.Type))
+		families[i].memtable.Insert(ikey, r.Value)
+		seq++
+	}
+	db.nextSeq = seq
+
+	for i, cf := range families {
+		if i > 0 && cf == families[i-1] {
+			continue
+		}
+		if !cf.dropped && cf.memtable.ApproximateSize() >= cf.opts.MemtableSizeLimit {
+			db.rotateMemtableLocked(cf)
+			go db.MaybeScheduleFlush()
+		}
+	}
+}
+
+// resolveFamiliesLocked returns the family of each record. Caller holds db.mu.
+func (db *DB) resolveFamiliesLocked(records []wal.LogicalRecord) ([]*columnFamily, error) {
+	families := make([]*columnFamily, len(records))
+	for i, r := range records {
+		cf, err := db.familyForWriteLocked(r.ColumnFamily)
+		if err != nil {
+			return nil, err
+		}
+		if r.Type == wal.LogicalTypeMerge && cf.opts.MergeOperator == nil {
+			return nil, ErrNoMergeOperator
+		}
+		families[i] = cf
+	}
+	return families, nil
+}
+
+// waitForRoom blocks while any live column family is over its memtable or
+// L0 limits, kicking a flush so that the backlog drains.
+// With noSlowdown it fails with ErrWriteStall instead of waiting.
+func (db *DB) waitForRoom(noSlowdown bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for {
+		if err := db.checkBackgroundError(); err != nil {
+			return err
+		}
+		if !db.writeStalledLocked() {
+			return nil
+		}
+		if noSlowdown {
+			return ErrWriteStall
+		}
+		go db.MaybeScheduleFlush()
+		db.roomCond.Wait()
+	}
+}
+
+// writeStalledLocked reports whether a live family has too many memtables
+// awaiting flush or too many L0 files. Caller holds db.mu.
+func (db *DB) writeStalledLocked() bool {
+	for _, cf := range db.liveFamiliesLocked() {
+		if n := cf.opts.MaxImmutableMemtables; n > 0 && len(cf.immutables) >= n {
+			return true
+		}
+		if n := cf.opts.L0StopWritesTrigger; n > 0 && len(cf.version.LevelFiles(0)) >= max(n, cf.opts.L0CompactionTrigger) {
+			return true
+		}
+	}
+	return false
+}
+
+// signalRoom wakes writers waiting in waitForRoom.
+func (db *DB) signalRoom() {
+	db.mu.Lock()
+	db.roomCond.Broadcast()
+	db.mu.Unlock()
+}
@@ -0,0 +1,375 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/manifest"
+	"vern_kv0.8/memtable"
+	"vern_kv0.8/sstable"
+)
+
+var (
+	ErrKeyOutOfOrder        = errors.New("keys out of order")
+	ErrExternalFilesOverlap = errors.New("external files overlap")
+	ErrInvalidExternalFile  = errors.New("invalid external file")
+)
+
+// SstFileWriter builds a table for IngestExternalFiles from keys added in
+// ascending order, each at most once.
+type SstFileWriter struct {
+	builder *sstable.Builder
+	cmp     internal.Comparator
+	path    string
+	last    []byte
+	count   int
+}
+
+// NewSstFileWriter creates the table path. opts sets the key order, which
+// must match the DB the file is ingested into, and the table format; nil
+// uses DefaultConfig.
+func NewSstFileWriter(path string, opts *Config) (*SstFileWriter, error) {
+	if opts == nil {
+		opts = DefaultConfig()
+	}
+	if err := opts.checkCompression(); err != nil {
+		return nil, err
+	}
+	cmp := newComparator(opts.Comparator)
+
+	b, err := sstable.NewBuilder(path, opts.builderOptions(0))
+	if err != nil {
+		return nil, err
+	}
+	b.SetComparator(cmp)
+	if p := opts.PrefixExtractor; p != nil {
+		b.SetPrefixExtractor(p)
+	}
+	return &SstFileWriter{builder: b, cmp: cmp, path: path}, nil
+}
+
+// Put adds key with value.
+func (w *SstFileWriter) Put(key, value []byte) error {
+	return w.add(key, value, internal.RecordTypeValue)
+}
+
+// Merge adds a merge operand for key.
+func (w *SstFileWriter) Merge(key, operand []byte) error {
+	return w.add(key, operand, internal.RecordTypeMerge)
+}
+
+// Delete adds a tombstone for key.
+func (w *SstFileWriter) Delete(key []byte) error {
+	return w.add(key, nil, internal.RecordTypeTombstone)
+}
+
+func (w *SstFileWriter) add(key, value []byte, typ internal.RecordType) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if w.last != nil && w.cmp.CompareUser(w.last, key) >= 0 {
+		return ErrKeyOutOfOrder
+	}
+	// The sequence number is assigned when the file is ingested.
+	if err := w.builder.Add(internal.EncodeInternalKey(key, 0, typ), value); err != nil {
+		return err
+	}
+	w.last = append(w.last[:0], key...)
+	w.count++
+	return nil
+}
+
+// Finish completes the file. A file without keys is removed and
+// ErrInvalidExternalFile returned.
+func (w *SstFileWriter) Finish() error {
+	if err := w.builder.Close(); err != nil {
+		return err
+	}
+	if w.count == 0 {
+		os.Remove(w.path)
+		return ErrInvalidExternalFile
+	}
+	return nil
+}
+
+// externalFile is a validated external table awaiting ingestion.
+type externalFile struct {
+	path              string
+	smallest, largest []byte // User keys.
+
+	fileNum uint64
+	level   int
+	meta    SSTableMeta
+}
+
+// IngestExternalFiles adds tables written by SstFileWriter to the default
+// column family without going through the WAL or the memtable.
+//
+// Each file's keys must ascend, once each, in the DB's key order, and no two
+// files may overlap. All keys take one new sequence number, so they shadow
+// every earlier write. Each file is copied into the DB at the deepest level
+// that has no overlapping table at or above it; the originals are left in
+// place. Writes wait while the files are copied.
+func (db *DB) IngestExternalFiles(paths []string) error {
+	if err := db.checkBackgroundError(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	files := make([]*externalFile, len(paths))
+	for i, path := range paths {
+		f, err := db.scanExternalFile(path)
+		if err != nil {
+			return err
+		}
+		files[i] = f
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return db.cmp.CompareUser(files[i].smallest, files[j].smallest) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if db.cmp.CompareUser(files[i-1].largest, files[i].smallest) >= 0 {
+			return ErrExternalFilesOverlap
+		}
+	}
+
+	// Ingest as a write of its own, so no batch holds a sequence number
+	// below the one the files take.
+	w := &writer{run: func() error { return db.ingest(files) }}
+	if err := db.write(w); err != nil {
+		return err
+	}
+	db.MaybeScheduleCompaction()
+	return nil
+}
+
+// scanExternalFile checks a file's keys and finds its key range.
+func (db *DB) scanExternalFile(path string) (*externalFile, error) {
+	r, err := sstable.NewReader(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	r.SetComparator(db.cmp.Compare)
+
+	if len(r.RangeTombstones()) > 0 {
+		return nil, ErrInvalidExternalFile
+	}
+	it, err := r.NewIterator()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &externalFile{path: path}
+	var last []byte
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		userKey := internal.ExtractUserKey(it.Key())
+		_, typ, err := internal.ExtractTrailer(it.Key())
+		if err != nil {
+			return nil, ErrInvalidExternalFile
+		}
+		switch typ {
+		case internal.RecordTypeValue, internal.RecordTypeTombstone, internal.RecordTypeExpiringValue:
+		case internal.RecordTypeMerge:
+			if db.columnFamily.opts.MergeOperator == nil {
+				return nil, ErrNoMergeOperator
+			}
+		default:
+			return nil, ErrInvalidExternalFile
+		}
+
+		if last != nil && db.cmp.CompareUser(last, userKey) >= 0 {
+			return nil, ErrKeyOutOfOrder
+		}
+		if f.smallest == nil {
+			f.smallest = append([]byte(nil), userKey...)
+		}
+		last = append(last[:0], userKey...)
+	}
+	if it.Err() != nil {
+		return nil, ErrInvalidExternalFile
+	}
+	if last == nil {
+		return nil, ErrInvalidExternalFile
+	}
+	f.largest = last
+	return f, nil
+}
+
+// ingest installs files under one new sequence number.
+// It runs as the leader of the write queue, so no other write is in flight.
+func (db *DB) ingest(files []*externalFile) error {
+	cf := db.columnFamily
+
+	// Memtables are read before every table; older versions of the files'
+	// keys there would hide the ingested ones.
+	db.mu.Lock()
+	overlaps := false
+	for _, mt := range cf.memtablesLocked() {
+		for _, f := range files {
+			if db.memtableOverlaps(mt, f.smallest, f.largest) {
+				overlaps = true
+			}
+		}
+	}
+	if overlaps && !cf.memtable.Empty() {
+		db.rotateMemtableLocked(cf)
+	}
+	db.mu.Unlock()
+	if overlaps {
+		db.MaybeScheduleFlush()
+		if err := db.checkBackgroundError(); err != nil {
+			return err
+		}
+	}
+
+	// No compaction may reshape the levels until the files are placed.
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	db.mu.Lock()
+	if cf.dropped {
+		db.mu.Unlock()
+		return ErrColumnFamilyDropped
+	}
+	seq := db.nextSeq
+	ver := cf.version.Current()
+	for _, f := range files {
+		f.fileNum = db.nextFileNum
+		db.nextFileNum++
+		f.level = db.ingestLevel(ver, f.smallest, f.largest)
+	}
+	db.mu.Unlock()
+	db.releaseVersion(ver)
+
+	fail := func(err error) error {
+		for _, f := range files {
+			os.Remove(filepath.Join(db.dir, fmt.Sprintf("%06d.sst", f.fileNum)))
+		}
+		return err
+	}
+	for _, f := range files {
+		meta, err := db.copyExternalFile(cf, f, seq)
+		if err != nil {
+			return fail(err)
+		}
+		f.meta = meta
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	edit := VersionEdit{}
+	for _, f := range files {
+		rec := manifest.Record{
+			Type: manifest.RecordTypeAddSSTable,
+			Data: manifest.AddSSTable{
+				FileNum:      f.meta.FileNum,
+				Level:        f.meta.Level,
+				SmallestKey:  f.meta.SmallestKey,
+				LargestKey:   f.meta.LargestKey,
+				SmallestSeq:  f.meta.SmallestSeq,
+				LargestSeq:   f.meta.LargestSeq,
+				FileSize:     f.meta.FileSize,
+				ColumnFamily: cf.id,
+			},
+		}
+		if err := db.manifest.Append(rec); err != nil {
+			return fail(err)
+		}
+		edit.Added = append(edit.Added, f.meta)
+	}
+	if err := cf.version.Apply(edit); err != nil {
+		return err
+	}
+	db.nextSeq = seq + 1
+	return nil
+}
+
+// memtableOverlaps reports whether mt holds a key in [smallest, largest].
+func (db *DB) memtableOverlaps(mt *memtable.Memtable, smallest, largest []byte) bool {
+	it := mt.Iterator()
+	it.Seek(internal.SeekKey(smallest, internal.MaxSequenceNumber))
+	return it.Valid() && db.cmp.CompareUser(internal.ExtractUserKey(it.Key()), largest) <= 0
+}
+
+// ingestLevel returns the deepest level that, like every level above it,
+// has no table overlapping [smallest, largest].
+func (db *DB) ingestLevel(ver *Version, smallest, largest []byte) int {
+	start := internal.SeekKey(smallest, internal.MaxSequenceNumber)
+	end := internal.SeekKey(largest, 0)
+
+	level := 0
+	for l := 0; l < NumLevels; l++ {
+		if len(ver.OverlappingInputs(l, start, end)) > 0 {
+			break
+		}
+		level = l
+	}
+	return level
+}
+
+// copyExternalFile writes f into the DB as table f.fileNum, its keys at seq.
+func (db *DB) copyExternalFile(cf *columnFamily, f *externalFile, seq uint64) (SSTableMeta, error) {
+	r, err := sstable.NewReader(f.path, nil)
+	if err != nil {
+		return SSTableMeta{}, err
+	}
+	defer r.Close()
+	r.SetComparator(cf.cmp.Compare)
+	it, err := r.NewIterator()
+	if err != nil {
+		return SSTableMeta{}, err
+	}
+
+	filename := filepath.Join(db.dir, fmt.Sprintf("%06d.sst", f.fileNum))
+	b, err := sstable.NewBuilder(filename, db.builderOptions(cf, f.level))
+	if err != nil {
+		return SSTableMeta{}, err
+	}
+	b.SetComparator(cf.cmp)
+	if p := cf.opts.PrefixExtractor; p != nil {
+		b.SetPrefixExtractor(p)
+	}
+
+	var smallest, largest []byte
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		_, typ, _ := internal.ExtractTrailer(it.Key())
+		key := internal.EncodeInternalKey(internal.ExtractUserKey(it.Key()), seq, typ)
+		if err := b.Add(key, it.Value()); err != nil {
+			b.Close()
+			return SSTableMeta{}, err
+		}
+		if smallest == nil {
+			smallest = key
+		}
+		largest = key
+	}
+	if it.Err() != nil {
+		b.Close()
+		return SSTableMeta{}, ErrInvalidExternalFile
+	}
+	if err := b.Close(); err != nil {
+		return SSTableMeta{}, err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return SSTableMeta{}, err
+	}
+	return SSTableMeta{
+		FileNum:     f.fileNum,
+		Level:       uint32(f.level),
+		SmallestSeq: seq,
+		LargestSeq:  seq,
+		SmallestKey: smallest,
+		LargestKey:  largest,
+		FileSize:    info.Size(),
+	}, nil
+}
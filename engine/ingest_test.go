@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeExternal(t *testing.T, path string, from, to int, value string) {
+	t.Helper()
+	w, err := NewSstFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < to; i++ {
+		if err := w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngestExternalFiles(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Existing data: k000-k049 in L1, k040 again in the memtable.
+	for i := 0; i < 50; i++ {
+		db.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("old"))
+	}
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("k040"), []byte("mem"))
+	snap := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snap)
+
+	ext := t.TempDir()
+	overlapping := filepath.Join(ext, "a.sst")
+	disjoint := filepath.Join(ext, "b.sst")
+	writeExternal(t, overlapping, 30, 60, "ingested")
+	writeExternal(t, disjoint, 100, 120, "bulk")
+
+	if err := db.IngestExternalFiles([]string{disjoint, overlapping}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The overlapping file lands above L1; the other one at the bottom.
+	levels := map[string]int{}
+	for l := 0; l < NumLevels; l++ {
+		for _, meta := range db.version.LevelFiles(l) {
+			if meta.SmallestSeq == meta.LargestSeq && meta.LargestSeq == snap.ReadSeq+1 {
+				levels[string(meta.SmallestKey[:4])] = l
+			}
+		}
+	}
+	if levels["k030"] != 0 || levels["k100"] != NumLevels-1 {
+		t.Fatalf("unexpected ingest levels %v", levels)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		expectValue(t, db, "k000", "old")
+		expectValue(t, db, "k030", "ingested")
+		expectValue(t, db, "k040", "ingested")
+		expectValue(t, db, "k059", "ingested")
+		expectValue(t, db, "k110", "bulk")
+	}
+	check(db)
+
+	// Older snapshots do not see ingested keys.
+	if v, err := db.GetWithOptions([]byte("k040"), &ReadOptions{Snapshot: snap}); err != nil || string(v) != "mem" {
+		t.Fatalf("expected mem under the snapshot, got %q (%v)", v, err)
+	}
+
+	// Later writes shadow ingested keys.
+	db.Put([]byte("k031"), []byte("new"))
+	expectValue(t, db, "k031", "new")
+
+	db.Close()
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	expectValue(t, db, "k031", "new")
+	db.Put([]byte("k032"), []byte("after reopen"))
+	expectValue(t, db, "k032", "after reopen")
+}
+
+func TestIngestRejectsBadFiles(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ext := t.TempDir()
+	w, err := NewSstFileWriter(filepath.Join(ext, "order.sst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Put([]byte("b"), []byte("1"))
+	if err := w.Put([]byte("a"), []byte("1")); err != ErrKeyOutOfOrder {
+		t.Fatalf("expected ErrKeyOutOfOrder, got %v", err)
+	}
+	if err := w.Put([]byte("b"), []byte("2")); err != ErrKeyOutOfOrder {
+		t.Fatalf("expected ErrKeyOutOfOrder for a repeated key, got %v", err)
+	}
+	w.Finish()
+
+	empty, err := NewSstFileWriter(filepath.Join(ext, "empty.sst"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := empty.Finish(); err != ErrInvalidExternalFile {
+		t.Fatalf("expected ErrInvalidExternalFile, got %v", err)
+	}
+
+	a, b := filepath.Join(ext, "a.sst"), filepath.Join(ext, "b.sst")
+	writeExternal(t, a, 0, 10, "a")
+	writeExternal(t, b, 9, 20, "b")
+	if err := db.IngestExternalFiles([]string{a, b}); err != ErrExternalFilesOverlap {
+		t.Fatalf("expected ErrExternalFilesOverlap, got %v", err)
+	}
+
+	// A file built outside the writer, with keys out of order.
+	bad := filepath.Join(ext, "bad.sst")
+	unsorted, err := NewSstFileWriter(bad, &Config{Comparator: reverseComparator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsorted.Put([]byte("k2"), nil)
+	unsorted.Put([]byte("k1"), nil)
+	unsorted.Finish()
+	if err := db.IngestExternalFiles([]string{bad}); err != ErrKeyOutOfOrder {
+		t.Fatalf("expected ErrKeyOutOfOrder, got %v", err)
+	}
+
+	// A data block past the first fails its checksum.
+	corrupt := filepath.Join(ext, "corrupt.sst")
+	writeExternal(t, corrupt, 0, 1000, strings.Repeat("v", 100))
+	data, err := os.ReadFile(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xFF
+	if err := os.WriteFile(corrupt, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IngestExternalFiles([]string{corrupt}); err != ErrInvalidExternalFile {
+		t.Fatalf("expected ErrInvalidExternalFile, got %v", err)
+	}
+
+	if _, err := db.Get([]byte("k005")); err != ErrNotFound {
+		t.Fatalf("expected nothing ingested, got %v", err)
+	}
+}
@@ -1,54 +1,213 @@
 package engine
 
 import (
+	"bytes"
+
 	"vern_kv0.8/internal"
 	"vern_kv0.8/iterators"
 )
 
 // Iterator is a user-facing iterator.
+// Seek positions at the first key >= target; SeekForPrev at the last key <= target.
 type Iterator interface {
 	SeekToFirst()
+	SeekToLast()
+	Seek(key []byte)
+	SeekForPrev(key []byte)
 	Next()
+	Prev()
 	Valid() bool
 	Key() []byte
 	Value() []byte
+
+	// Close releases the iterator's pinned version and table readers.
+	// Files compacted away while the iterator was open are deleted once it closes.
+	Close() error
 }
 
+// dbIterator resolves the versions of each user key into a single entry.
+// inner yields every version; entries of one user key are adjacent, newest first.
+//
+// Moving forward, inner rests on the first entry after the current user key.
+// Moving backward, it rests on the last entry before it.
 type dbIterator struct {
-	inner iterators.InternalIterator
+	inner     iterators.InternalIterator
+	merge     MergeOperator
+	rangeDels *rangeDelSet
+
+	// TTL entries expire relative to now, fixed when the iterator was created.
+	now            int64
+	includeExpired bool
+
+	forward bool
+	valid   bool
+	key     []byte
+	value   []byte
+	err     error
+
+	db      *DB
+	version *Version
+
+	// release unpins the table readers backing inner.
+	release []func()
 }
 
 func (it *dbIterator) SeekToFirst() {
 	it.inner.SeekToFirst()
-	it.skipTombstones()
+	it.forward = true
+	it.findNextUserEntry()
+}
+
+func (it *dbIterator) SeekToLast() {
+	it.inner.SeekToLast()
+	it.forward = false
+	it.findPrevUserEntry()
+}
+
+func (it *dbIterator) Seek(key []byte) {
+	it.inner.Seek(internal.SeekKey(key, internal.MaxSequenceNumber))
+	it.forward = true
+	it.findNextUserEntry()
+}
+
+func (it *dbIterator) SeekForPrev(key []byte) {
+	it.inner.SeekForPrev(internal.SeekKeyForPrev(key))
+	it.forward = false
+	it.findPrevUserEntry()
 }
 
 func (it *dbIterator) Next() {
-	it.inner.Next()
-	it.skipTombstones()
+	if !it.valid {
+		return
+	}
+	if !it.forward {
+		// Step over the current key's versions.
+		it.inner.Seek(internal.SeekKeyForPrev(it.key))
+		it.forward = true
+	}
+	it.findNextUserEntry()
 }
 
-func (it *dbIterator) skipTombstones() {
+func (it *dbIterator) Prev() {
+	if !it.valid {
+		return
+	}
+	if it.forward {
+		// Step back before the current key's versions.
+		it.inner.SeekForPrev(internal.SeekKey(it.key, internal.MaxSequenceNumber))
+		it.forward = false
+	}
+	it.findPrevUserEntry()
+}
+
+// findNextUserEntry resolves user keys from inner's position onwards until one is visible.
+func (it *dbIterator) findNextUserEntry() {
 	for it.inner.Valid() {
-		_, typ, _ := internal.ExtractTrailer(it.inner.Key())
-		if typ == internal.RecordTypeTombstone {
+		userKey := append([]byte(nil), internal.ExtractUserKey(it.inner.Key())...)
+		coverSeq := it.rangeDels.coveringSeq(userKey)
+		state := mergeState{op: it.merge, key: userKey, blobs: it.db.blobs}
+
+		for it.inner.Valid() && bytes.Equal(internal.ExtractUserKey(it.inner.Key()), userKey) {
+			if !state.done() {
+				state.add(it.entry(coverSeq))
+			}
 			it.inner.Next()
-			continue
 		}
-		break
+
+		if it.resolve(userKey, &state) {
+			return
+		}
+	}
+	it.valid = false
+}
+
+// findPrevUserEntry resolves user keys from inner's position backwards until one is visible.
+func (it *dbIterator) findPrevUserEntry() {
+	for it.inner.Valid() {
+		userKey := append([]byte(nil), internal.ExtractUserKey(it.inner.Key())...)
+
+		// Versions arrive oldest first; replay them newest first.
+		var types []internal.RecordType
+		var values [][]byte
+		coverSeq := it.rangeDels.coveringSeq(userKey)
+		for it.inner.Valid() && bytes.Equal(internal.ExtractUserKey(it.inner.Key()), userKey) {
+			typ, value := it.entry(coverSeq)
+			types = append(types, typ)
+			values = append(values, value)
+			it.inner.Prev()
+		}
+
+		state := mergeState{op: it.merge, key: userKey, blobs: it.db.blobs}
+		for i := len(types) - 1; i >= 0 && !state.done(); i-- {
+			state.add(types[i], values[i])
+		}
+
+		if it.resolve(userKey, &state) {
+			return
+		}
+	}
+	it.valid = false
+}
+
+// coveredType returns key's record type, or Tombstone if a range tombstone
+// newer than key (coverSeq) deletes it.
+func coveredType(key []byte, coverSeq uint64) internal.RecordType {
+	seq, typ, _ := internal.ExtractTrailer(key)
+	if seq < coverSeq {
+		return internal.RecordTypeTombstone
 	}
+	return typ
+}
+
+// entry returns the type and value inner's current version reads as,
+// after range tombstones and TTL expiry.
+func (it *dbIterator) entry(coverSeq uint64) (internal.RecordType, []byte) {
+	return resolveExpiry(coveredType(it.inner.Key(), coverSeq), it.inner.Value(), it.now, it.includeExpired)
+}
+
+// resolve positions the iterator on userKey if it is visible.
+// It returns false if the key should be skipped.
+func (it *dbIterator) resolve(userKey []byte, state *mergeState) bool {
+	value, err := state.result()
+	if err == ErrNotFound {
+		return false
+	}
+	if err != nil {
+		// Stop iteration; Close reports the error.
+		it.err = err
+		it.valid = false
+		return true
+	}
+	it.key = userKey
+	it.value = value
+	it.valid = true
+	return true
 }
 
 func (it *dbIterator) Valid() bool {
-	return it.inner.Valid()
+	return it.valid
 }
 
 // Key returns user key.
 func (it *dbIterator) Key() []byte {
-	return internal.ExtractUserKey(it.inner.Key())
+	return it.key
 }
 
-// Value returns the associated value.
+// Value returns the resolved value.
 func (it *dbIterator) Value() []byte {
-	return it.inner.Value()
+	return it.value
+}
+
+// Close also reports any merge error that ended iteration early.
+func (it *dbIterator) Close() error {
+	if it.version == nil {
+		return it.err
+	}
+	for _, r := range it.release {
+		r()
+	}
+	it.release = nil
+	it.db.releaseVersion(it.version)
+	it.version = nil
+	return it.err
 }
@@ -1,6 +1,11 @@
 package engine
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestSnapshotIteratorStability(t *testing.T) {
 	dir := t.TempDir()
@@ -52,3 +57,99 @@ func TestIteratorDeleteVisibility(t *testing.T) {
 		t.Fatalf("unexpected value")
 	}
 }
+
+func TestIteratorReverseAndSeek(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("c"), []byte("3"))
+	db.Put([]byte("e"), []byte("5"))
+
+	// Push the first versions to an SSTable.
+	db.freezeMemtable()
+
+	db.Put([]byte("c"), []byte("33"))
+	db.Put([]byte("d"), []byte("4"))
+	db.Delete([]byte("e"))
+
+	it := db.NewIterator(nil)
+
+	var keys []string
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		keys = append(keys, string(it.Key())+"="+string(it.Value()))
+	}
+	if len(keys) != 3 || keys[0] != "d=4" || keys[1] != "c=33" || keys[2] != "a=1" {
+		t.Fatalf("unexpected reverse scan: %v", keys)
+	}
+
+	it.Seek([]byte("b"))
+	if !it.Valid() || string(it.Key()) != "c" || string(it.Value()) != "33" {
+		t.Fatalf("Seek(b) should land on c=33")
+	}
+
+	it.SeekForPrev([]byte("b"))
+	if !it.Valid() || string(it.Key()) != "a" {
+		t.Fatalf("SeekForPrev(b) should land on a")
+	}
+
+	// Deleted e is skipped in both directions.
+	it.SeekForPrev([]byte("z"))
+	if !it.Valid() || string(it.Key()) != "d" {
+		t.Fatalf("SeekForPrev(z) should land on d")
+	}
+	it.Seek([]byte("e"))
+	if it.Valid() {
+		t.Fatalf("Seek(e) should be exhausted, got %s", it.Key())
+	}
+}
+
+func TestIteratorPinsFilesAcrossCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1"))
+	db.freezeMemtable()
+	db.Put([]byte("b"), []byte("2"))
+	db.freezeMemtable()
+
+	it := db.NewIterator(nil)
+	l0 := db.version.LevelFiles(0)
+
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	db.cleanupObsoleteFiles()
+
+	for _, meta := range l0 {
+		path := filepath.Join(dir, fmt.Sprintf("%06d.sst", meta.FileNum))
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("input %d deleted under a live iterator", meta.FileNum)
+		}
+	}
+
+	var keys []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys from pinned version, got %v", keys)
+	}
+
+	it.Close()
+
+	for _, meta := range l0 {
+		path := filepath.Join(dir, fmt.Sprintf("%06d.sst", meta.FileNum))
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("input %d should be deleted once the iterator closes", meta.FileNum)
+		}
+	}
+}
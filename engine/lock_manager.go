@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLockTimeout = errors.New("lock wait timed out")
+	ErrDeadlock    = errors.New("deadlock detected")
+)
+
+// lockManager hands out exclusive per-key locks to transactions.
+// Waiters record whom they wait for; a request that would close a cycle
+// in that wait-for graph fails with ErrDeadlock instead of blocking.
+type lockManager struct {
+	mu      sync.Mutex
+	locks   map[string]*keyLock
+	waitFor map[uint64]uint64 // Waiting txn -> holder it waits on.
+}
+
+type keyLock struct {
+	holder   uint64
+	released chan struct{} // Closed when the holder lets go.
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:   make(map[string]*keyLock),
+		waitFor: make(map[uint64]uint64),
+	}
+}
+
+// lock acquires key for txn, waiting up to timeout.
+// Re-locking a key txn already holds succeeds immediately.
+func (lm *lockManager) lock(txn uint64, key string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		lm.mu.Lock()
+		l, held := lm.locks[key]
+		if !held {
+			lm.locks[key] = &keyLock{holder: txn, released: make(chan struct{})}
+			delete(lm.waitFor, txn)
+			lm.mu.Unlock()
+			return nil
+		}
+		if l.holder == txn {
+			lm.mu.Unlock()
+			return nil
+		}
+
+		if lm.reachesLocked(l.holder, txn) {
+			delete(lm.waitFor, txn)
+			lm.mu.Unlock()
+			return ErrDeadlock
+		}
+		lm.waitFor[txn] = l.holder
+		released := l.released
+		lm.mu.Unlock()
+
+		select {
+		case <-released:
+			// Retry; another waiter may win the race.
+		case <-timer.C:
+			lm.mu.Lock()
+			delete(lm.waitFor, txn)
+			lm.mu.Unlock()
+			return ErrLockTimeout
+		}
+	}
+}
+
+// reachesLocked reports whether following wait-for edges from txn leads to target.
+func (lm *lockManager) reachesLocked(txn, target uint64) bool {
+	seen := make(map[uint64]bool)
+	for !seen[txn] {
+		if txn == target {
+			return true
+		}
+		seen[txn] = true
+		next, ok := lm.waitFor[txn]
+		if !ok {
+			return false
+		}
+		txn = next
+	}
+	return false
+}
+
+// unlock releases the keys held by txn and wakes their waiters.
+func (lm *lockManager) unlock(txn uint64, keys []string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, key := range keys {
+		if l, ok := lm.locks[key]; ok && l.holder == txn {
+			delete(lm.locks, key)
+			close(l.released)
+		}
+	}
+	delete(lm.waitFor, txn)
+}
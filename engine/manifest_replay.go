@@ -3,50 +3,150 @@ package engine
 import (
 	"os"
 
+	"vern_kv0.8/internal"
 	"vern_kv0.8/manifest"
 )
 
+// replayedFamily is a column family rebuilt from the manifest.
+type replayedFamily struct {
+	id      uint32
+	name    string
+	cmp     internal.Comparator
+	version *VersionSet
+	dropped bool
+
+	comparator string // Ordering the manifest records; empty is the DB's.
+}
+
 // ReplayManifest rebuilds VersionSet from manifest file.
+// Only the default column family is returned. The DB must use the bytewise comparator.
 func ReplayManifest(path string) (*VersionSet, error) {
-	data, err := os.ReadFile(path)
+	families, _, err := replayManifest(path, internal.Comparator{}, nil)
 	if err != nil {
 		return nil, err
 	}
+	return families[0].version, nil
+}
 
-	vs := NewVersionSet()
-	offset := 0
+// replayManifest rebuilds every column family, default first, and
+// returns the next free family ID.
+// A dropped family keeps its tables and blob files as obsolete so they can be deleted.
+// The default family is ordered by cmp, others by their entry in families or
+// else by cmp. It fails with ErrComparatorMismatch unless the manifest names
+// the same ordering for every live family; manifests naming none were written
+// bytewise.
+func replayManifest(path string, cmp internal.Comparator, families map[string]internal.Comparator) ([]*replayedFamily, uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
 
+	replayed := []*replayedFamily{{
+		id:      defaultColumnFamilyID,
+		name:    DefaultColumnFamilyName,
+		cmp:     cmp,
+		version: newVersionSet(cmp),
+	}}
+	comparator := internal.BytewiseComparator.Name()
+	byID := map[uint32]*replayedFamily{defaultColumnFamilyID: replayed[0]}
+	nextID := defaultColumnFamilyID + 1
+
+	offset := 0
 	for offset < len(data) {
 		rec, n, err := manifest.DecodeRecord(data[offset:])
 		if err != nil {
 			// Hard stop on corruption
 			break
 		}
+		offset += n
 
 		switch rec.Type {
+		case manifest.RecordTypeSetComparator:
+			comparator = rec.Data.(manifest.SetComparator).Name
+
 		case manifest.RecordTypeAddSSTable:
 			r := rec.Data.(manifest.AddSSTable)
-			vs.AddTable(SSTableMeta{
-				FileNum:     r.FileNum,
-				Level:       r.Level,
-				SmallestSeq: r.SmallestSeq,
-				LargestSeq:  r.LargestSeq,
-				SmallestKey: r.SmallestKey,
-				LargestKey:  r.LargestKey,
-				FileSize:    r.FileSize,
-			})
+			if f, ok := byID[r.ColumnFamily]; ok {
+				f.version.AddTable(SSTableMeta{
+					FileNum:     r.FileNum,
+					Level:       r.Level,
+					SmallestSeq: r.SmallestSeq,
+					LargestSeq:  r.LargestSeq,
+					SmallestKey: r.SmallestKey,
+					LargestKey:  r.LargestKey,
+					FileSize:    r.FileSize,
+				})
+			}
 
 		case manifest.RecordTypeRemoveSSTable:
 			r := rec.Data.(manifest.RemoveSSTable)
-			vs.RemoveTable(r.FileNum)
+			if f, ok := byID[r.ColumnFamily]; ok {
+				f.version.RemoveTable(r.FileNum)
+			}
 
 		case manifest.RecordTypeSetWALCutoff:
 			r := rec.Data.(manifest.SetWALCutoff)
-			vs.SetWALCutoff(r.Seq)
-		}
+			if f, ok := byID[r.ColumnFamily]; ok {
+				f.version.SetWALCutoff(r.Seq)
+			}
 
-		offset += n
+		case manifest.RecordTypeAddBlobFile:
+			r := rec.Data.(manifest.AddBlobFile)
+			if f, ok := byID[r.ColumnFamily]; ok {
+				f.version.Apply(VersionEdit{AddedBlobs: []BlobFileMeta{{
+					FileNum: r.FileNum,
+					Count:   r.Count,
+					Size:    r.Size,
+				}}})
+			}
+
+		case manifest.RecordTypeBlobGarbage:
+			r := rec.Data.(manifest.BlobGarbage)
+			if f, ok := byID[r.ColumnFamily]; ok {
+				f.version.Apply(VersionEdit{BlobGarbage: []BlobGarbage{{
+					FileNum: r.FileNum,
+					Count:   r.Count,
+					Size:    r.Size,
+				}}})
+			}
+
+		case manifest.RecordTypeCreateColumnFamily:
+			r := rec.Data.(manifest.CreateColumnFamily)
+			fcmp := cmp
+			if c, ok := families[r.Name]; ok {
+				fcmp = c
+			}
+			f := &replayedFamily{
+				id:         r.ID,
+				name:       r.Name,
+				cmp:        fcmp,
+				version:    newVersionSet(fcmp),
+				comparator: r.Comparator,
+			}
+			replayed = append(replayed, f)
+			byID[r.ID] = f
+			if r.ID >= nextID {
+				nextID = r.ID + 1
+			}
+
+		case manifest.RecordTypeDropColumnFamily:
+			r := rec.Data.(manifest.DropColumnFamily)
+			if f, ok := byID[r.ID]; ok && !f.dropped {
+				f.dropped = true
+				f.version.Apply(f.version.dropEdit())
+			}
+		}
 	}
 
-	return vs, nil
+	for _, f := range replayed {
+		want := f.comparator
+		if want == "" {
+			want = comparator
+		}
+		// A dropped family's tables are only deleted, never read.
+		if !f.dropped && want != f.cmp.Name() {
+			return nil, 0, ErrComparatorMismatch
+		}
+	}
+	return replayed, nextID, nil
 }
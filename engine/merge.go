@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"errors"
+
+	"vern_kv0.8/internal"
+)
+
+// ErrNoMergeOperator is returned when a merge operand is written or read
+// without Config.MergeOperator set.
+var ErrNoMergeOperator = errors.New("merge operator not configured")
+
+// MergeOperator folds merge operands into a value.
+// The same operator (by Name) must be configured every time the DB is opened.
+type MergeOperator interface {
+	// Name identifies the operator.
+	Name() string
+
+	// FullMerge applies operands, oldest first, to existing.
+	// existing is nil if the key has no value below the operands.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge combines two adjacent operands (left is older) into one.
+	// It returns false if the pair cannot be combined without a base value.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+}
+
+// mergeState resolves a key from its versions, fed newest first.
+type mergeState struct {
+	op    MergeOperator
+	key   []byte
+	blobs *blobCache
+
+	operands [][]byte // Newest first.
+	base     []byte
+	found    bool // Saw a Value or Tombstone.
+	deleted  bool
+	blobRef  bool // base is a blob index, fetched only if the result needs it.
+}
+
+// add feeds the next older version.
+// It returns false once the key is resolved and older versions are irrelevant.
+func (s *mergeState) add(typ internal.RecordType, value []byte) bool {
+	switch typ {
+	case internal.RecordTypeMerge:
+		s.operands = append(s.operands, value)
+		return true
+	case internal.RecordTypeTombstone:
+		s.deleted = true
+	case internal.RecordTypeBlobIndex:
+		s.base = value
+		s.blobRef = true
+	default:
+		s.base = value
+	}
+	s.found = true
+	return false
+}
+
+// done reports whether older versions can be skipped.
+func (s *mergeState) done() bool {
+	return s.found
+}
+
+// result returns the resolved value, or ErrNotFound if the key is absent.
+func (s *mergeState) result() ([]byte, error) {
+	if len(s.operands) == 0 {
+		if !s.found || s.deleted {
+			return nil, ErrNotFound
+		}
+		return s.baseValue()
+	}
+	if s.op == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	var existing []byte
+	if !s.deleted {
+		var err error
+		if existing, err = s.baseValue(); err != nil {
+			return nil, err
+		}
+	}
+	return s.op.FullMerge(s.key, existing, reverseOperands(s.operands))
+}
+
+// baseValue returns the base value, reading it from its blob file if separated.
+func (s *mergeState) baseValue() ([]byte, error) {
+	if !s.blobRef || s.base == nil {
+		return s.base, nil
+	}
+	return s.blobs.Get(s.base)
+}
+
+// reverseOperands returns a newest-first operand list oldest first.
+func reverseOperands(ops [][]byte) [][]byte {
+	out := make([][]byte, len(ops))
+	for i, op := range ops {
+		out[len(ops)-1-i] = op
+	}
+	return out
+}
+
+// compactionEntry is one version of a user key flowing through compaction.
+type compactionEntry struct {
+	key   []byte
+	value []byte
+}
+
+// collapseMerges shrinks runs of merge operands for one user key.
+// entries are newest first. snapshots lists live snapshot sequences ascending;
+// a run is only collapsed within a single snapshot stripe so every snapshot
+// still resolves the same value. bottom reports that no older version of the
+// key can exist outside entries.
+func collapseMerges(op MergeOperator, entries []compactionEntry, snapshots []uint64, bottom bool) []compactionEntry {
+	if op == nil {
+		return entries
+	}
+
+	out := make([]compactionEntry, 0, len(entries))
+	for i := 0; i < len(entries); {
+		seq, typ, _ := internal.ExtractTrailer(entries[i].key)
+		if typ != internal.RecordTypeMerge {
+			out = append(out, entries[i])
+			i++
+			continue
+		}
+
+		// Extend the run while operands stay in the same stripe.
+		stripe := snapshotStripe(seq, snapshots)
+		j := i + 1
+		for j < len(entries) {
+			s, t, _ := internal.ExtractTrailer(entries[j].key)
+			if t != internal.RecordTypeMerge || snapshotStripe(s, snapshots) != stripe {
+				break
+			}
+			j++
+		}
+
+		userKey := internal.ExtractUserKey(entries[i].key)
+		run := entries[i:j]
+		operands := make([][]byte, len(run))
+		for k, e := range run {
+			operands[len(run)-1-k] = e.value
+		}
+
+		// Try to fold the run into a value.
+		var (
+			existing []byte
+			full     bool
+			consumed = j
+		)
+		if j < len(entries) {
+			s, t, _ := internal.ExtractTrailer(entries[j].key)
+			if snapshotStripe(s, snapshots) == stripe {
+				switch t {
+				case internal.RecordTypeValue:
+					existing, full, consumed = entries[j].value, true, j+1
+				case internal.RecordTypeTombstone:
+					full = true
+				}
+			}
+		} else if bottom {
+			full = true
+		}
+
+		if full {
+			value, err := op.FullMerge(userKey, existing, operands)
+			if err == nil {
+				out = append(out, compactionEntry{
+					key:   internal.EncodeInternalKey(userKey, seq, internal.RecordTypeValue),
+					value: value,
+				})
+				i = consumed
+				continue
+			}
+		}
+
+		// Otherwise combine adjacent operands, keeping the newest seq of each.
+		out = append(out, partialMergeRun(op, userKey, run)...)
+		i = j
+	}
+	return out
+}
+
+// partialMergeRun combines a newest-first run of operands pairwise.
+func partialMergeRun(op MergeOperator, userKey []byte, run []compactionEntry) []compactionEntry {
+	// Fold oldest to newest.
+	var folded []compactionEntry
+	acc := run[len(run)-1]
+	for k := len(run) - 2; k >= 0; k-- {
+		if merged, ok := op.PartialMerge(userKey, acc.value, run[k].value); ok {
+			acc = compactionEntry{key: run[k].key, value: merged}
+			continue
+		}
+		folded = append(folded, acc)
+		acc = run[k]
+	}
+	folded = append(folded, acc)
+
+	// Back to newest first.
+	for l, r := 0, len(folded)-1; l < r; l, r = l+1, r-1 {
+		folded[l], folded[r] = folded[r], folded[l]
+	}
+	return folded
+}
+
+// snapshotStripe returns the index of the oldest snapshot that sees seq,
+// or len(snapshots) if only the latest state does.
+func snapshotStripe(seq uint64, snapshots []uint64) int {
+	for i, s := range snapshots {
+		if seq <= s {
+			return i
+		}
+	}
+	return len(snapshots)
+}
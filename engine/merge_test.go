@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"strconv"
+	"testing"
+
+	"vern_kv0.8/internal"
+)
+
+// counterMerge adds decimal operands.
+type counterMerge struct{}
+
+func (counterMerge) Name() string { return "counter" }
+
+func (counterMerge) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var n int
+	if existing != nil {
+		v, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, err
+		}
+		n = v
+	}
+	for _, op := range operands {
+		v, err := strconv.Atoi(string(op))
+		if err != nil {
+			return nil, err
+		}
+		n += v
+	}
+	return []byte(strconv.Itoa(n)), nil
+}
+
+func (counterMerge) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	l, err1 := strconv.Atoi(string(left))
+	r, err2 := strconv.Atoi(string(right))
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+	return []byte(strconv.Itoa(l + r)), true
+}
+
+func openMergeDB(t *testing.T) *DB {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.MergeOperator = counterMerge{}
+	db, err := Open(t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func expectValue(t *testing.T, db *DB, key, want string) {
+	t.Helper()
+	val, err := db.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if string(val) != want {
+		t.Fatalf("Get(%s) = %s, want %s", key, val, want)
+	}
+}
+
+func TestMergeWithoutOperator(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Merge([]byte("k"), []byte("1")); err != ErrNoMergeOperator {
+		t.Fatalf("expected ErrNoMergeOperator, got %v", err)
+	}
+}
+
+func TestMergeGet(t *testing.T) {
+	db := openMergeDB(t)
+
+	// Operands with no base.
+	db.Merge([]byte("a"), []byte("1"))
+	db.Merge([]byte("a"), []byte("2"))
+	expectValue(t, db, "a", "3")
+
+	// Operands over a value, split between an SSTable and the memtable.
+	db.Put([]byte("b"), []byte("10"))
+	db.Merge([]byte("b"), []byte("5"))
+	db.freezeMemtable()
+	db.Merge([]byte("b"), []byte("1"))
+	expectValue(t, db, "b", "16")
+
+	// A tombstone resets the base.
+	db.Delete([]byte("b"))
+	db.Merge([]byte("b"), []byte("4"))
+	expectValue(t, db, "b", "4")
+
+	// Snapshots resolve only the operands they can see.
+	snap := db.GetSnapshot()
+	db.Merge([]byte("a"), []byte("100"))
+	val, err := db.GetWithOptions([]byte("a"), &ReadOptions{Snapshot: snap})
+	if err != nil || string(val) != "3" {
+		t.Fatalf("snapshot Get = %s, %v", val, err)
+	}
+	db.ReleaseSnapshot(snap)
+	expectValue(t, db, "a", "103")
+}
+
+func TestMergeIterator(t *testing.T) {
+	db := openMergeDB(t)
+
+	db.Put([]byte("a"), []byte("1"))
+	db.Merge([]byte("b"), []byte("2"))
+	db.freezeMemtable()
+	db.Merge([]byte("a"), []byte("10"))
+	db.Merge([]byte("b"), []byte("3"))
+	db.Put([]byte("c"), []byte("7"))
+
+	want := []string{"a=11", "b=5", "c=7"}
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+
+	var got []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, string(it.Key())+"="+string(it.Value()))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("forward got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("forward got %v, want %v", got, want)
+		}
+	}
+
+	got = got[:0]
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		got = append(got, string(it.Key())+"="+string(it.Value()))
+	}
+	for i := range want {
+		if got[len(want)-1-i] != want[i] {
+			t.Fatalf("reverse got %v, want %v", got, want)
+		}
+	}
+
+	// Direction changes mid-scan.
+	it.Seek([]byte("b"))
+	it.Prev()
+	it.Next()
+	if !it.Valid() || string(it.Value()) != "5" {
+		t.Fatalf("expected b=5 after Prev/Next")
+	}
+}
+
+func TestCompactionCollapsesMergeOperands(t *testing.T) {
+	db := openMergeDB(t)
+
+	for i := 0; i < 20; i++ {
+		db.Merge([]byte("n"), []byte("1"))
+		if i%5 == 4 {
+			db.freezeMemtable()
+		}
+	}
+
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, "n", "20")
+
+	// Bottommost with no base: the stack becomes a single value.
+	count := 0
+	for _, meta := range db.version.GetAllTables() {
+		sst, release, err := db.tableCache.NewIterator(meta.FileNum, db.cmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for sst.SeekToFirst(); sst.Valid(); sst.Next() {
+			_, typ, _ := internal.ExtractTrailer(sst.Key())
+			if typ != internal.RecordTypeValue {
+				t.Fatalf("expected collapsed value, got type %d", typ)
+			}
+			count++
+		}
+		release()
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 entry after compaction, got %d", count)
+	}
+}
+
+func TestCollapseMergesRespectsSnapshots(t *testing.T) {
+	key := func(seq uint64, typ internal.RecordType) []byte {
+		return internal.EncodeInternalKey([]byte("k"), seq, typ)
+	}
+	entries := []compactionEntry{
+		{key(5, internal.RecordTypeMerge), []byte("1")},
+		{key(4, internal.RecordTypeMerge), []byte("1")},
+		{key(3, internal.RecordTypeMerge), []byte("1")},
+		{key(2, internal.RecordTypeValue), []byte("10")},
+	}
+
+	// A snapshot at 3 must still resolve 11, so 3 cannot fold into 4 and 5.
+	out := collapseMerges(counterMerge{}, entries, []uint64{3}, false)
+	if len(out) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(out))
+	}
+	seq, typ, _ := internal.ExtractTrailer(out[0].key)
+	if seq != 5 || typ != internal.RecordTypeMerge || string(out[0].value) != "2" {
+		t.Fatalf("unexpected newer stripe: seq=%d typ=%d val=%s", seq, typ, out[0].value)
+	}
+	seq, typ, _ = internal.ExtractTrailer(out[1].key)
+	if seq != 3 || typ != internal.RecordTypeValue || string(out[1].value) != "11" {
+		t.Fatalf("unexpected older stripe: seq=%d typ=%d val=%s", seq, typ, out[1].value)
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import "sort"
+
+// MultiGet looks up many keys in the default column family at once.
+// Results and errors are in the caller's order; a missing key has
+// ErrNotFound in its slot. Every key is read at one sequence number, so the
+// results are consistent with each other even without opts.Snapshot.
+//
+// Keys are resolved together: the memtables and version are taken once, and
+// each table is probed for all of its candidate keys in sorted order, so a
+// data block shared by several keys is read once.
+func (db *DB) MultiGet(keys [][]byte, opts *ReadOptions) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	if err := db.checkBackgroundError(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return values, errs
+	}
+
+	db.mu.RLock()
+	cf := db.columnFamily
+	readSeq := db.nextSeq - 1
+	if opts != nil && opts.Snapshot != nil {
+		readSeq = opts.Snapshot.ReadSeq
+	}
+	mems := cf.memtablesLocked()
+	ver := cf.version.Current()
+	db.mu.RUnlock()
+	defer db.releaseVersion(ver)
+
+	includeExpired := opts != nil && opts.IncludeExpired
+	now := db.now().UnixNano()
+
+	// Lookups sorted by key; order maps each back to the caller's slot.
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return cf.cmp.CompareUser(keys[order[a]], keys[order[b]]) < 0
+	})
+	lookups := make([]*pointLookup, len(keys))
+	for i, idx := range order {
+		lookups[i] = db.newPointLookup(cf, keys[idx], readSeq, now, includeExpired)
+	}
+
+	// Memtables.
+	for _, mt := range mems {
+		ts := mt.RangeTombstones()
+		for _, pl := range lookups {
+			if !pl.state.done() {
+				pl.cover(ts)
+				mt.Versions(pl.lookup, pl.collect)
+			}
+		}
+	}
+
+	// Tables. A table that fails to read fails only the keys it was probed for.
+	failed := make([]error, len(lookups))
+	probe := func(meta SSTableMeta) {
+		var batch []int
+		for i, pl := range lookups {
+			if failed[i] == nil && !pl.state.done() && meta.containsUserKey(cf.cmp, pl.key) {
+				batch = append(batch, i)
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		err := func() error {
+			ts, err := db.tableCache.RangeTombstones(meta.FileNum, cf.cmp)
+			if err != nil {
+				return err
+			}
+			probes := make([][]byte, len(batch))
+			for j, i := range batch {
+				lookups[i].cover(ts)
+				probes[j] = lookups[i].lookup
+			}
+			return db.tableCache.MultiVersions(meta.FileNum, cf.cmp, probes, func(j int, k, v []byte) bool {
+				return lookups[batch[j]].collect(k, v)
+			})
+		}()
+		if err != nil {
+			for _, i := range batch {
+				failed[i] = err
+			}
+		}
+	}
+
+	// L0 files overlap; newest first. Levels are probed in the same order as Get.
+	l0 := append([]SSTableMeta(nil), ver.Levels[0]...)
+	sort.Slice(l0, func(i, j int) bool {
+		return l0[i].FileNum > l0[j].FileNum
+	})
+	levels := [][]SSTableMeta{l0}
+	levels = append(levels, ver.Levels[1:]...)
+	for _, files := range levels {
+		for _, meta := range files {
+			probe(meta)
+		}
+	}
+
+	for i, idx := range order {
+		if failed[i] != nil {
+			errs[idx] = failed[i]
+			continue
+		}
+		values[idx], errs[idx] = lookups[i].state.result()
+	}
+	return values, errs
+}
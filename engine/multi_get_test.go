@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Spread versions over L1, L0 and the memtable.
+	for i := 0; i < 20; i++ {
+		db.Put([]byte(fmt.Sprintf("k%02d", i)), []byte("old"))
+	}
+	db.freezeMemtable()
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("k05"), []byte("l0"))
+	db.Delete([]byte("k06"))
+	db.freezeMemtable()
+	db.Put([]byte("k07"), []byte("mem"))
+	db.DeleteRange([]byte("k10"), []byte("k12"))
+
+	snap := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snap)
+	db.Put([]byte("k00"), []byte("after"))
+
+	keys := []string{"k07", "missing", "k05", "k06", "k00", "k11", "k15", "k05"}
+	want := []string{"mem", "", "l0", "", "after", "", "old", "l0"}
+	values, errs := db.MultiGet(byteKeys(keys), nil)
+	for i, k := range keys {
+		checkMultiGet(t, k, values[i], errs[i], want[i])
+	}
+
+	// Under a snapshot every key is read at the snapshot.
+	values, errs = db.MultiGet(byteKeys([]string{"k00", "k07"}), &ReadOptions{Snapshot: snap})
+	checkMultiGet(t, "k00", values[0], errs[0], "old")
+	checkMultiGet(t, "k07", values[1], errs[1], "mem")
+
+	// Agrees with Get key by key.
+	all := make([]string, 20)
+	for i := range all {
+		all[i] = fmt.Sprintf("k%02d", i)
+	}
+	values, errs = db.MultiGet(byteKeys(all), nil)
+	for i, k := range all {
+		v, err := db.Get([]byte(k))
+		if err != errs[i] || string(v) != string(values[i]) {
+			t.Fatalf("%s: MultiGet (%q, %v) disagrees with Get (%q, %v)", k, values[i], errs[i], v, err)
+		}
+	}
+}
+
+func byteKeys(keys []string) [][]byte {
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = []byte(k)
+	}
+	return out
+}
+
+// checkMultiGet expects value want, or ErrNotFound when want is empty.
+func checkMultiGet(t *testing.T, key string, value []byte, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound, got %q, %v", key, value, err)
+		}
+		return
+	}
+	if err != nil || string(value) != want {
+		t.Fatalf("%s: expected %q, got %q, %v", key, want, value, err)
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"sort"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/sstable"
+)
+
+// rangeDelSet answers coverage queries over a fixed set of range tombstones.
+type rangeDelSet struct {
+	cmp        internal.Comparator
+	tombstones []internal.RangeTombstone // Ordered by Start.
+}
+
+// newRangeDelSet keeps the tombstones visible at readSeq.
+func newRangeDelSet(cmp internal.Comparator, all []internal.RangeTombstone, readSeq uint64) *rangeDelSet {
+	s := &rangeDelSet{cmp: cmp}
+	for _, t := range all {
+		if t.Seq <= readSeq {
+			s.tombstones = append(s.tombstones, t)
+		}
+	}
+	sort.Slice(s.tombstones, func(i, j int) bool {
+		return cmp.CompareUser(s.tombstones[i].Start, s.tombstones[j].Start) < 0
+	})
+	return s
+}
+
+// coveringSeq returns the newest tombstone sequence covering userKey, or 0.
+// Versions of userKey older than the result are deleted.
+func (s *rangeDelSet) coveringSeq(userKey []byte) uint64 {
+	if s == nil {
+		return 0
+	}
+	var seq uint64
+	for _, t := range s.tombstones {
+		if s.cmp.CompareUser(t.Start, userKey) > 0 {
+			break
+		}
+		if t.Seq > seq && t.Contains(s.cmp, userKey) {
+			seq = t.Seq
+		}
+	}
+	return seq
+}
+
+// coveringSeq returns the newest tombstone in ts visible at readSeq that covers userKey, or 0.
+func coveringSeq(cmp internal.Comparator, ts []internal.RangeTombstone, userKey []byte, readSeq uint64) uint64 {
+	var seq uint64
+	for _, t := range ts {
+		if t.Seq <= readSeq && t.Seq > seq && t.Contains(cmp, userKey) {
+			seq = t.Seq
+		}
+	}
+	return seq
+}
+
+// addRangeTombstones writes ts to b in key order and widens meta's
+// key and sequence bounds to span them.
+func addRangeTombstones(cmp internal.Comparator, b *sstable.Builder, meta *SSTableMeta, ts []internal.RangeTombstone) error {
+	sorted := append([]internal.RangeTombstone(nil), ts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return cmp.Compare(sorted[i].SmallestKey(), sorted[j].SmallestKey()) < 0
+	})
+
+	for _, t := range sorted {
+		key, value := t.Encode()
+		if err := b.AddRangeTombstone(key, value); err != nil {
+			return err
+		}
+
+		if smallest := t.SmallestKey(); len(meta.SmallestKey) == 0 || cmp.Compare(smallest, meta.SmallestKey) < 0 {
+			meta.SmallestKey = smallest
+		}
+		if largest := t.LargestKey(); len(meta.LargestKey) == 0 || cmp.Compare(largest, meta.LargestKey) > 0 {
+			meta.LargestKey = largest
+		}
+		if t.Seq < meta.SmallestSeq {
+			meta.SmallestSeq = t.Seq
+		}
+		if t.Seq > meta.LargestSeq {
+			meta.LargestSeq = t.Seq
+		}
+	}
+	return nil
+}
+
+// clipRangeTombstone restricts t to [lower, upper); nil bounds are open.
+// It returns false if nothing of t remains.
+func clipRangeTombstone(cmp internal.Comparator, t internal.RangeTombstone, lower, upper []byte) (internal.RangeTombstone, bool) {
+	if lower != nil && cmp.CompareUser(t.Start, lower) < 0 {
+		t.Start = lower
+	}
+	if upper != nil && cmp.CompareUser(t.End, upper) > 0 {
+		t.End = upper
+	}
+	return t, cmp.CompareUser(t.Start, t.End) < 0
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func putKeys(t *testing.T, db *DB, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		if err := db.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func scanKeys(db *DB, opts *ReadOptions) (forward, reverse []string) {
+	it := db.NewIterator(opts)
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		forward = append(forward, string(it.Key()))
+	}
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		reverse = append(reverse, string(it.Key()))
+	}
+	return forward, reverse
+}
+
+func TestDeleteRangeGetAndIterate(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	putKeys(t, db, "a", "b", "c", "d", "e", "f")
+	db.freezeMemtable() // Half the keys in an SSTable.
+	putKeys(t, db, "d")
+
+	snap := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snap)
+
+	if err := db.DeleteRange([]byte("b"), []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	putKeys(t, db, "c") // Written after the tombstone.
+
+	for _, k := range []string{"b", "d"} {
+		if _, err := db.Get([]byte(k)); err != ErrNotFound {
+			t.Fatalf("Get(%s): expected ErrNotFound, got %v", k, err)
+		}
+	}
+	for _, k := range []string{"a", "c", "e", "f"} {
+		if _, err := db.Get([]byte(k)); err != nil {
+			t.Fatalf("Get(%s): %v", k, err)
+		}
+	}
+
+	forward, reverse := scanKeys(db, nil)
+	if fmt.Sprint(forward) != "[a c e f]" || fmt.Sprint(reverse) != "[f e c a]" {
+		t.Fatalf("iterator saw %v / %v", forward, reverse)
+	}
+
+	// The snapshot predates the tombstone.
+	forward, _ = scanKeys(db, &ReadOptions{Snapshot: snap})
+	if fmt.Sprint(forward) != "[a b c d e f]" {
+		t.Fatalf("snapshot iterator saw %v", forward)
+	}
+	if _, err := db.GetWithOptions([]byte("d"), &ReadOptions{Snapshot: snap}); err != nil {
+		t.Fatalf("snapshot Get(d): %v", err)
+	}
+
+	if err := db.DeleteRange([]byte("z"), []byte("a")); err != ErrInvalidRange {
+		t.Fatalf("expected ErrInvalidRange, got %v", err)
+	}
+}
+
+func TestDeleteRangeSurvivesFlushAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putKeys(t, db, "k1", "k2", "k3")
+	db.freezeMemtable()
+	db.DeleteRange([]byte("k1"), []byte("k3"))
+	db.freezeMemtable()                        // Tombstone alone in its own SSTable.
+	db.DeleteRange([]byte("k3"), []byte("k4")) // Left in the WAL.
+	db.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	forward, _ := scanKeys(db, nil)
+	if len(forward) != 0 {
+		t.Fatalf("expected no keys after reopen, got %v", forward)
+	}
+}
+
+func TestCompactionDropsRangeTombstones(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		putKeys(t, db, fmt.Sprintf("key%03d", i))
+	}
+	db.freezeMemtable()
+	db.DeleteRange([]byte("key010"), []byte("key090"))
+	db.freezeMemtable()
+
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bottom-most with no snapshots: covered keys and the tombstone are gone.
+	entries := 0
+	for _, meta := range db.version.GetAllTables() {
+		sst, release, err := db.tableCache.NewIterator(meta.FileNum, db.cmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for sst.SeekToFirst(); sst.Valid(); sst.Next() {
+			entries++
+		}
+		if n := len(sst.RangeTombstones()); n != 0 {
+			t.Fatalf("expected tombstone dropped, table %d has %d", meta.FileNum, n)
+		}
+		release()
+	}
+	if entries != 20 {
+		t.Fatalf("expected 20 surviving entries, got %d", entries)
+	}
+
+	if _, err := db.Get([]byte("key050")); err != ErrNotFound {
+		t.Fatalf("expected key050 deleted, got %v", err)
+	}
+}
+
+func TestCompactionKeepsRangeTombstoneForSnapshot(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	putKeys(t, db, "a", "b", "c")
+	db.freezeMemtable()
+	snap := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snap)
+	db.DeleteRange([]byte("a"), []byte("c"))
+	db.freezeMemtable()
+
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+
+	forward, _ := scanKeys(db, &ReadOptions{Snapshot: snap})
+	if fmt.Sprint(forward) != "[a b c]" {
+		t.Fatalf("snapshot iterator saw %v", forward)
+	}
+	forward, _ = scanKeys(db, nil)
+	if fmt.Sprint(forward) != "[c]" {
+		t.Fatalf("iterator saw %v", forward)
+	}
+}
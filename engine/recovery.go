@@ -15,39 +15,90 @@ import (
 )
 
 type RecoveredState struct {
-	VersionSet  *VersionSet
-	Memtable    *memtable.Memtable
+	// Default column family.
+	VersionSet *VersionSet
+	Memtable   *memtable.Memtable
+
+	// Every other column family, in creation order.
+	ColumnFamilies     []*RecoveredColumnFamily
+	NextColumnFamilyID uint32
+
 	NextSeq     uint64
 	NextFileNum uint64
 }
 
-// Recover restores DB state.
-func Recover(dbDir, walDir string, memtableLimit int) (*RecoveredState, error) {
+// RecoveredColumnFamily is a non-default column family restored by Recover.
+// Dropped families are returned so their obsolete tables can be deleted.
+type RecoveredColumnFamily struct {
+	ID         uint32
+	Name       string
+	VersionSet *VersionSet
+	Memtable   *memtable.Memtable
+	Dropped    bool
+}
+
+// Recover restores DB state. user must match the comparator the DB was
+// created with; nil is bytewise. families holds the comparators of column
+// families created with a Config of their own, by name; the others use user.
+// Memtables paged out while replaying the WAL are written with tables, or the
+// sstable defaults when it is nil.
+func Recover(dbDir, walDir string, memtableLimit int, user UserComparator, families map[string]UserComparator, tables *sstable.BuilderOptions) (*RecoveredState, error) {
 	manifestPath := filepath.Join(dbDir, "MANIFEST")
+	cmp := newComparator(user)
 
 	// Replay manifest.
-	vs, err := ReplayManifest(manifestPath)
+	replayed, nextFamilyID, err := replayManifest(manifestPath, cmp, newFamilyComparators(families))
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize memtable.
-	mt := memtable.New()
+	// One memtable per family.
+	byID := make(map[uint32]*RecoveredColumnFamily, len(replayed))
+	recovered := make([]*RecoveredColumnFamily, 0, len(replayed))
+	for _, f := range replayed {
+		rcf := &RecoveredColumnFamily{
+			ID:         f.id,
+			Name:       f.name,
+			VersionSet: f.version,
+			Memtable:   memtable.NewWithComparator(f.cmp),
+			Dropped:    f.dropped,
+		}
+		byID[f.id] = rcf
+		recovered = append(recovered, rcf)
+	}
 
 	// Find max sequence and file number.
 	var maxSeq uint64
 	var maxFileNum uint64
 
-	for _, meta := range vs.GetAllTables() {
-		if meta.LargestSeq > maxSeq {
-			maxSeq = meta.LargestSeq
+	for _, rcf := range recovered {
+		vs := rcf.VersionSet
+		for _, meta := range vs.GetAllTables() {
+			if meta.LargestSeq > maxSeq {
+				maxSeq = meta.LargestSeq
+			}
+			if meta.FileNum > maxFileNum {
+				maxFileNum = meta.FileNum
+			}
 		}
-		if meta.FileNum > maxFileNum {
-			maxFileNum = meta.FileNum
+		for fileNum := range vs.Obsolete {
+			if fileNum > maxFileNum {
+				maxFileNum = fileNum
+			}
+		}
+		for _, meta := range vs.BlobFiles() {
+			if meta.FileNum > maxFileNum {
+				maxFileNum = meta.FileNum
+			}
+		}
+		for fileNum := range vs.ObsoleteBlobs {
+			if fileNum > maxFileNum {
+				maxFileNum = fileNum
+			}
+		}
+		if vs.WALCutoffSeq > maxSeq {
+			maxSeq = vs.WALCutoffSeq
 		}
-	}
-	if vs.WALCutoffSeq > maxSeq {
-		maxSeq = vs.WALCutoffSeq
 	}
 
 	// Find WAL files.
@@ -82,44 +133,45 @@ func Recover(dbDir, walDir string, memtableLimit int) (*RecoveredState, error) {
 				// Stop on corruption.
 				break
 			}
-
-			batchMaxSeq := batch.SeqStart + uint64(len(batch.Records)) - 1
-			if batchMaxSeq <= vs.WALCutoffSeq {
-				offset += n
-				continue
-			}
+			offset += n
 
 			// Apply batch.
+			// Each family skips what it has already flushed.
 			seq := batch.SeqStart
 			for _, r := range batch.Records {
-				ikey := internal.EncodeInternalKey(
-					r.Key,
-					seq,
-					convertLogicalType(r.Type),
-				)
-				mt.Insert(ikey, r.Value)
-
 				if seq > maxSeq {
 					maxSeq = seq
 				}
+				rcf, ok := byID[r.ColumnFamily]
+				if ok && !rcf.Dropped && seq > rcf.VersionSet.WALCutoffSeq {
+					ikey := internal.EncodeInternalKey(
+						r.Key,
+						seq,
+						convertLogicalType(r.Type),
+					)
+					rcf.Memtable.Insert(ikey, r.Value)
+				}
 				seq++
 			}
 
-			offset += n
-
-			// Paging: Flush if memtable grows too large.
-			if mt.ApproximateSize() > memtableLimit {
+			// Paging: Flush if a memtable grows too large.
+			for _, rcf := range recovered {
+				if rcf.Memtable.ApproximateSize() <= memtableLimit {
+					continue
+				}
 				fileNum := maxFileNum + 1
 				maxFileNum++
 
 				sstPath := filepath.Join(dbDir, fmt.Sprintf("%06d.sst", fileNum))
-				meta, err := writeMemtableToSSTable(mt, sstPath, fileNum)
+				// The family's VersionSet is ordered by its comparator.
+				cmp := rcf.VersionSet.cmp
+				meta, err := writeMemtableToSSTable(cmp, tables, rcf.Memtable, sstPath, fileNum)
 				if err != nil {
 					return nil, err
 				}
 
 				// Update VersionSet.
-				if err := vs.AddTable(meta); err != nil {
+				if err := rcf.VersionSet.AddTable(meta); err != nil {
 					return nil, err
 				}
 
@@ -132,13 +184,14 @@ func Recover(dbDir, walDir string, memtableLimit int) (*RecoveredState, error) {
 				edit := manifest.Record{
 					Type: manifest.RecordTypeAddSSTable,
 					Data: manifest.AddSSTable{
-						FileNum:     meta.FileNum,
-						Level:       meta.Level,
-						SmallestKey: meta.SmallestKey,
-						LargestKey:  meta.LargestKey,
-						SmallestSeq: meta.SmallestSeq,
-						LargestSeq:  meta.LargestSeq,
-						FileSize:    meta.FileSize,
+						FileNum:      meta.FileNum,
+						Level:        meta.Level,
+						SmallestKey:  meta.SmallestKey,
+						LargestKey:   meta.LargestKey,
+						SmallestSeq:  meta.SmallestSeq,
+						LargestSeq:   meta.LargestSeq,
+						FileSize:     meta.FileSize,
+						ColumnFamily: rcf.ID,
 					},
 				}
 				if err := m.Append(edit); err != nil {
@@ -148,27 +201,30 @@ func Recover(dbDir, walDir string, memtableLimit int) (*RecoveredState, error) {
 				m.Close()
 
 				// Reset Memtable.
-				mt = memtable.New()
+				rcf.Memtable = memtable.NewWithComparator(cmp)
 			}
 		}
 	}
 
 	return &RecoveredState{
-		VersionSet:  vs,
-		Memtable:    mt,
-		NextSeq:     maxSeq + 1,
-		NextFileNum: maxFileNum,
+		VersionSet:         recovered[0].VersionSet,
+		Memtable:           recovered[0].Memtable,
+		ColumnFamilies:     recovered[1:],
+		NextColumnFamilyID: nextFamilyID,
+		NextSeq:            maxSeq + 1,
+		NextFileNum:        maxFileNum,
 	}, nil
 }
 
-func writeMemtableToSSTable(mt *memtable.Memtable, filename string, fileNum uint64) (SSTableMeta, error) {
+func writeMemtableToSSTable(cmp internal.Comparator, opts *sstable.BuilderOptions, mt *memtable.Memtable, filename string, fileNum uint64) (SSTableMeta, error) {
 	iter := mt.Iterator()
 	iter.SeekToFirst()
 
-	builder, err := sstable.NewBuilder(filename)
+	builder, err := sstable.NewBuilder(filename, opts)
 	if err != nil {
 		return SSTableMeta{}, err
 	}
+	builder.SetComparator(cmp)
 
 	var meta SSTableMeta
 	meta.FileNum = fileNum
@@ -203,6 +259,10 @@ func writeMemtableToSSTable(mt *memtable.Memtable, filename string, fileNum uint
 		iter.Next()
 	}
 
+	if err := addRangeTombstones(cmp, builder, &meta, mt.RangeTombstones()); err != nil {
+		return SSTableMeta{}, err
+	}
+
 	if err := builder.Close(); err != nil {
 		return SSTableMeta{}, err
 	}
@@ -218,6 +278,12 @@ func convertLogicalType(t uint8) internal.RecordType {
 		return internal.RecordTypeValue
 	case wal.LogicalTypeDelete:
 		return internal.RecordTypeTombstone
+	case wal.LogicalTypeMerge:
+		return internal.RecordTypeMerge
+	case wal.LogicalTypeDeleteRange:
+		return internal.RecordTypeRangeDelete
+	case wal.LogicalTypePutTTL:
+		return internal.RecordTypeExpiringValue
 	default:
 		panic("unknown WAL logical record type")
 	}
@@ -41,7 +41,7 @@ func TestRecoveryPaging(t *testing.T) {
 	w.Close()
 
 	// Recover with 200 byte limit
-	state, err := Recover(dir, walDir, 200)
+	state, err := Recover(dir, walDir, 200, nil, nil, nil)
 	if err != nil {
 		t.Fatalf("Recover: %v", err)
 	}
@@ -1,10 +1,15 @@
 package engine
 
-import "bytes"
+import (
+	"bytes"
+
+	"vern_kv0.8/internal"
+)
 
 // scanIterator filters keys by range.
 type scanIterator struct {
 	inner  Iterator
+	cmp    internal.Comparator
 	start  []byte
 	end    []byte
 	prefix []byte
@@ -13,16 +18,53 @@ type scanIterator struct {
 
 func (it *scanIterator) SeekToFirst() {
 	it.stop = false
-	it.inner.SeekToFirst()
+	if it.start != nil {
+		it.inner.Seek(it.start)
+	} else {
+		it.inner.SeekToFirst()
+	}
 	it.advance()
 }
 
+func (it *scanIterator) SeekToLast() {
+	it.stop = false
+	if it.end != nil {
+		it.inner.SeekForPrev(it.end)
+	} else {
+		it.inner.SeekToLast()
+	}
+	it.retreat()
+}
+
+// Seek positions at the first key in range >= key.
+func (it *scanIterator) Seek(key []byte) {
+	it.stop = false
+	if it.start != nil && it.cmp.CompareUser(key, it.start) < 0 {
+		key = it.start
+	}
+	it.inner.Seek(key)
+	it.advance()
+}
+
+// SeekForPrev positions at the last key in range <= key.
+func (it *scanIterator) SeekForPrev(key []byte) {
+	it.stop = false
+	it.inner.SeekForPrev(key)
+	it.retreat()
+}
+
 // Next advances to the next key in range.
 func (it *scanIterator) Next() {
 	it.inner.Next()
 	it.advance()
 }
 
+// Prev moves to the previous key in range.
+func (it *scanIterator) Prev() {
+	it.inner.Prev()
+	it.retreat()
+}
+
 func (it *scanIterator) Valid() bool {
 	return !it.stop && it.inner.Valid()
 }
@@ -35,6 +77,10 @@ func (it *scanIterator) Value() []byte {
 	return it.inner.Value()
 }
 
+func (it *scanIterator) Close() error {
+	return it.inner.Close()
+}
+
 func (it *scanIterator) advance() {
 	for it.inner.Valid() {
 		k := it.inner.Key()
@@ -46,12 +92,12 @@ func (it *scanIterator) advance() {
 			}
 		}
 
-		if it.start != nil && bytes.Compare(k, it.start) < 0 {
+		if it.start != nil && it.cmp.CompareUser(k, it.start) < 0 {
 			it.inner.Next()
 			continue
 		}
 
-		if it.end != nil && bytes.Compare(k, it.end) >= 0 {
+		if it.end != nil && it.cmp.CompareUser(k, it.end) >= 0 {
 			// end bound reached — stop iteration
 			it.stop = true
 			return
@@ -60,3 +106,30 @@ func (it *scanIterator) advance() {
 		return
 	}
 }
+
+// retreat is advance in reverse.
+func (it *scanIterator) retreat() {
+	for it.inner.Valid() {
+		k := it.inner.Key()
+
+		if it.prefix != nil {
+			if !bytes.HasPrefix(k, it.prefix) {
+				it.inner.Prev()
+				continue
+			}
+		}
+
+		if it.end != nil && it.cmp.CompareUser(k, it.end) >= 0 {
+			it.inner.Prev()
+			continue
+		}
+
+		if it.start != nil && it.cmp.CompareUser(k, it.start) < 0 {
+			// start bound reached — stop iteration
+			it.stop = true
+			return
+		}
+
+		return
+	}
+}
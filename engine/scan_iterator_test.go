@@ -1,6 +1,9 @@
 package engine
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestRangeScanSnapshot(t *testing.T) {
 	dir := t.TempDir()
@@ -58,3 +61,112 @@ func TestPrefixScan(t *testing.T) {
 		t.Fatalf("unexpected keys: %v", keys)
 	}
 }
+
+func TestRangeScanReverse(t *testing.T) {
+	dir := t.TempDir()
+	db, _ := Open(dir)
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	it := db.NewRangeIterator([]byte("b"), []byte("e"), nil)
+
+	var keys []string
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		keys = append(keys, string(it.Key()))
+	}
+
+	if len(keys) != 3 || keys[0] != "d" || keys[2] != "b" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	it.Seek([]byte("a"))
+	if !it.Valid() || string(it.Key()) != "b" {
+		t.Fatalf("Seek below start should clamp to b")
+	}
+}
+
+func TestPrefixExtractorSkipsTables(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.PrefixExtractor = NewFixedPrefixExtractor(2)
+	cfg.L0CompactionTrigger = 100 // Keep one table per prefix.
+	db, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// One L0 table per prefix.
+	for _, p := range []string{"aa", "bb", "cc"} {
+		for i := 0; i < 5; i++ {
+			db.Put([]byte(fmt.Sprintf("%s%d", p, i)), []byte(p))
+		}
+		db.freezeMemtable()
+	}
+	// Deleted in a later table that holds no "bb" keys itself.
+	if err := db.DeleteRange([]byte("bb1"), []byte("bb3")); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("dd0"), []byte("dd"))
+	db.freezeMemtable()
+
+	tables := db.version.LevelFiles(0)
+	if len(tables) != 4 {
+		t.Fatalf("expected 4 L0 tables, got %d", len(tables))
+	}
+	name := cfg.PrefixExtractor.Name()
+	skipped := 0
+	for _, meta := range tables {
+		ok, _, err := db.tableCache.PrefixMayMatch(meta.FileNum, db.cmp, name, []byte("bb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			skipped++
+		}
+	}
+	if skipped == 0 {
+		t.Fatal("expected some tables ruled out for prefix bb")
+	}
+
+	it := db.NewPrefixIterator([]byte("bb"), nil)
+	var keys []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[bb0 bb3 bb4]" {
+		t.Fatalf("unexpected forward keys: %v", keys)
+	}
+
+	keys = nil
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[bb4 bb3 bb0]" {
+		t.Fatalf("unexpected reverse keys: %v", keys)
+	}
+
+	// Seeks outside the prefix are clamped to it.
+	it.Seek([]byte("a"))
+	if !it.Valid() || string(it.Key()) != "bb0" {
+		t.Fatalf("expected bb0 after seeking below the prefix")
+	}
+	it.Seek([]byte("bb2"))
+	if !it.Valid() || string(it.Key()) != "bb3" {
+		t.Fatalf("expected bb3 after seeking into a deleted range")
+	}
+	it.Close()
+
+	// Scan prefixes shorter than the extractor's domain read every table.
+	keys = nil
+	it = db.NewPrefixIterator([]byte("c"), nil)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	if len(keys) != 5 {
+		t.Fatalf("unexpected keys for prefix c: %v", keys)
+	}
+}
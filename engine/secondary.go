@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/internal/cache"
+	"vern_kv0.8/memtable"
+	"vern_kv0.8/wal"
+)
+
+var (
+	ErrReadOnly     = errors.New("secondary instance is read-only")
+	ErrNotSecondary = errors.New("not a secondary instance")
+	ErrSecondaryDir = errors.New("secondary directory holds a database")
+)
+
+// maxCatchUpAttempts bounds the retries of a catch-up that lost a race
+// with the primary deleting a file.
+const maxCatchUpAttempts = 8
+
+// OpenSecondary opens a read-only follower of the DB in primaryDir, which may
+// be open in another process. It reads the primary's MANIFEST and WAL and
+// never writes to primaryDir or takes any lock there.
+//
+// The tables and blob files it reads are hard-linked (or copied, across
+// filesystems) into secondaryDir, so the primary deleting them after a
+// compaction does not disturb readers. opts must match the primary's
+// comparator and merge operator.
+//
+// The instance shows the primary as of OpenSecondary; call
+// TryCatchUpWithPrimary to move it forward. Writes, compactions, column
+// family changes and checkpoints return ErrReadOnly.
+func OpenSecondary(primaryDir, secondaryDir string, options ...*Config) (*DB, error) {
+	opts := DefaultConfig()
+	if len(options) > 0 && options[0] != nil {
+		opts = options[0]
+	}
+	if _, err := os.Stat(filepath.Join(primaryDir, "MANIFEST")); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(secondaryDir, "MANIFEST")); err == nil {
+		return nil, ErrSecondaryDir
+	}
+	if err := os.MkdirAll(secondaryDir, 0755); err != nil {
+		return nil, err
+	}
+	cmp := newComparator(opts.Comparator)
+
+	db := &DB{
+		columnFamily: newColumnFamily(defaultColumnFamilyID, DefaultColumnFamilyName, opts, cmp, newVersionSet(cmp), memtable.NewWithComparator(cmp)),
+		families:     make(map[uint32]*columnFamily),
+		nextFamilyID: defaultColumnFamilyID + 1,
+
+		nextSeq:    1,
+		dir:        secondaryDir,
+		primaryDir: primaryDir,
+		opts:       opts,
+		cmp:        cmp,
+	}
+	db.roomCond = sync.NewCond(&db.mu)
+	db.families[defaultColumnFamilyID] = db.columnFamily
+
+	db.cache = cache.NewLRUCache(8 * 1024 * 1024)
+	db.tableCache = NewTableCache(secondaryDir, opts.MaxOpenFiles, db.cache)
+	db.tableCache.filter = opts.FilterPolicy
+	db.blobs = newBlobCache(secondaryDir)
+
+	// Links left by an earlier instance may name files the primary has
+	// since deleted; start from nothing.
+	if err := removeLinkedFiles(secondaryDir); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.TryCatchUpWithPrimary(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// TryCatchUpWithPrimary moves a secondary to the primary's current state:
+// tables flushed or compacted since the last call, and writes still only in
+// the WAL. Iterators keep the tables they were created with; as on a
+// primary, one without a snapshot may also see writes added to its memtable.
+//
+// Snapshots taken on a secondary do not hold back the primary's compactions,
+// so after a catch-up they may see newer values than when they were taken.
+func (db *DB) TryCatchUpWithPrimary() error {
+	if db.primaryDir == "" {
+		return ErrNotSecondary
+	}
+
+	// Catch-ups stand in for flushes on a secondary, which has none.
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < maxCatchUpAttempts; attempt++ {
+		// A file listed in the MANIFEST was deleted before it was linked;
+		// a newer MANIFEST no longer lists it.
+		if err = db.catchUp(); !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	db.cleanupObsoleteFiles()
+	return nil
+}
+
+// catchUp replays what the primary wrote since the last catch-up and
+// installs the result.
+//
+// WAL records after db.walTail are appended to the family memtables. A family
+// whose WAL cutoff advanced has flushed some of them; its memtable is rebuilt
+// from the entries newer than the cutoff. Only tables and blob files new to
+// the MANIFEST are linked.
+func (db *DB) catchUp() error {
+	// The WAL is read first. Segments are only truncated once the MANIFEST
+	// records their writes as flushed, so a MANIFEST read afterwards covers
+	// any segment missing here.
+	batches, tail, err := readWALTail(db.logDir(), db.walTail)
+	if err != nil {
+		return err
+	}
+	familyCmps := newFamilyComparators(db.opts.familyComparators())
+	families, nextFamilyID, err := replayManifest(filepath.Join(db.primaryDir, "MANIFEST"), db.cmp, familyCmps)
+	if err != nil {
+		return err
+	}
+
+	// Only catch-ups change a secondary's families and versions, and they
+	// hold flushMu, so these reads need no db.mu.
+	var maxSeq uint64
+	byID := make(map[uint32]*replayedFamily, len(families))
+	for _, f := range families {
+		byID[f.id] = f
+
+		linked := make(map[uint64]bool)
+		var linkedBlobs map[uint64]BlobFileMeta
+		if cf, ok := db.families[f.id]; ok {
+			for _, meta := range cf.version.AllTables() {
+				linked[meta.FileNum] = true
+			}
+			linkedBlobs = cf.version.Blobs
+		}
+		for _, meta := range f.version.AllTables() {
+			if !linked[meta.FileNum] {
+				name := fmt.Sprintf("%06d.sst", meta.FileNum)
+				if err := linkNew(filepath.Join(db.primaryDir, name), filepath.Join(db.dir, name)); err != nil {
+					return err
+				}
+			}
+			if meta.LargestSeq > maxSeq {
+				maxSeq = meta.LargestSeq
+			}
+		}
+		for fileNum := range f.version.Blobs {
+			if _, ok := linkedBlobs[fileNum]; !ok {
+				if err := linkNew(blobFileName(db.primaryDir, fileNum), blobFileName(db.dir, fileNum)); err != nil {
+					return err
+				}
+			}
+		}
+		if f.version.WALCutoffSeq > maxSeq {
+			maxSeq = f.version.WALCutoffSeq
+		}
+	}
+
+	// Nothing below can fail, so a retried catch-up never appends twice.
+	mems := make(map[uint32]*memtable.Memtable, len(families))
+	for _, f := range families {
+		if f.dropped {
+			continue
+		}
+		cf, ok := db.families[f.id]
+		switch {
+		case !ok:
+			mems[f.id] = memtable.NewWithComparator(f.cmp)
+		case f.version.WALCutoffSeq > cf.version.WALCutoffSeq:
+			mems[f.id] = trimMemtable(f.cmp, cf.memtable, f.version.WALCutoffSeq)
+		default:
+			mems[f.id] = cf.memtable
+		}
+	}
+	// Each family takes the writes it has not flushed, as in recovery.
+	for _, batch := range batches {
+		seq := batch.SeqStart
+		for _, r := range batch.Records {
+			if seq > maxSeq {
+				maxSeq = seq
+			}
+			if f, ok := byID[r.ColumnFamily]; ok && !f.dropped && seq > f.version.WALCutoffSeq {
+				ikey := internal.EncodeInternalKey(r.Key, seq, convertLogicalType(r.Type))
+				mems[f.id].Insert(ikey, r.Value)
+			}
+			seq++
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, f := range families {
+		cf, ok := db.families[f.id]
+		if !ok {
+			cfOpts := db.opts
+			if o, ok := db.opts.ColumnFamilyConfigs[f.name]; ok && o != nil {
+				cfOpts = o
+			}
+			cf = newColumnFamily(f.id, f.name, cfOpts, f.cmp, newVersionSet(f.cmp), nil)
+			db.families[f.id] = cf
+		}
+		if err := cf.version.Apply(catchUpEdit(cf.version.Version, f.version.Version)); err != nil {
+			return err
+		}
+		cf.version.SetWALCutoff(f.version.WALCutoffSeq)
+		if mt, ok := mems[f.id]; ok {
+			cf.memtable = mt
+		} else {
+			cf.memtable = memtable.NewWithComparator(f.cmp)
+		}
+		cf.immutables = nil
+		cf.dropped = f.dropped
+	}
+	if nextFamilyID > db.nextFamilyID {
+		db.nextFamilyID = nextFamilyID
+	}
+	if maxSeq+1 > db.nextSeq {
+		db.nextSeq = maxSeq + 1
+	}
+	db.walTail = tail
+	return nil
+}
+
+// trimMemtable returns a copy of mt holding only the entries newer than
+// cutoff; the older ones have been flushed to tables.
+func trimMemtable(cmp internal.Comparator, mt *memtable.Memtable, cutoff uint64) *memtable.Memtable {
+	out := memtable.NewWithComparator(cmp)
+	it := mt.Iterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if seq, _, _ := internal.ExtractTrailer(it.Key()); seq > cutoff {
+			out.Insert(it.Key(), it.Value())
+		}
+	}
+	for _, t := range mt.RangeTombstones() {
+		if t.Seq > cutoff {
+			out.Insert(t.Encode())
+		}
+	}
+	return out
+}
+
+// catchUpEdit turns cur into next. Tables and blob files cur has and next
+// lacks become obsolete, so their links are removed once unpinned.
+func catchUpEdit(cur, next *Version) VersionEdit {
+	have := make(map[uint64]uint32)
+	for _, meta := range cur.AllTables() {
+		have[meta.FileNum] = meta.Level
+	}
+	want := make(map[uint64]uint32)
+	for _, meta := range next.AllTables() {
+		want[meta.FileNum] = meta.Level
+	}
+
+	var edit VersionEdit
+	for fileNum, level := range have {
+		if l, ok := want[fileNum]; !ok || l != level {
+			edit.Removed = append(edit.Removed, fileNum)
+		}
+	}
+	for _, meta := range next.AllTables() {
+		if l, ok := have[meta.FileNum]; !ok || l != meta.Level {
+			edit.Added = append(edit.Added, meta)
+		}
+	}
+
+	for fileNum, meta := range next.Blobs {
+		if _, ok := cur.Blobs[fileNum]; !ok {
+			edit.AddedBlobs = append(edit.AddedBlobs, meta)
+		}
+	}
+	for fileNum, meta := range cur.Blobs {
+		if _, ok := next.Blobs[fileNum]; !ok {
+			edit.BlobGarbage = append(edit.BlobGarbage, BlobGarbage{
+				FileNum: fileNum,
+				Count:   meta.Count - meta.GarbageCount,
+				Size:    meta.Size - meta.GarbageSize,
+			})
+		}
+	}
+	return edit
+}
+
+// linkNew links src to dst unless dst already exists. An existing dst is a
+// link to src: copying over it would truncate the primary's file.
+func linkNew(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return linkOrCopy(src, dst)
+}
+
+// walPosition is how far a secondary has replayed its primary's WAL:
+// offset bytes into the segment numbered segment.
+type walPosition struct {
+	segment uint64
+	offset  int
+}
+
+// readWALTail returns the whole records of walDir's segments after pos,
+// oldest first, and the position after the last of them. Segments truncated
+// while it runs are skipped.
+func readWALTail(walDir string, pos walPosition) ([]wal.Batch, walPosition, error) {
+	segments, err := wal.ListSegments(walDir)
+	if err != nil {
+		return nil, pos, err
+	}
+
+	var batches []wal.Batch
+	for _, path := range segments {
+		num, ok := wal.SegmentNumber(path)
+		if !ok || num < pos.segment {
+			continue
+		}
+		offset := 0
+		if num == pos.segment {
+			offset = pos.offset
+		}
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, pos, err
+		}
+
+		for offset < len(data) {
+			batch, n, err := wal.DecodeRecord(data[offset:])
+			if err != nil {
+				// Corrupt, or still being written.
+				break
+			}
+			batches = append(batches, batch)
+			offset += n
+		}
+		pos = walPosition{segment: num, offset: offset}
+	}
+	return batches, pos, nil
+}
+
+// removeLinkedFiles deletes the tables and blob files in a secondary's directory.
+func removeLinkedFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var fileNum uint64
+		name := e.Name()
+		if _, err := fmt.Sscanf(name, "%06d.sst", &fileNum); err != nil {
+			if _, err := fmt.Sscanf(name, "%06d.blob", &fileNum); err != nil {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// logDir returns the directory holding the WAL the DB reads; a secondary
+// reads its primary's.
+func (db *DB) logDir() string {
+	if db.primaryDir != "" {
+		return filepath.Join(db.primaryDir, db.opts.WalDir)
+	}
+	return filepath.Join(db.dir, db.opts.WalDir)
+}
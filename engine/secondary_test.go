@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecondaryCatchUp(t *testing.T) {
+	primaryDir := t.TempDir()
+	secondaryDir := filepath.Join(t.TempDir(), "secondary")
+	cfg := DefaultConfig()
+	cfg.MinBlobSize = 1024
+	cfg.L0CompactionTrigger = 100
+
+	primary, err := Open(primaryDir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	users, err := primary.CreateColumnFamily("users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		primary.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v1"))
+	}
+	big := bytes.Repeat([]byte("b"), 2048)
+	primary.Put([]byte("big"), big)
+	flushFamily(primary, primary.DefaultColumnFamily())
+	// Still only in the WAL.
+	primary.Put([]byte("k000"), []byte("v2"))
+	primary.PutCF(users, []byte("alice"), []byte("1"))
+
+	secondary, err := OpenSecondary(primaryDir, secondaryDir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	expect := func(key, want string) {
+		t.Helper()
+		got, err := secondary.Get([]byte(key))
+		if want == "" {
+			if err != ErrNotFound {
+				t.Fatalf("get %q: expected ErrNotFound, got %q, %v", key, got, err)
+			}
+			return
+		}
+		if err != nil || string(got) != want {
+			t.Fatalf("get %q = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	expect("k000", "v2")
+	expect("k001", "v1")
+	if got, err := secondary.Get([]byte("big")); err != nil || !bytes.Equal(got, big) {
+		t.Fatalf("blob value not read: %v", err)
+	}
+	h, err := secondary.ColumnFamily("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := secondary.GetCF(h, []byte("alice"), nil); err != nil || string(got) != "1" {
+		t.Fatalf("get alice = %q, %v", got, err)
+	}
+
+	if err := secondary.Put([]byte("x"), []byte("y")); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from Put, got %v", err)
+	}
+	if err := secondary.CompactLevel(0); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly from CompactLevel, got %v", err)
+	}
+
+	// The primary moves on: the first table is compacted away and deleted.
+	for i := 0; i < 50; i += 2 {
+		primary.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v3"))
+	}
+	primary.Delete([]byte("k001"))
+	flushFamily(primary, primary.DefaultColumnFamily())
+	if err := primary.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	primary.Put([]byte("k049"), []byte("v4"))
+
+	// The secondary still reads its old view from its own links.
+	it := secondary.NewIterator(nil)
+	expect("k001", "v1")
+	expect("k002", "v1")
+
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	expect("k000", "v3")
+	expect("k001", "")
+	expect("k003", "v1")
+	expect("k049", "v4")
+
+	// The open iterator keeps the tables it was created with.
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 51 {
+		t.Fatalf("old iterator saw %d keys, want 51", n)
+	}
+	it.Close()
+
+	// Links to tables the primary compacted away are gone once unpinned.
+	for _, meta := range secondary.version.GetAllTables() {
+		if _, err := os.Stat(filepath.Join(secondaryDir, fmt.Sprintf("%06d.sst", meta.FileNum))); err != nil {
+			t.Fatalf("live table not linked: %v", err)
+		}
+	}
+	entries, _ := os.ReadDir(secondaryDir)
+	var tables int
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".sst" {
+			tables++
+		}
+	}
+	if want := len(secondary.version.GetAllTables()) + len(secondary.families[users.ID()].version.GetAllTables()); tables != want {
+		t.Fatalf("secondary dir holds %d tables, want %d", tables, want)
+	}
+}
+
+func TestOpenSecondaryRejects(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := OpenSecondary(dir, dir); err != ErrSecondaryDir {
+		t.Fatalf("expected ErrSecondaryDir, got %v", err)
+	}
+	if _, err := OpenSecondary(t.TempDir(), t.TempDir()); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing MANIFEST error, got %v", err)
+	}
+	if err := db.TryCatchUpWithPrimary(); err != ErrNotSecondary {
+		t.Fatalf("expected ErrNotSecondary, got %v", err)
+	}
+}
+
+func TestSecondaryCatchUpIncremental(t *testing.T) {
+	primaryDir := t.TempDir()
+	primary, err := Open(primaryDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	for i := 0; i < 10; i++ {
+		primary.Put([]byte(fmt.Sprintf("k%02d", i)), []byte("v1"))
+	}
+
+	secondary, err := OpenSecondary(primaryDir, filepath.Join(t.TempDir(), "secondary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	// Without a flush, new writes are appended to the same memtable.
+	mt := secondary.memtable
+	tail := secondary.walTail
+	primary.Put([]byte("k10"), []byte("v1"))
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.memtable != mt || secondary.memtable.Size() != 11 {
+		t.Fatalf("expected 11 entries in the same memtable, got %d", secondary.memtable.Size())
+	}
+	if secondary.walTail.offset <= tail.offset {
+		t.Fatalf("WAL tail did not advance: %+v -> %+v", tail, secondary.walTail)
+	}
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.memtable.Size() != 11 {
+		t.Fatalf("an idle catch-up replayed records again: %d entries", secondary.memtable.Size())
+	}
+
+	// A flush advances the cutoff: only the newer writes stay in memory.
+	flushFamily(primary, primary.DefaultColumnFamily())
+	primary.Put([]byte("k00"), []byte("v2"))
+	primary.DeleteRange([]byte("k05"), []byte("k08"))
+	if err := secondary.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.memtable == mt || secondary.memtable.Size() != 1 || len(secondary.memtable.RangeTombstones()) != 1 {
+		t.Fatalf("expected a rebuilt memtable with the two newest writes")
+	}
+	for key, want := range map[string]string{"k00": "v2", "k04": "v1", "k10": "v1"} {
+		if got, err := secondary.Get([]byte(key)); err != nil || string(got) != want {
+			t.Fatalf("get %q = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := secondary.Get([]byte("k06")); err != ErrNotFound {
+		t.Fatalf("expected k06 deleted, got %v", err)
+	}
+}
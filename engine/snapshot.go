@@ -2,16 +2,22 @@ package engine
 
 // Snapshot represents a stable read view.
 // A snapshot guarantees that reads will only observe versions with sequence numbers <= ReadSeq.
+// It also pins the table versions of every column family current at creation until released.
 type Snapshot struct {
 	ReadSeq uint64
 
-	db   *DB
-	prev *Snapshot
-	next *Snapshot
+	db       *DB
+	versions []*Version
+	prev     *Snapshot
+	next     *Snapshot
 }
 
 // ReadOptions controls read behavior.
 // If Snapshot is nil, reads observe the latest state.
 type ReadOptions struct {
 	Snapshot *Snapshot
+
+	// IncludeExpired returns TTL entries past their expiry that flush and
+	// compaction have not dropped yet. Meant for debugging.
+	IncludeExpired bool
 }
@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"container/list"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/internal/cache"
+	"vern_kv0.8/sstable"
+)
+
+// DefaultMaxOpenFiles bounds the table cache when Config.MaxOpenFiles is unset.
+const DefaultMaxOpenFiles = 500
+
+// TableCache keeps a bounded LRU of open SSTable readers keyed by file number.
+//
+// Readers are reference counted. Eviction drops a reader from the cache,
+// but its file is only closed once the last user releases it.
+//
+// Each method takes cmp, the key ordering of the table's column family; it is
+// set on the reader when the table is opened.
+type TableCache struct {
+	mu       sync.Mutex
+	dir      string
+	capacity int
+	blocks   cache.Cache
+	filter   sstable.FilterPolicy // Policy the table filters were built with; nil is the default bloom filter.
+
+	lru     *list.List // Front is most recently used.
+	entries map[uint64]*list.Element
+
+	// loading holds the tables being opened. The open runs without mu, so
+	// hits on other tables do not wait on its disk reads.
+	loading map[uint64]*tableLoad
+}
+
+// tableLoad is a table being opened. err is set before done is closed.
+type tableLoad struct {
+	done    chan struct{}
+	err     error
+	evicted bool // Evict was called during the open; guarded by TableCache.mu.
+}
+
+// tableHandle is a pinned reader.
+type tableHandle struct {
+	fileNum uint64
+	reader  *sstable.Reader
+	refs    int
+	cached  bool
+}
+
+// NewTableCache creates a table cache over dir.
+func NewTableCache(dir string, maxOpenFiles int, blocks cache.Cache) *TableCache {
+	if maxOpenFiles <= 0 {
+		maxOpenFiles = DefaultMaxOpenFiles
+	}
+	return &TableCache{
+		dir:      dir,
+		capacity: maxOpenFiles,
+		blocks:   blocks,
+		lru:      list.New(),
+		entries:  make(map[uint64]*list.Element),
+		loading:  make(map[uint64]*tableLoad),
+	}
+}
+
+// acquire pins the reader for fileNum, opening it on a miss.
+// Callers must release the handle.
+func (tc *TableCache) acquire(fileNum uint64, cmp internal.Comparator) (*tableHandle, error) {
+	tc.mu.Lock()
+	for {
+		if elem, ok := tc.entries[fileNum]; ok {
+			defer tc.mu.Unlock()
+			return tc.pinLocked(elem), nil
+		}
+		l, ok := tc.loading[fileNum]
+		if !ok {
+			break
+		}
+		// Another caller is opening it; share the result.
+		tc.mu.Unlock()
+		<-l.done
+		if l.err != nil {
+			return nil, l.err
+		}
+		tc.mu.Lock()
+	}
+	l := &tableLoad{done: make(chan struct{})}
+	tc.loading[fileNum] = l
+	tc.mu.Unlock()
+
+	path := filepath.Join(tc.dir, fmt.Sprintf("%06d.sst", fileNum))
+	r, err := sstable.NewReader(path, tc.blocks, tc.filter)
+	if err == nil {
+		r.SetComparator(cmp.Compare)
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.loading, fileNum)
+	l.err = err
+	close(l.done)
+	if err != nil {
+		return nil, err
+	}
+
+	if elem, ok := tc.entries[fileNum]; ok {
+		r.Close()
+		return tc.pinLocked(elem), nil
+	}
+	h := &tableHandle{fileNum: fileNum, reader: r, refs: 1}
+	if l.evicted {
+		// The file is being deleted; the reader closes on release.
+		return h, nil
+	}
+	h.cached = true
+	tc.entries[fileNum] = tc.lru.PushFront(h)
+	tc.evictLocked()
+
+	return h, nil
+}
+
+// pinLocked takes a reference on a cached reader. Caller holds mu.
+func (tc *TableCache) pinLocked(elem *list.Element) *tableHandle {
+	tc.lru.MoveToFront(elem)
+	h := elem.Value.(*tableHandle)
+	h.refs++
+	return h
+}
+
+// release unpins a handle, closing its file if it has left the cache.
+func (tc *TableCache) release(h *tableHandle) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	h.refs--
+	if h.refs == 0 && !h.cached {
+		h.reader.Close()
+	}
+}
+
+// Evict drops fileNum from the cache. Called when the file is deleted.
+func (tc *TableCache) Evict(fileNum uint64) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if elem, ok := tc.entries[fileNum]; ok {
+		tc.removeLocked(elem)
+	}
+	if l, ok := tc.loading[fileNum]; ok {
+		l.evicted = true
+	}
+}
+
+// Len returns the number of cached readers.
+func (tc *TableCache) Len() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.lru.Len()
+}
+
+// Close drops every cached reader.
+func (tc *TableCache) Close() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	for tc.lru.Len() > 0 {
+		tc.removeLocked(tc.lru.Back())
+	}
+}
+
+// Versions calls fn for each version of lookup's user key in one table,
+// newest first, until fn returns false.
+func (tc *TableCache) Versions(fileNum uint64, cmp internal.Comparator, lookup []byte, fn func(key, value []byte) bool) error {
+	h, err := tc.acquire(fileNum, cmp)
+	if err != nil {
+		return err
+	}
+	defer tc.release(h)
+
+	return h.reader.Versions(lookup, fn)
+}
+
+// MultiVersions is Versions for many lookups, sorted ascending, in one table.
+// fn receives the index of the lookup each version belongs to.
+func (tc *TableCache) MultiVersions(fileNum uint64, cmp internal.Comparator, lookups [][]byte, fn func(i int, key, value []byte) bool) error {
+	h, err := tc.acquire(fileNum, cmp)
+	if err != nil {
+		return err
+	}
+	defer tc.release(h)
+
+	return h.reader.MultiVersions(lookups, fn)
+}
+
+// RangeTombstones returns the range tombstones stored in one table.
+func (tc *TableCache) RangeTombstones(fileNum uint64, cmp internal.Comparator) ([]internal.RangeTombstone, error) {
+	h, err := tc.acquire(fileNum, cmp)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.release(h)
+
+	return h.reader.RangeTombstones(), nil
+}
+
+// PrefixMayMatch reports whether fileNum may hold keys with prefix, as produced
+// by the named extractor. The table's range tombstones are returned with it,
+// since skipping the table must not skip its deletions.
+func (tc *TableCache) PrefixMayMatch(fileNum uint64, cmp internal.Comparator, extractor string, prefix []byte) (bool, []internal.RangeTombstone, error) {
+	h, err := tc.acquire(fileNum, cmp)
+	if err != nil {
+		return false, nil, err
+	}
+	defer tc.release(h)
+
+	return h.reader.PrefixMayMatch(extractor, prefix), h.reader.RangeTombstones(), nil
+}
+
+// NewIterator opens an iterator over fileNum.
+// The returned release func unpins the reader once the iterator is done.
+func (tc *TableCache) NewIterator(fileNum uint64, cmp internal.Comparator) (*sstable.TableIterator, func(), error) {
+	h, err := tc.acquire(fileNum, cmp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	it, err := h.reader.NewIterator()
+	if err != nil {
+		tc.release(h)
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return it, func() { once.Do(func() { tc.release(h) }) }, nil
+}
+
+func (tc *TableCache) evictLocked() {
+	for tc.lru.Len() > tc.capacity {
+		tc.removeLocked(tc.lru.Back())
+	}
+}
+
+func (tc *TableCache) removeLocked(elem *list.Element) {
+	h := elem.Value.(*tableHandle)
+	tc.lru.Remove(elem)
+	delete(tc.entries, h.fileNum)
+	h.cached = false
+	if h.refs == 0 {
+		h.reader.Close()
+	}
+}
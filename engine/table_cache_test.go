@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/sstable"
+)
+
+// buildTable writes a one-key SSTable for table cache tests.
+func buildTable(t *testing.T, dir string, fileNum uint64, key string) {
+	t.Helper()
+	path := filepath.Join(dir, fmt.Sprintf("%06d.sst", fileNum))
+	b, err := sstable.NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(internal.EncodeInternalKey([]byte(key), 1, internal.RecordTypeValue), []byte(key))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableCacheBoundsOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := uint64(1); i <= 5; i++ {
+		buildTable(t, dir, i, fmt.Sprintf("k%d", i))
+	}
+
+	tc := NewTableCache(dir, 2, nil)
+	defer tc.Close()
+
+	for i := uint64(1); i <= 5; i++ {
+		var v []byte
+		lookup := internal.SeekKey([]byte(fmt.Sprintf("k%d", i)), internal.MaxSequenceNumber)
+		err := tc.Versions(i, internal.Comparator{}, lookup, func(key, value []byte) bool {
+			v = value
+			return false
+		})
+		if err != nil {
+			t.Fatalf("Versions from table %d: %v", i, err)
+		}
+		if string(v) != fmt.Sprintf("k%d", i) {
+			t.Fatalf("unexpected value %s", v)
+		}
+	}
+
+	if n := tc.Len(); n != 2 {
+		t.Fatalf("expected 2 cached readers, got %d", n)
+	}
+}
+
+func TestTableCachePinnedReaderSurvivesEviction(t *testing.T) {
+	dir := t.TempDir()
+	buildTable(t, dir, 1, "a")
+
+	tc := NewTableCache(dir, 10, nil)
+	defer tc.Close()
+
+	it, release, err := tc.NewIterator(1, internal.Comparator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate cleanupObsoleteFiles while the iterator is live.
+	tc.Evict(1)
+	os.Remove(filepath.Join(dir, "000001.sst"))
+
+	it.SeekToFirst()
+	if !it.Valid() || string(it.Value()) != "a" {
+		t.Fatalf("pinned reader should remain readable after eviction")
+	}
+	if tc.Len() != 0 {
+		t.Fatalf("evicted reader should leave the cache")
+	}
+
+	release()
+	release() // Idempotent.
+}
+
+func TestTableCacheConcurrentOpen(t *testing.T) {
+	dir := t.TempDir()
+	for i := uint64(1); i <= 4; i++ {
+		buildTable(t, dir, i, fmt.Sprintf("k%d", i))
+	}
+
+	tc := NewTableCache(dir, 10, nil)
+	defer tc.Close()
+
+	// Many callers race to open the same few tables.
+	var wg sync.WaitGroup
+	handles := make([]*tableHandle, 64)
+	errs := make([]error, len(handles))
+	for i := range handles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			handles[i], errs[i] = tc.acquire(uint64(i%4+1), internal.Comparator{})
+		}(i)
+	}
+	wg.Wait()
+
+	readers := make(map[uint64]*sstable.Reader)
+	for i, h := range handles {
+		if errs[i] != nil {
+			t.Fatalf("acquire: %v", errs[i])
+		}
+		if r, ok := readers[h.fileNum]; ok && r != h.reader {
+			t.Fatalf("table %d opened twice", h.fileNum)
+		}
+		readers[h.fileNum] = h.reader
+	}
+	for _, h := range handles {
+		tc.release(h)
+	}
+	if n := tc.Len(); n != 4 {
+		t.Fatalf("expected 4 cached readers, got %d", n)
+	}
+
+	if _, err := tc.acquire(99, internal.Comparator{}); err == nil {
+		t.Fatalf("expected an error opening a missing table")
+	}
+	if len(tc.loading) != 0 {
+		t.Fatalf("failed open left a placeholder")
+	}
+}
@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"errors"
+	"sort"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/wal"
+)
+
+var (
+	ErrConflict = errors.New("transaction conflict")
+	ErrTxnDone  = errors.New("transaction already committed or rolled back")
+)
+
+// TransactionOptions controls an optimistic transaction.
+type TransactionOptions struct {
+	// WriteConflictsOnly skips validation of keys that were only read,
+	// giving snapshot isolation instead of serializable reads.
+	WriteConflictsOnly bool
+}
+
+// Txn is an optimistic transaction.
+// Reads see the DB as of BeginTransaction plus the transaction's own writes.
+// Writes are buffered and applied as one batch by Commit, which fails with
+// ErrConflict if a key the transaction read or wrote changed after it began.
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db       *DB
+	opts     TransactionOptions
+	snapshot *Snapshot
+
+	records []wal.LogicalRecord
+	writes  map[string]txnWrite // Latest buffered write per key.
+	reads   map[string]struct{}
+	done    bool
+}
+
+// txnWrite is the buffered state of one key.
+type txnWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// BeginTransaction starts an optimistic transaction. nil opts uses the defaults.
+func (db *DB) BeginTransaction(opts *TransactionOptions) *Txn {
+	t := &Txn{
+		db:       db,
+		snapshot: db.GetSnapshot(),
+		writes:   make(map[string]txnWrite),
+		reads:    make(map[string]struct{}),
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	return t
+}
+
+// Get reads key, preferring the transaction's own writes.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	if w, ok := t.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), w.value...), nil
+	}
+	t.reads[string(key)] = struct{}{}
+	return t.db.GetWithOptions(key, &ReadOptions{Snapshot: t.snapshot})
+}
+
+// Put buffers a write of key.
+func (t *Txn) Put(key, value []byte) error {
+	return t.buffer(wal.LogicalRecord{
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), value...),
+		Type:  wal.LogicalTypePut,
+	})
+}
+
+// Delete buffers a deletion of key.
+func (t *Txn) Delete(key []byte) error {
+	return t.buffer(wal.LogicalRecord{
+		Key:  append([]byte(nil), key...),
+		Type: wal.LogicalTypeDelete,
+	})
+}
+
+func (t *Txn) buffer(r wal.LogicalRecord) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(r.Key) == 0 {
+		return ErrEmptyKey
+	}
+	t.records = append(t.records, r)
+	t.writes[string(r.Key)] = txnWrite{
+		value:   r.Value,
+		deleted: r.Type == wal.LogicalTypeDelete,
+	}
+	return nil
+}
+
+// NewIterator iterates over the transaction's snapshot overlaid with its own writes.
+// Keys it lands on count as reads; keys inserted by others into the scanned
+// range are not detected.
+func (t *Txn) NewIterator() Iterator {
+	base := t.db.NewIterator(&ReadOptions{Snapshot: t.snapshot})
+	return t.db.newTxnIterator(base, t.writes, func(key []byte) {
+		if !t.done {
+			t.reads[string(key)] = struct{}{}
+		}
+	})
+}
+
+// Commit validates the transaction and applies its writes atomically.
+// The transaction is finished afterwards, whether or not Commit succeeded.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.finish()
+
+	if len(t.records) == 0 {
+		return nil
+	}
+	db := t.db
+	// The check runs in a commit group of its own, so nothing lands
+	// between validation and apply.
+	w := db.newWriter(t.records, nil)
+	w.check = func() error {
+		for key := range t.writes {
+			if err := t.validateLocked(key); err != nil {
+				return err
+			}
+		}
+		if !t.opts.WriteConflictsOnly {
+			for key := range t.reads {
+				if err := t.validateLocked(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return db.write(w)
+}
+
+func (t *Txn) validateLocked(key string) error {
+	changed, err := t.db.changedSinceLocked(t.db.columnFamily, []byte(key), t.snapshot.ReadSeq)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Rollback discards the transaction's writes.
+func (t *Txn) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.finish()
+	return nil
+}
+
+func (t *Txn) finish() {
+	t.done = true
+	t.db.ReleaseSnapshot(t.snapshot)
+	t.snapshot = nil
+	t.records = nil
+}
+
+// changedSinceLocked reports whether any version of key in cf, or a range
+// tombstone covering it, is newer than seq. Caller holds db.mu.
+// Sources are probed newest-first, so the first version found settles it.
+func (db *DB) changedSinceLocked(cf *columnFamily, key []byte, seq uint64) (bool, error) {
+	lookup := internal.SeekKey(key, internal.MaxSequenceNumber)
+
+	var changed, found bool
+	cover := func(ts []internal.RangeTombstone) {
+		if coveringSeq(cf.cmp, ts, key, internal.MaxSequenceNumber) > seq {
+			changed = true
+		}
+	}
+	collect := func(k, _ []byte) bool {
+		found = true
+		if s, _, _ := internal.ExtractTrailer(k); s > seq {
+			changed = true
+		}
+		return false
+	}
+
+	// Memtables.
+	for _, mt := range cf.memtablesLocked() {
+		cover(mt.RangeTombstones())
+		mt.Versions(lookup, collect)
+		if changed || found {
+			return changed, nil
+		}
+	}
+
+	// Tables, L0 newest first. The version cannot be replaced while db.mu is held.
+	ver := cf.version.Current()
+	defer ver.Unref()
+
+	l0 := append([]SSTableMeta(nil), ver.Levels[0]...)
+	sort.Slice(l0, func(i, j int) bool {
+		return l0[i].FileNum > l0[j].FileNum
+	})
+	levels := [][]SSTableMeta{l0}
+	levels = append(levels, ver.Levels[1:]...)
+
+	for _, files := range levels {
+		for _, meta := range files {
+			if meta.LargestSeq <= seq || !meta.containsUserKey(cf.cmp, key) {
+				continue
+			}
+			if err := db.probeTable(cf, meta.FileNum, lookup, cover, collect); err != nil {
+				return false, err
+			}
+			if changed || found {
+				return changed, nil
+			}
+		}
+	}
+	return changed, nil
+}
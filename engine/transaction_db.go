@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"vern_kv0.8/wal"
+)
+
+// DefaultLockTimeout bounds lock waits when TransactionDBOptions leaves it unset.
+const DefaultLockTimeout = time.Second
+
+// TransactionDBOptions configures a TransactionDB.
+type TransactionDBOptions struct {
+	// LockTimeout bounds how long a lock request waits. Zero uses DefaultLockTimeout.
+	LockTimeout time.Duration
+}
+
+// TransactionDB adds pessimistic transactions to a DB.
+// Transactions lock every key they write or read with GetForUpdate and hold
+// the locks until Commit or Rollback, so commits never conflict.
+// Writes made directly on the underlying DB bypass the locks.
+type TransactionDB struct {
+	db      *DB
+	locks   *lockManager
+	timeout time.Duration
+	nextID  atomic.Uint64
+}
+
+// NewTransactionDB wraps db. nil opts uses the defaults.
+func NewTransactionDB(db *DB, opts *TransactionDBOptions) *TransactionDB {
+	tdb := &TransactionDB{
+		db:      db,
+		locks:   newLockManager(),
+		timeout: DefaultLockTimeout,
+	}
+	if opts != nil && opts.LockTimeout > 0 {
+		tdb.timeout = opts.LockTimeout
+	}
+	return tdb
+}
+
+// DB returns the underlying database.
+func (tdb *TransactionDB) DB() *DB {
+	return tdb.db
+}
+
+// Put writes key outside any transaction, waiting for its lock.
+func (tdb *TransactionDB) Put(key, value []byte) error {
+	txn := tdb.BeginTransaction()
+	if err := txn.Put(key, value); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// Delete deletes key outside any transaction, waiting for its lock.
+func (tdb *TransactionDB) Delete(key []byte) error {
+	txn := tdb.BeginTransaction()
+	if err := txn.Delete(key); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// Get reads the latest committed value of key.
+func (tdb *TransactionDB) Get(key []byte) ([]byte, error) {
+	return tdb.db.Get(key)
+}
+
+// BeginTransaction starts a pessimistic transaction reading from a snapshot
+// of the current state.
+func (tdb *TransactionDB) BeginTransaction() *PessimisticTxn {
+	return &PessimisticTxn{
+		tdb:      tdb,
+		id:       tdb.nextID.Add(1),
+		snapshot: tdb.db.GetSnapshot(),
+		writes:   make(map[string]txnWrite),
+		locked:   make(map[string]bool),
+	}
+}
+
+// PessimisticTxn is a transaction that locks keys as it touches them.
+// Get and NewIterator read the snapshot taken when it began; GetForUpdate
+// reads the latest committed value once it holds the key's lock.
+// A lock request fails with ErrLockTimeout or ErrDeadlock; the transaction
+// stays usable but should normally be rolled back.
+// A PessimisticTxn is not safe for concurrent use.
+type PessimisticTxn struct {
+	tdb      *TransactionDB
+	id       uint64
+	snapshot *Snapshot
+
+	records []wal.LogicalRecord
+	writes  map[string]txnWrite // Latest buffered write per key.
+	locked  map[string]bool
+	keys    []string // Locked keys, in acquisition order.
+	done    bool
+}
+
+func (t *PessimisticTxn) lock(key []byte) error {
+	if t.locked[string(key)] {
+		return nil
+	}
+	if err := t.tdb.locks.lock(t.id, string(key), t.tdb.timeout); err != nil {
+		return err
+	}
+	t.locked[string(key)] = true
+	t.keys = append(t.keys, string(key))
+	return nil
+}
+
+// Get reads key from the transaction's snapshot, preferring its own writes.
+// It takes no lock, so the value may change before Commit.
+func (t *PessimisticTxn) Get(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	return t.read(key, &ReadOptions{Snapshot: t.snapshot})
+}
+
+// GetForUpdate locks key, then reads it.
+// No other transaction can change key until this one finishes.
+func (t *PessimisticTxn) GetForUpdate(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	if err := t.lock(key); err != nil {
+		return nil, err
+	}
+	return t.read(key, nil)
+}
+
+// read looks key up in the transaction's writes, then in the DB with opts.
+func (t *PessimisticTxn) read(key []byte, opts *ReadOptions) ([]byte, error) {
+	if w, ok := t.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), w.value...), nil
+	}
+	return t.tdb.db.GetWithOptions(key, opts)
+}
+
+// Put locks key and buffers a write of it.
+func (t *PessimisticTxn) Put(key, value []byte) error {
+	return t.buffer(wal.LogicalRecord{
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), value...),
+		Type:  wal.LogicalTypePut,
+	})
+}
+
+// Delete locks key and buffers a deletion of it.
+func (t *PessimisticTxn) Delete(key []byte) error {
+	return t.buffer(wal.LogicalRecord{
+		Key:  append([]byte(nil), key...),
+		Type: wal.LogicalTypeDelete,
+	})
+}
+
+func (t *PessimisticTxn) buffer(r wal.LogicalRecord) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(r.Key) == 0 {
+		return ErrEmptyKey
+	}
+	if err := t.lock(r.Key); err != nil {
+		return err
+	}
+	t.records = append(t.records, r)
+	t.writes[string(r.Key)] = txnWrite{
+		value:   r.Value,
+		deleted: r.Type == wal.LogicalTypeDelete,
+	}
+	return nil
+}
+
+// NewIterator iterates over the transaction's snapshot overlaid with its own
+// writes. Iterated keys are not locked.
+func (t *PessimisticTxn) NewIterator() Iterator {
+	base := t.tdb.db.NewIterator(&ReadOptions{Snapshot: t.snapshot})
+	return t.tdb.db.newTxnIterator(base, t.writes, func([]byte) {})
+}
+
+// Commit applies the transaction's writes as one batch and releases its locks.
+func (t *PessimisticTxn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.finish()
+
+	if len(t.records) == 0 {
+		return nil
+	}
+	return t.tdb.db.writeRecords(t.records, nil)
+}
+
+// Rollback discards the transaction's writes and releases its locks.
+func (t *PessimisticTxn) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.finish()
+	return nil
+}
+
+func (t *PessimisticTxn) finish() {
+	t.done = true
+	t.tdb.locks.unlock(t.id, t.keys)
+	t.tdb.db.ReleaseSnapshot(t.snapshot)
+	t.snapshot = nil
+	t.records = nil
+	t.keys = nil
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTransactionDB(t *testing.T, timeout time.Duration) *TransactionDB {
+	t.Helper()
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewTransactionDB(db, &TransactionDBOptions{LockTimeout: timeout})
+}
+
+func TestPessimisticTxnLockTimeout(t *testing.T) {
+	tdb := openTransactionDB(t, 50*time.Millisecond)
+
+	a := tdb.BeginTransaction()
+	if _, err := a.GetForUpdate([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	b := tdb.BeginTransaction()
+	if err := b.Put([]byte("k"), []byte("b")); err != ErrLockTimeout {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if err := tdb.Put([]byte("k"), []byte("direct")); err != ErrLockTimeout {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+
+	a.Put([]byte("k"), []byte("a"))
+	if err := a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Released on commit.
+	if err := b.Put([]byte("k"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := tdb.Get([]byte("k")); err != nil || string(v) != "a" {
+		t.Fatalf("Get: %q, %v", v, err)
+	}
+}
+
+func TestPessimisticTxnSnapshotReads(t *testing.T) {
+	tdb := openTransactionDB(t, 50*time.Millisecond)
+	tdb.Put([]byte("a"), []byte("1"))
+	tdb.Put([]byte("b"), []byte("1"))
+
+	txn := tdb.BeginTransaction()
+	defer txn.Rollback()
+	if v, err := txn.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("Get: %q, %v", v, err)
+	}
+
+	tdb.Put([]byte("a"), []byte("2"))
+	tdb.Put([]byte("b"), []byte("2"))
+	tdb.Put([]byte("c"), []byte("2"))
+
+	// Unlocked reads stay on the snapshot.
+	if v, err := txn.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("repeated Get: %q, %v", v, err)
+	}
+	it := txn.NewIterator()
+	var got []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, string(it.Key())+"="+string(it.Value()))
+	}
+	it.Close()
+	if want := "[a=1 b=1]"; fmt.Sprint(got) != want {
+		t.Fatalf("iterator: got %v, want %s", got, want)
+	}
+
+	// A locked read sees the latest commit.
+	if v, err := txn.GetForUpdate([]byte("b")); err != nil || string(v) != "2" {
+		t.Fatalf("GetForUpdate: %q, %v", v, err)
+	}
+}
+
+func TestPessimisticTxnDeadlock(t *testing.T) {
+	tdb := openTransactionDB(t, 5*time.Second)
+
+	a := tdb.BeginTransaction()
+	b := tdb.BeginTransaction()
+	if err := a.Put([]byte("x"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put([]byte("y"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a waits for b...
+	done := make(chan error, 1)
+	go func() { done <- a.Put([]byte("y"), []byte("a")) }()
+	for {
+		tdb.locks.mu.Lock()
+		_, waiting := tdb.locks.waitFor[a.id]
+		tdb.locks.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...so b waiting for a closes the cycle.
+	start := time.Now()
+	if err := b.Put([]byte("x"), []byte("b")); err != ErrDeadlock {
+		t.Fatalf("expected ErrDeadlock, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("deadlock was not detected before the timeout")
+	}
+
+	b.Rollback()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tdb.Get([]byte("y")); string(v) != "a" {
+		t.Fatalf("expected a, got %q", v)
+	}
+}
+
+func TestPessimisticTxnConcurrentIncrements(t *testing.T) {
+	tdb := openTransactionDB(t, 10*time.Second)
+	if err := tdb.Put([]byte("stock"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, rounds = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				txn := tdb.BeginTransaction()
+				v, err := txn.GetForUpdate([]byte("stock"))
+				if err != nil {
+					t.Error(err)
+					txn.Rollback()
+					return
+				}
+				n, _ := strconv.Atoi(string(v))
+				txn.Put([]byte("stock"), []byte(strconv.Itoa(n+1)))
+				if err := txn.Commit(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, err := tdb.Get([]byte("stock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != strconv.Itoa(workers*rounds) {
+		t.Fatalf("lost updates: got %s, want %d", v, workers*rounds)
+	}
+}
@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"bytes"
+	"sort"
+
+	"vern_kv0.8/internal"
+)
+
+// txnIterator overlays a transaction's buffered writes on a snapshot iterator.
+// A buffered write shadows the base entry with the same key; buffered deletes hide it.
+//
+// Moving forward, both inputs rest on or after the current key;
+// moving backward, on or before it.
+type txnIterator struct {
+	base    Iterator
+	cmp     internal.Comparator
+	keys    []string   // Buffered keys, sorted by cmp.
+	pending []txnWrite // Parallel to keys.
+	pos     int        // Index into keys; -1 or len(keys) when exhausted.
+
+	onRead func(key []byte)
+
+	forward bool
+	valid   bool
+	key     []byte
+	value   []byte
+}
+
+// newTxnIterator overlays writes on base.
+func (db *DB) newTxnIterator(base Iterator, writes map[string]txnWrite, onRead func(key []byte)) *txnIterator {
+	keys := make([]string, 0, len(writes))
+	for k := range writes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return db.cmp.CompareUser([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+
+	pending := make([]txnWrite, len(keys))
+	for i, k := range keys {
+		pending[i] = writes[k]
+	}
+
+	return &txnIterator{
+		base:    base,
+		cmp:     db.cmp,
+		keys:    keys,
+		pending: pending,
+		pos:     -1,
+		onRead:  onRead,
+	}
+}
+
+func (it *txnIterator) SeekToFirst() {
+	it.base.SeekToFirst()
+	it.pos = 0
+	it.forward = true
+	it.findNext()
+}
+
+func (it *txnIterator) SeekToLast() {
+	it.base.SeekToLast()
+	it.pos = len(it.keys) - 1
+	it.forward = false
+	it.findPrev()
+}
+
+func (it *txnIterator) Seek(key []byte) {
+	it.base.Seek(key)
+	it.pos = sort.Search(len(it.keys), func(i int) bool { return it.cmp.CompareUser([]byte(it.keys[i]), key) >= 0 })
+	it.forward = true
+	it.findNext()
+}
+
+func (it *txnIterator) SeekForPrev(key []byte) {
+	it.base.SeekForPrev(key)
+	it.pos = sort.Search(len(it.keys), func(i int) bool { return it.cmp.CompareUser([]byte(it.keys[i]), key) > 0 }) - 1
+	it.forward = false
+	it.findPrev()
+}
+
+func (it *txnIterator) Next() {
+	if !it.valid {
+		return
+	}
+	if !it.forward {
+		cur := append([]byte(nil), it.key...)
+		it.Seek(cur)
+		if !it.valid || !bytes.Equal(it.key, cur) {
+			return
+		}
+	}
+	it.skipCurrent()
+	it.findNext()
+}
+
+func (it *txnIterator) Prev() {
+	if !it.valid {
+		return
+	}
+	if it.forward {
+		cur := append([]byte(nil), it.key...)
+		it.SeekForPrev(cur)
+		if !it.valid || !bytes.Equal(it.key, cur) {
+			return
+		}
+	}
+	it.skipCurrent()
+	it.findPrev()
+}
+
+// skipCurrent steps every input resting on the current key past it.
+func (it *txnIterator) skipCurrent() {
+	if it.base.Valid() && bytes.Equal(it.base.Key(), it.key) {
+		if it.forward {
+			it.base.Next()
+		} else {
+			it.base.Prev()
+		}
+	}
+	if it.pos >= 0 && it.pos < len(it.keys) && it.keys[it.pos] == string(it.key) {
+		if it.forward {
+			it.pos++
+		} else {
+			it.pos--
+		}
+	}
+}
+
+// findNext settles on the smallest visible key at or after the inputs' positions.
+func (it *txnIterator) findNext() {
+	for {
+		hasPending := it.pos < len(it.keys)
+		if !it.base.Valid() && !hasPending {
+			it.valid = false
+			return
+		}
+
+		c := 1 // Which input is smaller: <0 base, >0 pending, 0 both.
+		if it.base.Valid() && hasPending {
+			c = it.cmp.CompareUser(it.base.Key(), []byte(it.keys[it.pos]))
+		} else if it.base.Valid() {
+			c = -1
+		}
+
+		if c < 0 {
+			it.setBase()
+			return
+		}
+		if c == 0 {
+			it.base.Next()
+		}
+		if w := it.pending[it.pos]; !w.deleted {
+			it.setPending(it.keys[it.pos], w)
+			return
+		}
+		it.pos++
+	}
+}
+
+// findPrev settles on the largest visible key at or before the inputs' positions.
+func (it *txnIterator) findPrev() {
+	for {
+		hasPending := it.pos >= 0
+		if !it.base.Valid() && !hasPending {
+			it.valid = false
+			return
+		}
+
+		c := -1 // Which input is larger: >0 base, <0 pending, 0 both.
+		if it.base.Valid() && hasPending {
+			c = it.cmp.CompareUser(it.base.Key(), []byte(it.keys[it.pos]))
+		} else if it.base.Valid() {
+			c = 1
+		}
+
+		if c > 0 {
+			it.setBase()
+			return
+		}
+		if c == 0 {
+			it.base.Prev()
+		}
+		if w := it.pending[it.pos]; !w.deleted {
+			it.setPending(it.keys[it.pos], w)
+			return
+		}
+		it.pos--
+	}
+}
+
+func (it *txnIterator) setBase() {
+	it.valid = true
+	it.key = append([]byte(nil), it.base.Key()...)
+	it.value = append([]byte(nil), it.base.Value()...)
+	it.onRead(it.key)
+}
+
+func (it *txnIterator) setPending(key string, w txnWrite) {
+	it.valid = true
+	it.key = []byte(key)
+	it.value = w.value
+}
+
+func (it *txnIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *txnIterator) Key() []byte {
+	return it.key
+}
+
+func (it *txnIterator) Value() []byte {
+	return it.value
+}
+
+func (it *txnIterator) Close() error {
+	return it.base.Close()
+}
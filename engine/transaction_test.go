@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTxnReadYourOwnWrites(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	putKeys(t, db, "a", "b", "c", "d")
+	db.freezeMemtable()
+
+	txn := db.BeginTransaction(nil)
+	txn.Put([]byte("b"), []byte("txn-b"))
+	txn.Delete([]byte("c"))
+	txn.Put([]byte("e"), []byte("txn-e"))
+
+	// Not visible outside before commit.
+	if _, err := db.Get([]byte("e")); err != ErrNotFound {
+		t.Fatalf("uncommitted write visible: %v", err)
+	}
+	if v, err := txn.Get([]byte("b")); err != nil || string(v) != "txn-b" {
+		t.Fatalf("txn Get(b): %q, %v", v, err)
+	}
+	if _, err := txn.Get([]byte("c")); err != ErrNotFound {
+		t.Fatalf("txn Get(c): expected ErrNotFound, got %v", err)
+	}
+
+	it := txn.NewIterator()
+	var forward, reverse []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		forward = append(forward, string(it.Key())+"="+string(it.Value()))
+	}
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		reverse = append(reverse, string(it.Key()))
+	}
+	// Switch direction mid-scan.
+	it.Seek([]byte("b"))
+	it.Next()
+	it.Prev()
+	mid := string(it.Key())
+	it.Close()
+
+	if fmt.Sprint(forward) != "[a=va b=txn-b d=vd e=txn-e]" {
+		t.Fatalf("unexpected forward scan: %v", forward)
+	}
+	if fmt.Sprint(reverse) != "[e d b a]" {
+		t.Fatalf("unexpected reverse scan: %v", reverse)
+	}
+	if mid != "b" {
+		t.Fatalf("expected b after Next/Prev, got %s", mid)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Fatalf("expected ErrTxnDone, got %v", err)
+	}
+	if v, err := db.Get([]byte("e")); err != nil || string(v) != "txn-e" {
+		t.Fatalf("Get(e) after commit: %q, %v", v, err)
+	}
+	if _, err := db.Get([]byte("c")); err != ErrNotFound {
+		t.Fatalf("Get(c) after commit: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTxnConflicts(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	putKeys(t, db, "read", "written", "other")
+	db.freezeMemtable()
+
+	// Write-write conflict.
+	txn := db.BeginTransaction(nil)
+	txn.Put([]byte("written"), []byte("txn"))
+	db.Put([]byte("written"), []byte("outside"))
+	if err := txn.Commit(); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if v, _ := db.Get([]byte("written")); string(v) != "outside" {
+		t.Fatalf("conflicting commit was applied: %q", v)
+	}
+
+	// Read-write conflict, including a range deletion over the read key.
+	txn = db.BeginTransaction(nil)
+	txn.Get([]byte("read"))
+	txn.Put([]byte("other"), []byte("txn"))
+	db.DeleteRange([]byte("r"), []byte("s"))
+	if err := txn.Commit(); err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	// Same history without read validation commits.
+	txn = db.BeginTransaction(&TransactionOptions{WriteConflictsOnly: true})
+	txn.Get([]byte("read"))
+	txn.Put([]byte("other"), []byte("txn"))
+	db.Put([]byte("read"), []byte("again"))
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to untouched keys and rolled back transactions never conflict.
+	txn = db.BeginTransaction(nil)
+	txn.Get([]byte("other"))
+	txn.Put([]byte("new"), []byte("txn"))
+	db.Put([]byte("unrelated"), []byte("x"))
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	txn = db.BeginTransaction(nil)
+	txn.Put([]byte("gone"), []byte("txn"))
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("gone")); err != ErrNotFound {
+		t.Fatalf("rolled back write visible: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/wal"
+)
+
+// ErrInvalidTTL is returned for a TTL that is not positive.
+var ErrInvalidTTL = errors.New("ttl must be positive")
+
+// PutWithTTL sets key to value until ttl has passed.
+// Once expired, the key reads as deleted and is dropped by the next flush
+// or compaction that sees it.
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	expiry := db.now().Add(ttl).UnixNano()
+	return db.writeRecord(nil, wal.LogicalRecord{
+		Key:   key,
+		Value: internal.EncodeExpiringValue(expiry, value),
+		Type:  wal.LogicalTypePutTTL,
+	}, nil)
+}
+
+// now reads Config.Clock.
+func (db *DB) now() time.Time {
+	if db.opts.Clock != nil {
+		return db.opts.Clock()
+	}
+	return time.Now()
+}
+
+// resolveExpiry turns an expiring value into a plain Value, or into a
+// Tombstone once now has reached its expiry. Other records pass through.
+// includeExpired keeps expired values readable.
+func resolveExpiry(typ internal.RecordType, value []byte, now int64, includeExpired bool) (internal.RecordType, []byte) {
+	if typ != internal.RecordTypeExpiringValue {
+		return typ, value
+	}
+	expiry, payload, ok := internal.DecodeExpiringValue(value)
+	if !ok || (now >= expiry && !includeExpired) {
+		return internal.RecordTypeTombstone, nil
+	}
+	return internal.RecordTypeValue, payload
+}
+
+// expired reports whether key holds an expiring value past its expiry.
+func expired(key, value []byte, now int64) bool {
+	_, typ, _ := internal.ExtractTrailer(key)
+	if typ != internal.RecordTypeExpiringValue {
+		return false
+	}
+	t, _ := resolveExpiry(typ, value, now, false)
+	return t == internal.RecordTypeTombstone
+}
+
+// expiredAsTombstone rewrites an expired entry as a tombstone with the same sequence,
+// so it keeps shadowing older versions until tombstone GC drops it.
+func expiredAsTombstone(key []byte) []byte {
+	seq, _, _ := internal.ExtractTrailer(key)
+	return internal.EncodeInternalKey(internal.ExtractUserKey(key), seq, internal.RecordTypeTombstone)
+}
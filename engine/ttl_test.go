@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"vern_kv0.8/internal"
+)
+
+// fakeClock is a settable Config.Clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func openTTLDB(t *testing.T) (*DB, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cfg := DefaultConfig()
+	cfg.Clock = clock.Now
+	db, err := Open(t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, clock
+}
+
+func TestTTLExpiryOnRead(t *testing.T) {
+	db, clock := openTTLDB(t)
+
+	if err := db.PutWithTTL([]byte("k"), []byte("v"), 0); err != ErrInvalidTTL {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+
+	db.Put([]byte("k"), []byte("old"))
+	if err := db.PutWithTTL([]byte("k"), []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	db.PutWithTTL([]byte("short"), []byte("v"), time.Second)
+	db.Put([]byte("z"), []byte("v"))
+
+	expectValue(t, db, "k", "v")
+	expectValue(t, db, "short", "v")
+
+	clock.Advance(2 * time.Second)
+	if _, err := db.Get([]byte("short")); err != ErrNotFound {
+		t.Fatalf("expected short to expire, got %v", err)
+	}
+	expectValue(t, db, "k", "v")
+
+	clock.Advance(time.Minute)
+	// The expired value hides the older one rather than uncovering it.
+	if _, err := db.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected k to expire, got %v", err)
+	}
+
+	forward, reverse := scanKeys(db, nil)
+	if len(forward) != 1 || forward[0] != "z" || len(reverse) != 1 {
+		t.Fatalf("expected only z, got %v / %v", forward, reverse)
+	}
+
+	forward, _ = scanKeys(db, &ReadOptions{IncludeExpired: true})
+	if len(forward) != 3 {
+		t.Fatalf("expected expired keys with IncludeExpired, got %v", forward)
+	}
+	v, err := db.GetWithOptions([]byte("k"), &ReadOptions{IncludeExpired: true})
+	if err != nil || string(v) != "v" {
+		t.Fatalf("expected expired value v, got %q, %v", v, err)
+	}
+}
+
+func TestTTLSurvivesRecovery(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cfg := DefaultConfig()
+	cfg.Clock = clock.Now
+
+	db, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.PutWithTTL([]byte("k"), []byte("v"), time.Minute)
+	db.Close()
+
+	db, err = Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectValue(t, db, "k", "v")
+	clock.Advance(time.Hour)
+	if _, err := db.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected k to expire after recovery, got %v", err)
+	}
+}
+
+func TestTTLDroppedByFlushAndCompaction(t *testing.T) {
+	db, clock := openTTLDB(t)
+
+	// countType counts table entries of typ; 0 counts every entry.
+	countType := func(typ internal.RecordType) int {
+		n := 0
+		for _, meta := range db.version.GetAllTables() {
+			sst, release, err := db.tableCache.NewIterator(meta.FileNum, db.cmp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for sst.SeekToFirst(); sst.Valid(); sst.Next() {
+				if _, tt, _ := internal.ExtractTrailer(sst.Key()); typ == 0 || tt == typ {
+					n++
+				}
+			}
+			release()
+		}
+		return n
+	}
+
+	// Live at flush time: kept with its expiry.
+	db.PutWithTTL([]byte("a"), []byte("v"), time.Minute)
+	db.freezeMemtable()
+	if n := countType(internal.RecordTypeExpiringValue); n != 1 {
+		t.Fatalf("expected 1 expiring value after flush, got %d", n)
+	}
+
+	// Expired by flush time: written as a tombstone.
+	db.PutWithTTL([]byte("b"), []byte("v"), time.Second)
+	clock.Advance(2 * time.Second)
+	db.freezeMemtable()
+	if n := countType(internal.RecordTypeTombstone); n != 1 {
+		t.Fatalf("expected expired entry flushed as tombstone, got %d tombstones", n)
+	}
+
+	clock.Advance(time.Hour)
+	if err := db.CompactLevel(0); err != nil {
+		t.Fatal(err)
+	}
+	if n := countType(0); n != 0 {
+		t.Fatalf("expected compaction to drop every expired entry, %d left", n)
+	}
+	for _, k := range []string{"a", "b"} {
+		if _, err := db.Get([]byte(k)); err != ErrNotFound {
+			t.Fatalf("Get(%s): expected ErrNotFound, got %v", k, err)
+		}
+	}
+}
+
+func TestTTLInWriteBatch(t *testing.T) {
+	db, clock := openTTLDB(t)
+
+	b := NewWriteBatch()
+	b.PutWithExpiry([]byte("k"), []byte("v"), clock.Now().Add(time.Minute))
+	b.Put([]byte("z"), []byte("v"))
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, "k", "v")
+
+	clock.Advance(2 * time.Minute)
+	if _, err := db.Get([]byte("k")); err != ErrNotFound {
+		t.Fatalf("expected the batched TTL write to expire, got %v", err)
+	}
+	expectValue(t, db, "z", "v")
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"errors"
+	"os"
+
+	"vern_kv0.8/wal"
+)
+
+// ErrWALTooOld is returned by GetUpdatesSince when the WAL segments holding
+// the requested sequence number have been removed.
+var ErrWALTooOld = errors.New("requested sequence is no longer in the WAL")
+
+// errSegmentRemoved reports a segment truncated while it was being located.
+var errSegmentRemoved = errors.New("wal segment removed")
+
+// UpdateIterator walks committed WAL batches in sequence order.
+//
+// A batch is one WAL record: the writes of one group commit, numbered from
+// SeqStart. Records keep their WAL form; LogicalTypePutTTL values start with
+// their 8-byte expiry time.
+type UpdateIterator struct {
+	segments []string // Segments not yet read.
+	data     []byte   // The segment being read.
+	offset   int
+
+	since uint64 // Batches ending before since are skipped.
+	last  uint64 // The newest sequence committed when the iterator was created.
+
+	batch wal.Batch
+	valid bool
+	err   error
+}
+
+// GetUpdatesSince returns the committed writes from sequence number seq on,
+// read from the WAL. The first batch may start before seq if it contains it.
+// The iterator ends at the last write committed when it is created; to follow
+// the log, create another from the sequence after the last batch seen.
+//
+// Writes made with DisableWAL, and ingested files, are not in the WAL and are
+// not returned. Once flushes have truncated the segments holding seq, it
+// returns ErrWALTooOld; Config.WALRetention keeps segments around for longer.
+func (db *DB) GetUpdatesSince(seq uint64) (*UpdateIterator, error) {
+	if err := db.checkBackgroundError(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	last := db.nextSeq - 1
+	db.mu.RUnlock()
+
+	for {
+		it, err := newUpdateIterator(db.logDir(), seq, last)
+		// A concurrent truncation moved the start of the log; look again.
+		if err == errSegmentRemoved {
+			continue
+		}
+		return it, err
+	}
+}
+
+func newUpdateIterator(walDir string, since, last uint64) (*UpdateIterator, error) {
+	segments, err := wal.ListSegments(walDir)
+	if err != nil {
+		return nil, err
+	}
+	it := &UpdateIterator{since: since, last: last}
+	if since > last {
+		return it, nil
+	}
+
+	// Start at the last segment beginning at or before since; the batches
+	// before it end earlier.
+	start := 0
+	for i, path := range segments {
+		first, ok, err := wal.SegmentFirstSeq(path)
+		if os.IsNotExist(err) {
+			return nil, errSegmentRemoved
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok || first > since {
+			break
+		}
+		start = i
+	}
+	it.segments = segments[start:]
+
+	// Read after the segments: a truncation records what it removes before
+	// removing it, so any segment missing above is covered here.
+	truncated, ok, err := wal.TruncatedSeq(walDir)
+	if err != nil {
+		return nil, err
+	}
+	if !ok && len(segments) > 0 {
+		if n, _ := wal.SegmentNumber(segments[0]); n != 1 {
+			// Truncated before truncations were recorded: assume everything
+			// before the first surviving batch is gone.
+			truncated, ok = legacyTruncatedSeq(segments[0])
+		}
+	}
+	if ok && since <= truncated {
+		return nil, ErrWALTooOld
+	}
+
+	for it.readBatch() {
+		if batchEnd(it.batch) >= since {
+			it.valid = true
+			return it, nil
+		}
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return it, nil
+}
+
+// legacyTruncatedSeq returns the sequence before the first batch of the
+// segment at path.
+func legacyTruncatedSeq(path string) (uint64, bool) {
+	first, ok, err := wal.SegmentFirstSeq(path)
+	if err != nil || !ok {
+		return 0, false
+	}
+	return first - 1, true
+}
+
+// readBatch decodes the next batch into it.batch. It returns false at the
+// end of the committed log or on error.
+func (it *UpdateIterator) readBatch() bool {
+	for {
+		if it.offset < len(it.data) {
+			batch, n, err := wal.DecodeRecord(it.data[it.offset:])
+			if err == nil && batch.SeqStart > it.last {
+				// Written after the iterator was created.
+				it.segments = nil
+				it.data = nil
+				return false
+			}
+			if err == nil {
+				it.offset += n
+				it.batch = batch
+				return true
+			}
+			// Like recovery, skip the rest of a segment at a corrupt record.
+			it.data = nil
+		}
+
+		if len(it.segments) == 0 {
+			return false
+		}
+		data, err := os.ReadFile(it.segments[0])
+		if os.IsNotExist(err) {
+			// Truncated while unread: its batches are gone.
+			it.err = errSegmentRemoved
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.segments = it.segments[1:]
+		it.data, it.offset = data, 0
+	}
+}
+
+// Valid reports whether the iterator is positioned at a batch.
+func (it *UpdateIterator) Valid() bool {
+	return it.valid
+}
+
+// Next moves to the following batch.
+func (it *UpdateIterator) Next() {
+	if !it.valid {
+		return
+	}
+	it.valid = it.readBatch()
+	if it.err == errSegmentRemoved {
+		it.err = ErrWALTooOld
+	}
+}
+
+// Batch returns the current batch. Its records hold sequence numbers
+// SeqStart, SeqStart+1, and so on.
+func (it *UpdateIterator) Batch() wal.Batch {
+	return it.batch
+}
+
+// Err returns the error that ended the iteration, if any. ErrWALTooOld means
+// the remaining segments were truncated before they were read.
+func (it *UpdateIterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator.
+func (it *UpdateIterator) Close() error {
+	it.valid = false
+	it.segments = nil
+	it.data = nil
+	return nil
+}
+
+// batchEnd returns the sequence number of b's last record.
+func batchEnd(b wal.Batch) uint64 {
+	return b.SeqStart + uint64(len(b.Records)) - 1
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"vern_kv0.8/wal"
+)
+
+// collectUpdates returns each record from GetUpdatesSince(seq) by its sequence number.
+func collectUpdates(t *testing.T, db *DB, seq uint64) map[uint64]wal.LogicalRecord {
+	t.Helper()
+	it, err := db.GetUpdatesSince(seq)
+	if err != nil {
+		t.Fatalf("GetUpdatesSince(%d): %v", seq, err)
+	}
+	defer it.Close()
+
+	got := make(map[uint64]wal.LogicalRecord)
+	next := uint64(0)
+	for ; it.Valid(); it.Next() {
+		b := it.Batch()
+		if b.SeqStart < next {
+			t.Fatalf("batch at %d after one ending at %d", b.SeqStart, next-1)
+		}
+		for i, r := range b.Records {
+			got[b.SeqStart+uint64(i)] = r
+		}
+		next = b.SeqStart + uint64(len(b.Records))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	return got
+}
+
+func TestGetUpdatesSince(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1")) // seq 1
+	b := NewWriteBatch()
+	b.Put([]byte("b"), []byte("2")) // seq 2
+	b.Delete([]byte("a"))           // seq 3
+	if err := db.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("c"), []byte("3")) // seq 4
+
+	got := collectUpdates(t, db, 1)
+	if len(got) != 4 {
+		t.Fatalf("got %d records, want 4", len(got))
+	}
+	if r := got[3]; string(r.Key) != "a" || r.Type != wal.LogicalTypeDelete {
+		t.Fatalf("seq 3 = %q type %d, want delete of a", r.Key, r.Type)
+	}
+	if r := got[4]; string(r.Key) != "c" || string(r.Value) != "3" {
+		t.Fatalf("seq 4 = %q=%q, want c=3", r.Key, r.Value)
+	}
+
+	// Seq 3 sits inside the batch starting at 2, which is returned whole.
+	got = collectUpdates(t, db, 3)
+	if _, ok := got[2]; !ok || len(got) != 3 {
+		t.Fatalf("from seq 3 got %d records, want the batch from 2 and seq 4", len(got))
+	}
+
+	// Past the end there is nothing yet.
+	if got := collectUpdates(t, db, 5); len(got) != 0 {
+		t.Fatalf("from seq 5 got %d records", len(got))
+	}
+
+	// Flushed writes stay readable while their segment is live.
+	flushFamily(db, db.DefaultColumnFamily())
+	db.Put([]byte("d"), []byte("4"))
+	if got := collectUpdates(t, db, 1); len(got) != 5 {
+		t.Fatalf("after flush got %d records, want 5", len(got))
+	}
+}
+
+func TestGetUpdatesSinceTruncated(t *testing.T) {
+	for _, retain := range []bool{false, true} {
+		t.Run(fmt.Sprintf("retain=%v", retain), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.WALSegmentSize = 512
+			if retain {
+				cfg.WALRetention = WALRetention{Size: 1 << 20}
+			}
+			db, err := Open(t.TempDir(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			for i := 0; i < 50; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 64)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			flushFamily(db, db.DefaultColumnFamily())
+			if err := db.Put([]byte("last"), []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+
+			it, err := db.GetUpdatesSince(1)
+			if !retain {
+				if err != ErrWALTooOld {
+					t.Fatalf("expected ErrWALTooOld, got %v", err)
+				}
+				// The write after the flush is still in the log.
+				if got := collectUpdates(t, db, 51); len(got) != 1 || string(got[51].Key) != "last" {
+					t.Fatalf("from seq 51 got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("retained segments: %v", err)
+			}
+			it.Close()
+			if got := collectUpdates(t, db, 1); len(got) != 51 {
+				t.Fatalf("got %d records, want 51", len(got))
+			}
+		})
+	}
+}
+
+func TestUpdateIteratorStart(t *testing.T) {
+	dir := t.TempDir()
+
+	// Segments 2-4 hold batches 5-13, three records each; seq 4 was never
+	// logged and segment 1, up to seq 3, was truncated.
+	seq := uint64(5)
+	for n := 2; n <= 4; n++ {
+		seg, err := wal.OpenSegment(filepath.Join(dir, fmt.Sprintf("wal_%06d.log", n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			rec, err := wal.EncodeRecord(wal.Batch{SeqStart: seq, Records: []wal.LogicalRecord{
+				{Key: []byte(fmt.Sprintf("k%d", seq)), Type: wal.LogicalTypePut},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			seg.Append(rec)
+			seq++
+		}
+		seg.Close()
+	}
+	if err := os.WriteFile(filepath.Join(dir, "TRUNCATED"), []byte{3, 0, 0, 0, 0, 0, 0, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newUpdateIterator(dir, 3, 13); err != ErrWALTooOld {
+		t.Fatalf("expected ErrWALTooOld from a truncated seq, got %v", err)
+	}
+	for _, tc := range []struct {
+		since, first uint64
+		segments     int
+	}{
+		{4, 5, 3},   // Never logged: starts at the first batch.
+		{9, 9, 2},   // Skips segment 2 unread.
+		{11, 11, 1}, // The last segment.
+	} {
+		it, err := newUpdateIterator(dir, tc.since, 13)
+		if err != nil {
+			t.Fatalf("since %d: %v", tc.since, err)
+		}
+		if !it.Valid() || it.Batch().SeqStart != tc.first {
+			t.Fatalf("since %d: expected a batch at %d", tc.since, tc.first)
+		}
+		if n := len(it.segments) + 1; n != tc.segments {
+			t.Fatalf("since %d: read %d segments, want %d", tc.since, n, tc.segments)
+		}
+		it.Close()
+	}
+}
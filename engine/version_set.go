@@ -1,10 +1,11 @@
 package engine
 
 import (
-	"bytes"
 	"errors"
 	"sort"
 	"sync"
+
+	"vern_kv0.8/internal"
 )
 
 const NumLevels = 7
@@ -19,60 +20,190 @@ type SSTableMeta struct {
 	FileSize    int64
 }
 
-type VersionSet struct {
-	mu sync.RWMutex
+// BlobFileMeta describes a blob file and how much of it is still referenced.
+type BlobFileMeta struct {
+	FileNum      uint64
+	Count        uint64
+	Size         uint64
+	GarbageCount uint64
+	GarbageSize  uint64
+}
+
+// LiveRatio returns the fraction of the file's bytes still referenced.
+func (m BlobFileMeta) LiveRatio() float64 {
+	if m.Size == 0 {
+		return 0
+	}
+	return 1 - float64(m.GarbageSize)/float64(m.Size)
+}
+
+// BlobGarbage counts records of a blob file that lost their last reference.
+type BlobGarbage struct {
+	FileNum uint64
+	Count   uint64
+	Size    uint64
+}
 
+// Version is an immutable view of the table layout.
+// Readers pin a Version so its files outlive later compactions.
+type Version struct {
 	// Levels[0] overlaps; L1+ are sorted.
 	Levels [NumLevels][]SSTableMeta
 
+	// Blob files referenced by the tables, by file number.
+	Blobs map[uint64]BlobFileMeta
+
+	vset *VersionSet
+	refs int // Guarded by vset.mu.
+}
+
+// VersionEdit is an atomic change to the table layout.
+type VersionEdit struct {
+	Added   []SSTableMeta
+	Removed []uint64
+
+	AddedBlobs  []BlobFileMeta
+	BlobGarbage []BlobGarbage
+}
+
+type VersionSet struct {
+	mu sync.RWMutex
+
+	// Current version, installed copy-on-write. Its fields are promoted;
+	// code that reads without holding mu should pin it via Current.
+	*Version
+
+	// Versions with outstanding references, including the current one.
+	live map[*Version]struct{}
+
 	Obsolete     map[uint64]bool
 	WALCutoffSeq uint64
+
+	// ObsoleteBlobs holds blob files whose records are all garbage.
+	ObsoleteBlobs map[uint64]bool
+
+	cmp internal.Comparator // Orders table key ranges.
 }
 
+// NewVersionSet creates an empty VersionSet ordering user keys bytewise.
 func NewVersionSet() *VersionSet {
-	return &VersionSet{
-		Levels:   [NumLevels][]SSTableMeta{},
-		Obsolete: make(map[uint64]bool),
+	return newVersionSet(internal.Comparator{})
+}
+
+func newVersionSet(cmp internal.Comparator) *VersionSet {
+	v := &VersionSet{
+		live:          make(map[*Version]struct{}),
+		Obsolete:      make(map[uint64]bool),
+		ObsoleteBlobs: make(map[uint64]bool),
+		cmp:           cmp,
 	}
+	v.install(&Version{})
+	return v
 }
 
-func (v *VersionSet) AddTable(meta SSTableMeta) error {
+// Current pins and returns the current version. Callers must Unref it.
+func (v *VersionSet) Current() *Version {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	if meta.Level >= NumLevels {
-		return errors.New("invalid level")
-	}
+	v.Version.refs++
+	return v.Version
+}
 
-	v.Levels[meta.Level] = append(v.Levels[meta.Level], meta)
+// Unref releases a pin.
+// It reports whether the version is no longer referenced by anyone.
+func (ver *Version) Unref() bool {
+	ver.vset.mu.Lock()
+	defer ver.vset.mu.Unlock()
+	return ver.unrefLocked()
+}
 
-	// Sort L1+ by key.
-	if meta.Level > 0 {
-		sort.Slice(v.Levels[meta.Level], func(i, j int) bool {
-			return bytes.Compare(v.Levels[meta.Level][i].SmallestKey, v.Levels[meta.Level][j].SmallestKey) < 0
-		})
+func (ver *Version) unrefLocked() bool {
+	ver.refs--
+	if ver.refs == 0 {
+		delete(ver.vset.live, ver)
+		return true
 	}
+	return false
+}
 
-	return nil
+// install makes ver current. Caller holds mu.
+func (v *VersionSet) install(ver *Version) {
+	ver.vset = v
+	ver.refs++
+	v.live[ver] = struct{}{}
+
+	if old := v.Version; old != nil {
+		old.unrefLocked()
+	}
+	v.Version = ver
 }
 
-func (v *VersionSet) RemoveTable(fileNum uint64) {
+// Apply installs a new version with edit applied.
+// Removed files, and blob files left without live records, are marked obsolete.
+func (v *VersionSet) Apply(edit VersionEdit) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	v.Obsolete[fileNum] = true
+	for _, meta := range edit.Added {
+		if meta.Level >= NumLevels {
+			return errors.New("invalid level")
+		}
+	}
 
-	// Remove table from level.
-	for l := 0; l < NumLevels; l++ {
-		files := v.Levels[l]
-		for i, t := range files {
-			if t.FileNum == fileNum {
-				// Remove
-				v.Levels[l] = append(files[:i], files[i+1:]...)
-				return
-			}
+	next := v.Version.clone()
+	next.vset = v
+	for _, fileNum := range edit.Removed {
+		v.Obsolete[fileNum] = true
+		next.remove(fileNum)
+	}
+	for _, meta := range edit.Added {
+		next.add(meta)
+	}
+	for _, meta := range edit.AddedBlobs {
+		next.Blobs[meta.FileNum] = meta
+	}
+	for _, g := range edit.BlobGarbage {
+		meta, ok := next.Blobs[g.FileNum]
+		if !ok {
+			continue
+		}
+		meta.GarbageCount += g.Count
+		meta.GarbageSize += g.Size
+		if meta.GarbageCount >= meta.Count {
+			delete(next.Blobs, g.FileNum)
+			v.ObsoleteBlobs[g.FileNum] = true
+			continue
 		}
+		next.Blobs[g.FileNum] = meta
 	}
+
+	v.install(next)
+	return nil
+}
+
+func (v *VersionSet) AddTable(meta SSTableMeta) error {
+	return v.Apply(VersionEdit{Added: []SSTableMeta{meta}})
+}
+
+func (v *VersionSet) RemoveTable(fileNum uint64) {
+	v.Apply(VersionEdit{Removed: []uint64{fileNum}})
+}
+
+// dropEdit removes every table and blob file of the current version.
+func (v *VersionSet) dropEdit() VersionEdit {
+	var edit VersionEdit
+	for _, meta := range v.GetAllTables() {
+		edit.Removed = append(edit.Removed, meta.FileNum)
+	}
+	for _, meta := range v.BlobFiles() {
+		edit.BlobGarbage = append(edit.BlobGarbage, BlobGarbage{
+			FileNum: meta.FileNum,
+			Count:   meta.Count - meta.GarbageCount,
+			Size:    meta.Size - meta.GarbageSize,
+		})
+	}
+	return edit
 }
 
 func (v *VersionSet) SetWALCutoff(seq uint64) {
@@ -88,13 +219,141 @@ func (v *VersionSet) GetAllTables() []SSTableMeta {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
+	return v.Version.AllTables()
+}
+
+// LevelFiles returns a copy of one level's tables.
+func (v *VersionSet) LevelFiles(level int) []SSTableMeta {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	files := make([]SSTableMeta, len(v.Levels[level]))
+	copy(files, v.Levels[level])
+	return files
+}
+
+// DeletableFiles returns obsolete files that no live version references.
+func (v *VersionSet) DeletableFiles() []uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	inUse := make(map[uint64]bool)
+	for ver := range v.live {
+		for _, files := range ver.Levels {
+			for _, t := range files {
+				inUse[t.FileNum] = true
+			}
+		}
+	}
+
+	var deletable []uint64
+	for fileNum := range v.Obsolete {
+		if !inUse[fileNum] {
+			deletable = append(deletable, fileNum)
+		}
+	}
+	return deletable
+}
+
+// DeletableBlobFiles returns obsolete blob files that no live version references.
+func (v *VersionSet) DeletableBlobFiles() []uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var deletable []uint64
+	for fileNum := range v.ObsoleteBlobs {
+		inUse := false
+		for ver := range v.live {
+			if _, ok := ver.Blobs[fileNum]; ok {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			deletable = append(deletable, fileNum)
+		}
+	}
+	return deletable
+}
+
+// ForgetObsolete drops a deleted file from the obsolete sets.
+func (v *VersionSet) ForgetObsolete(fileNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.Obsolete, fileNum)
+	delete(v.ObsoleteBlobs, fileNum)
+}
+
+// BlobFiles returns the blob files of the current version, by file number.
+func (v *VersionSet) BlobFiles() []BlobFileMeta {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	files := make([]BlobFileMeta, 0, len(v.Blobs))
+	for _, meta := range v.Blobs {
+		files = append(files, meta)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileNum < files[j].FileNum
+	})
+	return files
+}
+
+// AllTables returns every table in the version.
+func (ver *Version) AllTables() []SSTableMeta {
 	var all []SSTableMeta
-	for _, files := range v.Levels {
+	for _, files := range ver.Levels {
 		all = append(all, files...)
 	}
 	return all
 }
 
+func (ver *Version) clone() *Version {
+	next := &Version{Blobs: make(map[uint64]BlobFileMeta, len(ver.Blobs))}
+	for l, files := range ver.Levels {
+		next.Levels[l] = append([]SSTableMeta(nil), files...)
+	}
+	for fileNum, meta := range ver.Blobs {
+		next.Blobs[fileNum] = meta
+	}
+	return next
+}
+
+func (ver *Version) add(meta SSTableMeta) {
+	ver.Levels[meta.Level] = append(ver.Levels[meta.Level], meta)
+
+	// Sort L1+ by key.
+	if meta.Level > 0 {
+		files := ver.Levels[meta.Level]
+		cmp := ver.vset.cmp
+		sort.Slice(files, func(i, j int) bool {
+			return cmp.Compare(files[i].SmallestKey, files[j].SmallestKey) < 0
+		})
+	}
+}
+
+func (ver *Version) remove(fileNum uint64) {
+	// Remove table from level.
+	for l := 0; l < NumLevels; l++ {
+		files := ver.Levels[l]
+		for i, t := range files {
+			if t.FileNum == fileNum {
+				ver.Levels[l] = append(files[:i], files[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// containsUserKey reports whether key falls within the table's key range.
+func (m SSTableMeta) containsUserKey(cmp internal.Comparator, key []byte) bool {
+	if len(m.SmallestKey) == 0 || len(m.LargestKey) == 0 {
+		return true
+	}
+	return cmp.CompareUser(key, internal.ExtractUserKey(m.SmallestKey)) >= 0 &&
+		cmp.CompareUser(key, internal.ExtractUserKey(m.LargestKey)) <= 0
+}
+
 // PickCompaction identifies level needing compaction.
 func (v *VersionSet) PickCompaction(l0Trigger int, l1MaxBytes int64) (int, bool) {
 	v.mu.RLock()
@@ -140,10 +399,19 @@ func (v *VersionSet) GetOverlappingInputs(level int, start, end []byte) []SSTabl
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	var inputs []SSTableMeta
-	for _, t := range v.Levels[level] {
+	return v.Version.OverlappingInputs(level, start, end)
+}
+
+// OverlappingInputs returns the level's tables whose user keys intersect those
+// of the internal key range [start, end].
+func (ver *Version) OverlappingInputs(level int, start, end []byte) []SSTableMeta {
+	cmp := ver.vset.cmp
+	ustart, uend := internal.ExtractUserKey(start), internal.ExtractUserKey(end)
 
-		if bytes.Compare(t.LargestKey, start) < 0 || bytes.Compare(t.SmallestKey, end) > 0 {
+	var inputs []SSTableMeta
+	for _, t := range ver.Levels[level] {
+		if cmp.CompareUser(internal.ExtractUserKey(t.LargestKey), ustart) < 0 ||
+			cmp.CompareUser(internal.ExtractUserKey(t.SmallestKey), uend) > 0 {
 			continue // No overlap.
 		}
 		inputs = append(inputs, t)
@@ -4,9 +4,15 @@ import (
 	"path/filepath"
 	"testing"
 
+	"vern_kv0.8/internal"
 	"vern_kv0.8/manifest"
 )
 
+// tableKey is the internal key of a table bound.
+func tableKey(userKey string) []byte {
+	return internal.EncodeInternalKey([]byte(userKey), 1, internal.RecordTypeValue)
+}
+
 func TestVersionSetReplay(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "MANIFEST")
@@ -66,20 +72,20 @@ func TestVersionSet_AddTable(t *testing.T) {
 	t1 := SSTableMeta{
 		FileNum:     1,
 		Level:       1,
-		SmallestKey: []byte("c"),
-		LargestKey:  []byte("d"),
+		SmallestKey: tableKey("c"),
+		LargestKey:  tableKey("d"),
 	}
 	t2 := SSTableMeta{
 		FileNum:     2,
 		Level:       1,
-		SmallestKey: []byte("a"),
-		LargestKey:  []byte("b"),
+		SmallestKey: tableKey("a"),
+		LargestKey:  tableKey("b"),
 	}
 	t3 := SSTableMeta{
 		FileNum:     3,
 		Level:       1,
-		SmallestKey: []byte("e"),
-		LargestKey:  []byte("f"),
+		SmallestKey: tableKey("e"),
+		LargestKey:  tableKey("f"),
 	}
 
 	if err := vs.AddTable(t1); err != nil {
@@ -97,13 +103,13 @@ func TestVersionSet_AddTable(t *testing.T) {
 	if len(files) != 3 {
 		t.Fatalf("expected 3 files in L1, got %d", len(files))
 	}
-	if string(files[0].SmallestKey) != "a" {
+	if string(internal.ExtractUserKey(files[0].SmallestKey)) != "a" {
 		t.Errorf("expected first key 'a', got %s", files[0].SmallestKey)
 	}
-	if string(files[1].SmallestKey) != "c" {
+	if string(internal.ExtractUserKey(files[1].SmallestKey)) != "c" {
 		t.Errorf("expected second key 'c', got %s", files[1].SmallestKey)
 	}
-	if string(files[2].SmallestKey) != "e" {
+	if string(internal.ExtractUserKey(files[2].SmallestKey)) != "e" {
 		t.Errorf("expected third key 'e', got %s", files[2].SmallestKey)
 	}
 }
@@ -166,12 +172,12 @@ func TestVersionSet_GetOverlappingInputs(t *testing.T) {
 	vs := NewVersionSet()
 
 	// L1: [a, b], [d, e], [g, h]
-	vs.AddTable(SSTableMeta{FileNum: 1, Level: 1, SmallestKey: []byte("a"), LargestKey: []byte("b")})
-	vs.AddTable(SSTableMeta{FileNum: 2, Level: 1, SmallestKey: []byte("d"), LargestKey: []byte("e")})
-	vs.AddTable(SSTableMeta{FileNum: 3, Level: 1, SmallestKey: []byte("g"), LargestKey: []byte("h")})
+	vs.AddTable(SSTableMeta{FileNum: 1, Level: 1, SmallestKey: tableKey("a"), LargestKey: tableKey("b")})
+	vs.AddTable(SSTableMeta{FileNum: 2, Level: 1, SmallestKey: tableKey("d"), LargestKey: tableKey("e")})
+	vs.AddTable(SSTableMeta{FileNum: 3, Level: 1, SmallestKey: tableKey("g"), LargestKey: tableKey("h")})
 
 	// Range [b, d] overlaps with tables 1 and 2.
-	inputs := vs.GetOverlappingInputs(1, []byte("b"), []byte("d"))
+	inputs := vs.GetOverlappingInputs(1, tableKey("b"), tableKey("d"))
 
 	if len(inputs) != 2 {
 		t.Fatalf("expected 2 overlapping inputs, got %d", len(inputs))
@@ -184,8 +190,30 @@ func TestVersionSet_GetOverlappingInputs(t *testing.T) {
 	}
 
 	// Range [e, f] overlaps with table 2.
-	inputs = vs.GetOverlappingInputs(1, []byte("e"), []byte("f"))
+	inputs = vs.GetOverlappingInputs(1, tableKey("e"), tableKey("f"))
 	if len(inputs) != 1 {
 		t.Fatalf("expected 1 overlapping input, got %d", len(inputs))
 	}
 }
+
+func TestVersionSet_PinnedFilesNotDeletable(t *testing.T) {
+	vs := NewVersionSet()
+	vs.AddTable(SSTableMeta{FileNum: 1, Level: 0})
+
+	pinned := vs.Current()
+	vs.RemoveTable(1)
+
+	if len(vs.DeletableFiles()) != 0 {
+		t.Fatalf("file referenced by a pinned version must not be deletable")
+	}
+	if len(pinned.Levels[0]) != 1 {
+		t.Fatalf("pinned version must be unaffected by later edits")
+	}
+
+	if !pinned.Unref() {
+		t.Fatalf("expected last reference to be dropped")
+	}
+	if files := vs.DeletableFiles(); len(files) != 1 || files[0] != 1 {
+		t.Fatalf("expected file 1 deletable after unpin, got %v", files)
+	}
+}
@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"vern_kv0.8/internal"
+	"vern_kv0.8/wal"
+)
+
+// Write size limits. Every record of a batch lands in one WAL record,
+// so a batch must fit comfortably inside a WAL segment.
+const (
+	MaxKeySize   = 64 * 1024        // 64KB
+	MaxValueSize = 16 * 1024 * 1024 // 16MB
+	MaxBatchSize = 32 * 1024 * 1024 // 32MB
+)
+
+var (
+	ErrEmptyKey      = errors.New("empty key")
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrBatchTooLarge = errors.New("write batch too large")
+	ErrCorruptBatch  = errors.New("corrupt write batch")
+)
+
+// writeBatchHeaderSize is the record count prefix of WriteBatch.Data.
+const writeBatchHeaderSize = 4
+
+// WriteBatch collects updates that DB.Write applies atomically.
+// The zero value is an empty batch. A WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	records []wal.LogicalRecord
+	size    int // Encoded size excluding the header.
+}
+
+// NewWriteBatch returns an empty batch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put sets key in the default column family.
+func (b *WriteBatch) Put(key, value []byte) {
+	b.PutCF(nil, key, value)
+}
+
+// PutCF sets key in the column family h.
+func (b *WriteBatch) PutCF(h *ColumnFamilyHandle, key, value []byte) {
+	b.add(h, wal.LogicalTypePut, key, value)
+}
+
+// PutWithExpiry sets key in the default column family until expiry.
+// See DB.PutWithTTL.
+func (b *WriteBatch) PutWithExpiry(key, value []byte, expiry time.Time) {
+	b.PutWithExpiryCF(nil, key, value, expiry)
+}
+
+// PutWithExpiryCF sets key in the column family h until expiry.
+func (b *WriteBatch) PutWithExpiryCF(h *ColumnFamilyHandle, key, value []byte, expiry time.Time) {
+	b.add(h, wal.LogicalTypePutTTL, key, internal.EncodeExpiringValue(expiry.UnixNano(), value))
+}
+
+// Delete deletes key from the default column family.
+func (b *WriteBatch) Delete(key []byte) {
+	b.DeleteCF(nil, key)
+}
+
+// DeleteCF deletes key from the column family h.
+func (b *WriteBatch) DeleteCF(h *ColumnFamilyHandle, key []byte) {
+	b.add(h, wal.LogicalTypeDelete, key, nil)
+}
+
+// DeleteRange deletes [start, end) from the default column family.
+func (b *WriteBatch) DeleteRange(start, end []byte) {
+	b.DeleteRangeCF(nil, start, end)
+}
+
+// DeleteRangeCF deletes [start, end) from the column family h.
+func (b *WriteBatch) DeleteRangeCF(h *ColumnFamilyHandle, start, end []byte) {
+	b.add(h, wal.LogicalTypeDeleteRange, start, end)
+}
+
+// Merge records operand against key in the default column family.
+func (b *WriteBatch) Merge(key, operand []byte) {
+	b.MergeCF(nil, key, operand)
+}
+
+// MergeCF records operand against key in the column family h.
+func (b *WriteBatch) MergeCF(h *ColumnFamilyHandle, key, operand []byte) {
+	b.add(h, wal.LogicalTypeMerge, key, operand)
+}
+
+func (b *WriteBatch) add(h *ColumnFamilyHandle, typ uint8, key, value []byte) {
+	r := wal.LogicalRecord{
+		Key:  append([]byte(nil), key...),
+		Type: typ,
+	}
+	if typ != wal.LogicalTypeDelete {
+		r.Value = append([]byte(nil), value...)
+	}
+	if h != nil {
+		r.ColumnFamily = h.ID()
+	}
+	b.records = append(b.records, r)
+	b.size += encodedRecordSize(r)
+}
+
+// Clear empties the batch.
+func (b *WriteBatch) Clear() {
+	b.records = nil
+	b.size = 0
+}
+
+// Count returns the number of updates in the batch.
+func (b *WriteBatch) Count() int {
+	return len(b.records)
+}
+
+// ApproximateSize returns the size of the batch's serialized form in bytes.
+func (b *WriteBatch) ApproximateSize() int {
+	return writeBatchHeaderSize + b.size
+}
+
+// Data serializes the batch. FromData restores it.
+//
+//	[count uint32]
+//	repeated: [type uint8][column family uint32][key len uvarint][key][value len uvarint][value]
+func (b *WriteBatch) Data() []byte {
+	buf := make([]byte, writeBatchHeaderSize, b.ApproximateSize())
+	binary.LittleEndian.PutUint32(buf, uint32(len(b.records)))
+	for _, r := range b.records {
+		buf = append(buf, r.Type)
+		buf = binary.LittleEndian.AppendUint32(buf, r.ColumnFamily)
+		buf = binary.AppendUvarint(buf, uint64(len(r.Key)))
+		buf = append(buf, r.Key...)
+		buf = binary.AppendUvarint(buf, uint64(len(r.Value)))
+		buf = append(buf, r.Value...)
+	}
+	return buf
+}
+
+// FromData replaces the batch's contents with a batch serialized by Data.
+// On error the batch is left unchanged.
+func (b *WriteBatch) FromData(data []byte) error {
+	if len(data) < writeBatchHeaderSize {
+		return ErrCorruptBatch
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[writeBatchHeaderSize:]
+
+	readBytes := func() ([]byte, bool) {
+		n, w := binary.Uvarint(data)
+		if w <= 0 || uint64(len(data)-w) < n {
+			return nil, false
+		}
+		out := append([]byte(nil), data[w:w+int(n)]...)
+		data = data[w+int(n):]
+		return out, true
+	}
+
+	var decoded WriteBatch
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 5 {
+			return ErrCorruptBatch
+		}
+		r := wal.LogicalRecord{
+			Type:         data[0],
+			ColumnFamily: binary.LittleEndian.Uint32(data[1:]),
+		}
+		data = data[5:]
+
+		var ok bool
+		if r.Key, ok = readBytes(); !ok {
+			return ErrCorruptBatch
+		}
+		if r.Value, ok = readBytes(); !ok {
+			return ErrCorruptBatch
+		}
+		switch r.Type {
+		case wal.LogicalTypePut, wal.LogicalTypeDelete, wal.LogicalTypeMerge, wal.LogicalTypeDeleteRange,
+			wal.LogicalTypePutTTL:
+		default:
+			return ErrCorruptBatch
+		}
+		if r.Type == wal.LogicalTypeDelete {
+			r.Value = nil
+		}
+		decoded.records = append(decoded.records, r)
+		decoded.size += encodedRecordSize(r)
+	}
+	if len(data) != 0 {
+		return ErrCorruptBatch
+	}
+
+	*b = decoded
+	return nil
+}
+
+// WriteBatchHandler receives the updates of a batch from WriteBatch.Iterate.
+// columnFamily is the target family's ID; 0 is the default family.
+type WriteBatchHandler interface {
+	Put(columnFamily uint32, key, value []byte) error
+	PutWithTTL(columnFamily uint32, key, value []byte, expiry time.Time) error
+	Delete(columnFamily uint32, key []byte) error
+	DeleteRange(columnFamily uint32, start, end []byte) error
+	Merge(columnFamily uint32, key, operand []byte) error
+}
+
+// Iterate calls handler for each update in insertion order,
+// stopping at the first error. A record it cannot decode fails with
+// ErrCorruptBatch.
+func (b *WriteBatch) Iterate(handler WriteBatchHandler) error {
+	for _, r := range b.records {
+		var err error
+		switch r.Type {
+		case wal.LogicalTypePut:
+			err = handler.Put(r.ColumnFamily, r.Key, r.Value)
+		case wal.LogicalTypePutTTL:
+			expiry, value, ok := internal.DecodeExpiringValue(r.Value)
+			if !ok {
+				return ErrCorruptBatch
+			}
+			err = handler.PutWithTTL(r.ColumnFamily, r.Key, value, time.Unix(0, expiry))
+		case wal.LogicalTypeDelete:
+			err = handler.Delete(r.ColumnFamily, r.Key)
+		case wal.LogicalTypeDeleteRange:
+			err = handler.DeleteRange(r.ColumnFamily, r.Key, r.Value)
+		case wal.LogicalTypeMerge:
+			err = handler.Merge(r.ColumnFamily, r.Key, r.Value)
+		default:
+			return ErrCorruptBatch
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodedRecordSize(r wal.LogicalRecord) int {
+	return 1 + 4 +
+		uvarintLen(uint64(len(r.Key))) + len(r.Key) +
+		uvarintLen(uint64(len(r.Value))) + len(r.Value)
+}
+
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
+
+// validateRecords checks a batch before it is logged.
+// Range bounds are ordered by the comparator cmp returns for their family.
+func validateRecords(cmp func(id uint32) internal.Comparator, records []wal.LogicalRecord) error {
+	size := 0
+	for _, r := range records {
+		if len(r.Key) == 0 {
+			return ErrEmptyKey
+		}
+		if len(r.Key) > MaxKeySize {
+			return ErrKeyTooLarge
+		}
+		if len(r.Value) > MaxValueSize {
+			return ErrValueTooLarge
+		}
+		size += encodedRecordSize(r)
+		if size > MaxBatchSize {
+			return ErrBatchTooLarge
+		}
+
+		switch r.Type {
+		case wal.LogicalTypePut, wal.LogicalTypeDelete, wal.LogicalTypeMerge:
+		case wal.LogicalTypePutTTL:
+			if _, _, ok := internal.DecodeExpiringValue(r.Value); !ok {
+				return ErrCorruptBatch
+			}
+		case wal.LogicalTypeDeleteRange:
+			if len(r.Value) > MaxKeySize {
+				return ErrKeyTooLarge
+			}
+			if cmp(r.ColumnFamily).CompareUser(r.Key, r.Value) > 0 {
+				return ErrInvalidRange
+			}
+		default:
+			return fmt.Errorf("unknown record type %d", r.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingHandler renders each update of a batch as a string.
+type recordingHandler struct {
+	ops []string
+}
+
+func (h *recordingHandler) Put(cf uint32, key, value []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("put(%d,%s,%s)", cf, key, value))
+	return nil
+}
+
+func (h *recordingHandler) PutWithTTL(cf uint32, key, value []byte, expiry time.Time) error {
+	h.ops = append(h.ops, fmt.Sprintf("putttl(%d,%s,%s,%d)", cf, key, value, expiry.Unix()))
+	return nil
+}
+
+func (h *recordingHandler) Delete(cf uint32, key []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("del(%d,%s)", cf, key))
+	return nil
+}
+
+func (h *recordingHandler) DeleteRange(cf uint32, start, end []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("delrange(%d,%s,%s)", cf, start, end))
+	return nil
+}
+
+func (h *recordingHandler) Merge(cf uint32, key, operand []byte) error {
+	h.ops = append(h.ops, fmt.Sprintf("merge(%d,%s,%s)", cf, key, operand))
+	return nil
+}
+
+func TestWriteBatchDataRoundTrip(t *testing.T) {
+	cf := &ColumnFamilyHandle{cf: &columnFamily{id: 7}}
+
+	b := NewWriteBatch()
+	b.Put([]byte("a"), []byte("1"))
+	b.DeleteCF(cf, []byte("b"))
+	b.DeleteRange([]byte("c"), []byte("e"))
+	b.MergeCF(cf, []byte("f"), []byte("+1"))
+	b.PutWithExpiryCF(cf, []byte("g"), []byte("2"), time.Unix(100, 0))
+
+	if b.Count() != 5 {
+		t.Fatalf("expected 5 updates, got %d", b.Count())
+	}
+	data := b.Data()
+	if b.ApproximateSize() != len(data) {
+		t.Fatalf("ApproximateSize %d, encoded %d", b.ApproximateSize(), len(data))
+	}
+
+	var restored WriteBatch
+	if err := restored.FromData(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored.Data(), data) {
+		t.Fatal("round trip changed the encoding")
+	}
+
+	var h recordingHandler
+	if err := restored.Iterate(&h); err != nil {
+		t.Fatal(err)
+	}
+	want := "put(0,a,1) del(7,b) delrange(0,c,e) merge(7,f,+1) putttl(7,g,2,100)"
+	if got := strings.Join(h.ops, " "); got != want {
+		t.Fatalf("Iterate: got %s, want %s", got, want)
+	}
+
+	// Truncations and trailing garbage are rejected without touching the batch.
+	for _, bad := range [][]byte{data[:3], data[:len(data)-1], append(append([]byte(nil), data...), 0)} {
+		if err := restored.FromData(bad); err != ErrCorruptBatch {
+			t.Fatalf("expected ErrCorruptBatch, got %v", err)
+		}
+	}
+	if restored.Count() != 5 {
+		t.Fatal("failed FromData modified the batch")
+	}
+
+	b.Clear()
+	if b.Count() != 0 || b.ApproximateSize() != len(b.Data()) {
+		t.Fatal("Clear left data behind")
+	}
+}
+
+func TestWriteBatchValidation(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		name string
+		fill func(b *WriteBatch)
+		want error
+	}{
+		{"empty key", func(b *WriteBatch) { b.Put(nil, []byte("v")) }, ErrEmptyKey},
+		{"large key", func(b *WriteBatch) { b.Put(make([]byte, MaxKeySize+1), nil) }, ErrKeyTooLarge},
+		{"large value", func(b *WriteBatch) { b.Put([]byte("k"), make([]byte, MaxValueSize+1)) }, ErrValueTooLarge},
+		{"large batch", func(b *WriteBatch) {
+			for i := 0; i <= MaxBatchSize/MaxValueSize; i++ {
+				b.Put([]byte(fmt.Sprint(i)), make([]byte, MaxValueSize))
+			}
+		}, ErrBatchTooLarge},
+		{"inverted range", func(b *WriteBatch) { b.DeleteRange([]byte("z"), []byte("a")) }, ErrInvalidRange},
+		{"merge without operator", func(b *WriteBatch) { b.Merge([]byte("k"), []byte("1")) }, ErrNoMergeOperator},
+	}
+	for _, c := range cases {
+		b := NewWriteBatch()
+		b.Put([]byte("first"), []byte("v"))
+		c.fill(b)
+		if err := db.Write(b); err != c.want {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+		if _, err := db.Get([]byte("first")); err != ErrNotFound {
+			t.Fatalf("%s: invalid batch was partially applied", c.name)
+		}
+	}
+
+	seq := db.nextSeq
+	if err := db.Write(NewWriteBatch()); err != nil {
+		t.Fatal(err)
+	}
+	if db.nextSeq != seq {
+		t.Fatal("empty batch consumed a sequence number")
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisableWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlogged := &WriteOptions{DisableWAL: true}
+	if err := db.PutWithOptions([]byte("flushed"), []byte("v"), unlogged); err != nil {
+		t.Fatal(err)
+	}
+	db.freezeMemtable()
+
+	db.Put([]byte("logged-1"), []byte("v"))
+	db.PutWithOptions([]byte("lost"), []byte("v"), unlogged)
+	b := NewWriteBatch()
+	b.Put([]byte("lost-batch"), []byte("v"))
+	db.WriteWithOptions(b, unlogged)
+	db.PutWithOptions([]byte("logged-2"), []byte("v"), &WriteOptions{Sync: true})
+	lastSeq := db.nextSeq - 1
+
+	// Close does not flush, so unlogged writes still in the memtable are lost.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, k := range []string{"flushed", "logged-1", "logged-2"} {
+		if _, err := db.Get([]byte(k)); err != nil {
+			t.Fatalf("Get(%s): %v", k, err)
+		}
+	}
+	for _, k := range []string{"lost", "lost-batch"} {
+		if _, err := db.Get([]byte(k)); err != ErrNotFound {
+			t.Fatalf("Get(%s): expected ErrNotFound, got %v", k, err)
+		}
+	}
+
+	// Sequence numbering resumes after the last logged write despite the gap.
+	if db.nextSeq != lastSeq+1 {
+		t.Fatalf("expected next sequence %d, got %d", lastSeq+1, db.nextSeq)
+	}
+	if err := db.Put([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoSlowdownWriteStall(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxImmutableMemtables = 1
+	db, err := Open(t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Hold up flushes so the immutable memtable stays queued.
+	db.flushMu.Lock()
+	db.Put([]byte("a"), []byte("v"))
+	rotateFamily(db, db.DefaultColumnFamily())
+
+	if err := db.PutWithOptions([]byte("b"), []byte("v"), &WriteOptions{NoSlowdown: true}); err != ErrWriteStall {
+		t.Fatalf("expected ErrWriteStall, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- db.Put([]byte("b"), []byte("v")) }()
+	select {
+	case err := <-done:
+		t.Fatalf("stalled write returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once the flush drains the backlog the waiting write proceeds.
+	db.flushMu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled write never resumed")
+	}
+	if _, err := db.Get([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+}
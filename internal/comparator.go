@@ -2,16 +2,88 @@ package internal
 
 import "bytes"
 
-type Comparator struct{}
+// UserComparator orders user keys.
+type UserComparator interface {
+	Compare(a, b []byte) int
 
-// Comparator sorts keys in ascending order and sequences in descending order.
-func (Comparator) Compare(a, b []byte) int {
+	// Name identifies the ordering. Data written under one name cannot be
+	// read under another.
+	Name() string
+
+	// FindShortestSeparator returns a short key k with start <= k < limit.
+	// Returning start unchanged is always correct.
+	FindShortestSeparator(start, limit []byte) []byte
+
+	// FindShortSuccessor returns a short key k >= key.
+	// Returning key unchanged is always correct.
+	FindShortSuccessor(key []byte) []byte
+}
+
+// BytewiseComparator orders user keys lexicographically by byte.
+var BytewiseComparator UserComparator = bytewiseComparator{}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "vern.BytewiseComparator"
+}
+
+func (bytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	// Skip the common prefix.
+	n := min(len(start), len(limit))
+	i := 0
+	for i < n && start[i] == limit[i] {
+		i++
+	}
+	if i >= n {
+		// One is a prefix of the other.
+		return start
+	}
+	if c := start[i]; c < 0xFF && c+1 < limit[i] {
+		sep := append([]byte(nil), start[:i+1]...)
+		sep[i]++
+		return sep
+	}
+	return start
+}
+
+func (bytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	// Bump the first byte that can be bumped and cut the rest.
+	for i, c := range key {
+		if c != 0xFF {
+			succ := append([]byte(nil), key[:i+1]...)
+			succ[i]++
+			return succ
+		}
+	}
+	return key
+}
+
+// Comparator orders internal keys: user keys ascending by User, then
+// sequences descending. The zero value orders user keys bytewise.
+type Comparator struct {
+	User UserComparator
+}
+
+func (c Comparator) user() UserComparator {
+	if c.User == nil {
+		return BytewiseComparator
+	}
+	return c.User
+}
+
+// Compare sorts keys in ascending order and sequences in descending order.
+func (c Comparator) Compare(a, b []byte) int {
 	// Compare user keys.
 	ua := ExtractUserKey(a)
 	ub := ExtractUserKey(b)
 
-	if c := bytes.Compare(ua, ub); c != 0 {
-		return c
+	if r := c.user().Compare(ua, ub); r != 0 {
+		return r
 	}
 
 	// Same user key: compare sequence numbers (descending).
@@ -35,3 +107,35 @@ func (Comparator) Compare(a, b []byte) int {
 
 	return 0
 }
+
+// CompareUser compares two user keys.
+func (c Comparator) CompareUser(a, b []byte) int {
+	return c.user().Compare(a, b)
+}
+
+// Name returns the user comparator's name.
+func (c Comparator) Name() string {
+	return c.user().Name()
+}
+
+// FindShortestSeparator returns a short internal key k with start <= k < limit.
+// A shortened user key takes the largest trailer, so k sorts before every
+// real version of it.
+func (c Comparator) FindShortestSeparator(start, limit []byte) []byte {
+	ustart := ExtractUserKey(start)
+	sep := c.user().FindShortestSeparator(ustart, ExtractUserKey(limit))
+	if len(sep) < len(ustart) && c.user().Compare(ustart, sep) < 0 {
+		return SeekKey(sep, MaxSequenceNumber)
+	}
+	return start
+}
+
+// FindShortSuccessor returns a short internal key k >= key.
+func (c Comparator) FindShortSuccessor(key []byte) []byte {
+	ukey := ExtractUserKey(key)
+	succ := c.user().FindShortSuccessor(ukey)
+	if len(succ) < len(ukey) && c.user().Compare(ukey, succ) < 0 {
+		return SeekKey(succ, MaxSequenceNumber)
+	}
+	return key
+}
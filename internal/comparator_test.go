@@ -0,0 +1,52 @@
+package internal
+
+import "testing"
+
+func TestBytewiseSeparators(t *testing.T) {
+	cases := []struct {
+		start, limit, want string
+	}{
+		{"abcdef", "abzz", "abd"},
+		{"abc", "abd", "abc"},   // No room between them.
+		{"ab", "abcd", "ab"},    // Prefix.
+		{"a\xff", "b", "a\xff"}, // Cannot bump 0xFF.
+	}
+	for _, c := range cases {
+		got := BytewiseComparator.FindShortestSeparator([]byte(c.start), []byte(c.limit))
+		if string(got) != c.want {
+			t.Fatalf("FindShortestSeparator(%q, %q) = %q, want %q", c.start, c.limit, got, c.want)
+		}
+	}
+
+	if got := BytewiseComparator.FindShortSuccessor([]byte("\xffabc")); string(got) != "\xffb" {
+		t.Fatalf("FindShortSuccessor = %q", got)
+	}
+	if got := BytewiseComparator.FindShortSuccessor([]byte("\xff\xff")); string(got) != "\xff\xff" {
+		t.Fatalf("FindShortSuccessor of all 0xFF = %q", got)
+	}
+}
+
+func TestInternalSeparators(t *testing.T) {
+	cmp := Comparator{}
+
+	start := EncodeInternalKey([]byte("apple"), 5, RecordTypeValue)
+	limit := EncodeInternalKey([]byte("cherry"), 9, RecordTypeValue)
+	sep := cmp.FindShortestSeparator(start, limit)
+	if string(ExtractUserKey(sep)) != "b" {
+		t.Fatalf("expected user key b, got %q", ExtractUserKey(sep))
+	}
+	if cmp.Compare(start, sep) > 0 || cmp.Compare(sep, limit) >= 0 {
+		t.Fatal("separator out of range")
+	}
+
+	// Versions of one user key cannot be separated.
+	older := EncodeInternalKey([]byte("apple"), 3, RecordTypeValue)
+	if sep := cmp.FindShortestSeparator(start, older); string(sep) != string(start) {
+		t.Fatalf("expected start unchanged, got %q", sep)
+	}
+
+	succ := cmp.FindShortSuccessor(start)
+	if string(ExtractUserKey(succ)) != "b" || cmp.Compare(start, succ) > 0 {
+		t.Fatalf("bad successor %q", succ)
+	}
+}
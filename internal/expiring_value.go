@@ -0,0 +1,22 @@
+package internal
+
+import "encoding/binary"
+
+// expiryPrefixSize is the length of the expiry prefixed to an expiring value.
+const expiryPrefixSize = 8
+
+// EncodeExpiringValue prefixes value with its expiry, in Unix nanoseconds.
+func EncodeExpiringValue(expiry int64, value []byte) []byte {
+	buf := make([]byte, expiryPrefixSize, expiryPrefixSize+len(value))
+	binary.LittleEndian.PutUint64(buf, uint64(expiry))
+	return append(buf, value...)
+}
+
+// DecodeExpiringValue splits an expiring value into its expiry and payload.
+// It returns false if b is too short to hold an expiry.
+func DecodeExpiringValue(b []byte) (expiry int64, value []byte, ok bool) {
+	if len(b) < expiryPrefixSize {
+		return 0, nil, false
+	}
+	return int64(binary.LittleEndian.Uint64(b)), b[expiryPrefixSize:], true
+}
@@ -11,8 +11,21 @@ type RecordType uint8
 const (
 	RecordTypeValue     RecordType = 0x01
 	RecordTypeTombstone RecordType = 0x02
+	RecordTypeMerge     RecordType = 0x03
+
+	// RecordTypeRangeDelete keys carry a range's start; the value holds its end.
+	RecordTypeRangeDelete RecordType = 0x04
+
+	// RecordTypeExpiringValue values are prefixed with their expiry; see EncodeExpiringValue.
+	RecordTypeExpiringValue RecordType = 0x05
+
+	// RecordTypeBlobIndex values locate the real value in a blob file.
+	RecordTypeBlobIndex RecordType = 0x06
 )
 
+// MaxSequenceNumber is the largest sequence number an internal key can hold.
+const MaxSequenceNumber uint64 = (1 << 56) - 1
+
 // InternalKey comprises of user key, sequence number, and record type.
 type InternalKey struct {
 	UserKey []byte
@@ -47,7 +60,7 @@ func DecodeInternalKey(b []byte) (InternalKey, error) {
 	seq := trailer >> 8
 	typ := RecordType(trailer & 0xFF)
 
-	if typ != RecordTypeValue && typ != RecordTypeTombstone {
+	if typ < RecordTypeValue || typ > RecordTypeBlobIndex {
 		return InternalKey{}, errors.New("invalid record type")
 	}
 
@@ -76,3 +89,15 @@ func ExtractTrailer(b []byte) (uint64, RecordType, error) {
 
 	return seq, typ, nil
 }
+
+// SeekKey returns the smallest internal key for userKey visible at seq.
+// Seeking to it positions an iterator on the newest version <= seq.
+func SeekKey(userKey []byte, seq uint64) []byte {
+	return EncodeInternalKey(userKey, seq, RecordTypeValue)
+}
+
+// SeekKeyForPrev returns the largest internal key for userKey.
+// Seeking backwards from it includes every version of userKey.
+func SeekKeyForPrev(userKey []byte) []byte {
+	return EncodeInternalKey(userKey, 0, RecordType(0xFF))
+}
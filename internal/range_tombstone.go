@@ -0,0 +1,43 @@
+package internal
+
+// RangeTombstone deletes every user key in [Start, End) written before Seq.
+type RangeTombstone struct {
+	Start []byte
+	End   []byte
+	Seq   uint64
+}
+
+// DecodeRangeTombstone rebuilds a tombstone from its internal key and value.
+func DecodeRangeTombstone(key, value []byte) RangeTombstone {
+	seq, _, _ := ExtractTrailer(key)
+	return RangeTombstone{
+		Start: ExtractUserKey(key),
+		End:   value,
+		Seq:   seq,
+	}
+}
+
+// Encode returns the tombstone's internal key and value.
+func (t RangeTombstone) Encode() (key, value []byte) {
+	return EncodeInternalKey(t.Start, t.Seq, RecordTypeRangeDelete), t.End
+}
+
+// Contains reports whether userKey falls in [Start, End) under cmp.
+func (t RangeTombstone) Contains(cmp Comparator, userKey []byte) bool {
+	return cmp.CompareUser(userKey, t.Start) >= 0 && cmp.CompareUser(userKey, t.End) < 0
+}
+
+// Covers reports whether the tombstone deletes userKey at seq.
+func (t RangeTombstone) Covers(cmp Comparator, userKey []byte, seq uint64) bool {
+	return seq < t.Seq && t.Contains(cmp, userKey)
+}
+
+// SmallestKey is the smallest internal key the tombstone spans.
+func (t RangeTombstone) SmallestKey() []byte {
+	return EncodeInternalKey(t.Start, t.Seq, RecordTypeRangeDelete)
+}
+
+// LargestKey is an exclusive sentinel for End: it sorts before every real version of End.
+func (t RangeTombstone) LargestKey() []byte {
+	return EncodeInternalKey(t.End, MaxSequenceNumber, RecordTypeRangeDelete)
+}
@@ -1,9 +1,14 @@
 package iterators
 
 // InternalIterator interface.
+// Seek positions at the first key >= target; SeekForPrev at the last key <= target.
 type InternalIterator interface {
 	SeekToFirst()
+	SeekToLast()
+	Seek(target []byte)
+	SeekForPrev(target []byte)
 	Next()
+	Prev()
 	Valid() bool
 	Key() []byte
 	Value() []byte
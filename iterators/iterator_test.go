@@ -151,3 +151,64 @@ func TestVersionFilterHidesFutureWrites(t *testing.T) {
 		t.Fatalf("expected old value")
 	}
 }
+
+func TestMergeIteratorReverseDedup(t *testing.T) {
+	older := memtable.New()
+	older.Insert(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	older.Insert(internal.EncodeInternalKey([]byte("b"), 1, internal.RecordTypeValue), []byte("b1"))
+	older.Insert(internal.EncodeInternalKey([]byte("c"), 1, internal.RecordTypeValue), []byte("c1"))
+
+	newer := memtable.New()
+	newer.Insert(internal.EncodeInternalKey([]byte("b"), 2, internal.RecordTypeValue), []byte("b2"))
+	newer.Insert(internal.EncodeInternalKey([]byte("c"), 3, internal.RecordTypeValue), []byte("c3"))
+
+	merge := NewMergeIterator([]InternalIterator{
+		NewMemtableIterator(newer),
+		NewMemtableIterator(older),
+	}, true)
+
+	var got []string
+	for merge.SeekToLast(); merge.Valid(); merge.Prev() {
+		got = append(got, string(merge.Value()))
+	}
+	if len(got) != 3 || got[0] != "c3" || got[1] != "b2" || got[2] != "a1" {
+		t.Fatalf("unexpected reverse order: %v", got)
+	}
+
+	// Switch direction mid-scan.
+	merge.Seek(internal.SeekKey([]byte("b"), internal.MaxSequenceNumber))
+	if !merge.Valid() || string(merge.Value()) != "b2" {
+		t.Fatalf("expected b2 after Seek")
+	}
+	merge.Prev()
+	if !merge.Valid() || string(merge.Value()) != "a1" {
+		t.Fatalf("expected a1 after Prev")
+	}
+	merge.Next()
+	if !merge.Valid() || string(merge.Value()) != "b2" {
+		t.Fatalf("expected b2 after Next")
+	}
+	merge.Next()
+	if !merge.Valid() || string(merge.Value()) != "c3" {
+		t.Fatalf("expected c3 after Next")
+	}
+}
+
+func TestVersionFilterReverse(t *testing.T) {
+	mt := memtable.New()
+	mt.Insert(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	mt.Insert(internal.EncodeInternalKey([]byte("b"), 4, internal.RecordTypeValue), []byte("b4"))
+	mt.Insert(internal.EncodeInternalKey([]byte("b"), 2, internal.RecordTypeValue), []byte("b2"))
+	mt.Insert(internal.EncodeInternalKey([]byte("c"), 5, internal.RecordTypeValue), []byte("c5"))
+
+	filtered := NewVersionFilterIterator(NewMemtableIterator(mt), 3)
+	merge := NewMergeIterator([]InternalIterator{filtered}, true)
+
+	var got []string
+	for merge.SeekToLast(); merge.Valid(); merge.Prev() {
+		got = append(got, string(merge.Value()))
+	}
+	if len(got) != 2 || got[0] != "b2" || got[1] != "a1" {
+		t.Fatalf("unexpected reverse order at seq 3: %v", got)
+	}
+}
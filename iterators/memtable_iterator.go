@@ -21,10 +21,22 @@ func (it *MemtableIterator) Seek(key []byte) {
 	it.iter.Seek(key)
 }
 
+func (it *MemtableIterator) SeekToLast() {
+	it.iter.SeekToLast()
+}
+
+func (it *MemtableIterator) SeekForPrev(key []byte) {
+	it.iter.SeekForPrev(key)
+}
+
 func (it *MemtableIterator) Next() {
 	it.iter.Next()
 }
 
+func (it *MemtableIterator) Prev() {
+	it.iter.Prev()
+}
+
 func (it *MemtableIterator) Valid() bool {
 	return it.iter.Valid()
 }
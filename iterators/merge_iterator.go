@@ -7,6 +7,10 @@ import (
 )
 
 // MergeIterator merges multiple sorted iterators into one.
+//
+// Children are kept positioned just past the current entry in the direction
+// of travel: after the current key when moving forward, before it when
+// moving backward. Changing direction re-seeks every child.
 type MergeIterator struct {
 	iters []InternalIterator
 	valid []bool
@@ -15,6 +19,7 @@ type MergeIterator struct {
 	currValue []byte
 
 	initialized bool
+	forward     bool
 	cmp         internal.Comparator
 	Deduplicate bool // If true, skips older versions of same key.
 }
@@ -29,22 +34,101 @@ func NewMergeIterator(children []InternalIterator, deduplicate bool) *MergeItera
 	}
 }
 
+// SetComparator overrides the bytewise user key ordering.
+func (m *MergeIterator) SetComparator(cmp internal.Comparator) {
+	m.cmp = cmp
+}
+
 func (m *MergeIterator) SeekToFirst() {
 	for i, it := range m.iters {
 		it.SeekToFirst()
 		m.valid[i] = it.Valid()
 	}
 	m.initialized = true
+	m.forward = true
 	m.advance()
 }
 
+func (m *MergeIterator) SeekToLast() {
+	for i, it := range m.iters {
+		it.SeekToLast()
+		m.valid[i] = it.Valid()
+	}
+	m.initialized = true
+	m.forward = false
+	m.retreat()
+}
+
+func (m *MergeIterator) Seek(target []byte) {
+	for i, it := range m.iters {
+		it.Seek(target)
+		m.valid[i] = it.Valid()
+	}
+	m.initialized = true
+	m.forward = true
+	m.advance()
+}
+
+func (m *MergeIterator) SeekForPrev(target []byte) {
+	for i, it := range m.iters {
+		it.SeekForPrev(target)
+		m.valid[i] = it.Valid()
+	}
+	m.initialized = true
+	m.forward = false
+	m.retreat()
+}
+
 func (m *MergeIterator) Next() {
-	if !m.initialized {
+	if !m.initialized || !m.Valid() {
 		return
 	}
+	if !m.forward {
+		// Children sit before the current entry; move them past it.
+		userKey := internal.ExtractUserKey(m.currKey)
+		for i, it := range m.iters {
+			if m.Deduplicate {
+				it.Seek(internal.SeekKey(userKey, internal.MaxSequenceNumber))
+				for it.Valid() && bytes.Equal(internal.ExtractUserKey(it.Key()), userKey) {
+					it.Next()
+				}
+			} else {
+				it.Seek(m.currKey)
+				for it.Valid() && bytes.Equal(it.Key(), m.currKey) {
+					it.Next()
+				}
+			}
+			m.valid[i] = it.Valid()
+		}
+		m.forward = true
+	}
 	m.advance()
 }
 
+func (m *MergeIterator) Prev() {
+	if !m.initialized || !m.Valid() {
+		return
+	}
+	if m.forward {
+		// Children sit after the current entry; move them before it.
+		target := m.currKey
+		if m.Deduplicate {
+			target = internal.SeekKey(internal.ExtractUserKey(m.currKey), internal.MaxSequenceNumber)
+		}
+		for i, it := range m.iters {
+			it.Seek(target)
+			if it.Valid() {
+				it.Prev()
+			} else {
+				it.SeekToLast()
+			}
+			m.valid[i] = it.Valid()
+		}
+		m.forward = false
+	}
+	m.retreat()
+}
+
 func (m *MergeIterator) Valid() bool {
 	return m.currKey != nil
 }
@@ -106,3 +190,51 @@ func (m *MergeIterator) advance() {
 		}
 	}
 }
+
+// retreat selects the next largest key.
+// With Deduplicate, it walks back over every version of the user key
+// and settles on the newest one.
+func (m *MergeIterator) retreat() {
+	best := m.largest()
+	if best == -1 {
+		m.currKey = nil
+		m.currValue = nil
+		return
+	}
+
+	userKey := internal.ExtractUserKey(m.iters[best].Key())
+	for best != -1 {
+		m.currKey = m.iters[best].Key()
+		m.currValue = m.iters[best].Value()
+
+		// Step back past duplicate internal keys.
+		for i, it := range m.iters {
+			if m.valid[i] && bytes.Equal(it.Key(), m.currKey) {
+				it.Prev()
+				m.valid[i] = it.Valid()
+			}
+		}
+
+		if !m.Deduplicate {
+			return
+		}
+		best = m.largest()
+		if best != -1 && !bytes.Equal(internal.ExtractUserKey(m.iters[best].Key()), userKey) {
+			return
+		}
+	}
+}
+
+// largest returns the child holding the largest key, or -1.
+func (m *MergeIterator) largest() int {
+	best := -1
+	for i, ok := range m.valid {
+		if !ok {
+			continue
+		}
+		if best == -1 || m.cmp.Compare(m.iters[i].Key(), m.iters[best].Key()) > 0 {
+			best = i
+		}
+	}
+	return best
+}
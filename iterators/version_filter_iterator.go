@@ -25,11 +25,32 @@ func NewVersionFilterIterator(
 
 func (it *VersionFilterIterator) SeekToFirst() {
 	it.child.SeekToFirst()
-	it.advance()
+	it.skipForward()
+}
+
+func (it *VersionFilterIterator) SeekToLast() {
+	it.child.SeekToLast()
+	it.skipBackward()
+}
+
+func (it *VersionFilterIterator) Seek(target []byte) {
+	it.child.Seek(target)
+	it.skipForward()
+}
+
+func (it *VersionFilterIterator) SeekForPrev(target []byte) {
+	it.child.SeekForPrev(target)
+	it.skipBackward()
 }
 
 func (it *VersionFilterIterator) Next() {
-	it.advance()
+	it.child.Next()
+	it.skipForward()
+}
+
+func (it *VersionFilterIterator) Prev() {
+	it.child.Prev()
+	it.skipBackward()
 }
 
 func (it *VersionFilterIterator) Valid() bool {
@@ -44,27 +65,43 @@ func (it *VersionFilterIterator) Value() []byte {
 	return it.value
 }
 
-// advance skips records not visible at the current sequence.
-func (it *VersionFilterIterator) advance() {
-	it.valid = false
-
+// skipForward skips records not visible at the current sequence.
+func (it *VersionFilterIterator) skipForward() {
 	for it.child.Valid() {
-		k := it.child.Key()
-		seq, _, err := internal.ExtractTrailer(k)
-		if err != nil {
-			// Terminate on key corruption.
+		if visible, ok := it.visible(); !ok || visible {
 			return
 		}
+		// Skip newer versions.
+		it.child.Next()
+	}
+	it.valid = false
+}
 
-		if seq <= it.readSeq {
-			it.key = k
-			it.value = it.child.Value()
-			it.valid = true
-			it.child.Next()
+// skipBackward is skipForward in reverse.
+func (it *VersionFilterIterator) skipBackward() {
+	for it.child.Valid() {
+		if visible, ok := it.visible(); !ok || visible {
 			return
 		}
+		it.child.Prev()
+	}
+	it.valid = false
+}
 
-		// Skip newer versions.
-		it.child.Next()
+// visible captures the child's entry if it is visible.
+// ok is false on key corruption, which terminates iteration.
+func (it *VersionFilterIterator) visible() (visible bool, ok bool) {
+	it.valid = false
+	k := it.child.Key()
+	seq, _, err := internal.ExtractTrailer(k)
+	if err != nil {
+		return false, false
+	}
+	if seq > it.readSeq {
+		return false, true
 	}
+	it.key = k
+	it.value = it.child.Value()
+	it.valid = true
+	return true, true
 }
@@ -6,4 +6,9 @@ const (
 	_ = uint8(1) / (uint8(1) - (RecordTypeAddSSTable ^ 0x01))
 	_ = uint8(1) / (uint8(1) - (RecordTypeRemoveSSTable ^ 0x02))
 	_ = uint8(1) / (uint8(1) - (RecordTypeSetWALCutoff ^ 0x03))
+	_ = uint8(1) / (uint8(1) - (RecordTypeCreateColumnFamily ^ 0x04))
+	_ = uint8(1) / (uint8(1) - (RecordTypeDropColumnFamily ^ 0x05))
+	_ = uint8(1) / (uint8(1) - (RecordTypeAddBlobFile ^ 0x06))
+	_ = uint8(1) / (uint8(1) - (RecordTypeBlobGarbage ^ 0x07))
+	_ = uint8(1) / (uint8(1) - (RecordTypeSetComparator ^ 0x08))
 )
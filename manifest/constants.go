@@ -5,4 +5,12 @@ const (
 	RecordTypeAddSSTable    uint8 = 0x01
 	RecordTypeRemoveSSTable uint8 = 0x02
 	RecordTypeSetWALCutoff  uint8 = 0x03
+
+	RecordTypeCreateColumnFamily uint8 = 0x04
+	RecordTypeDropColumnFamily   uint8 = 0x05
+
+	RecordTypeAddBlobFile uint8 = 0x06
+	RecordTypeBlobGarbage uint8 = 0x07
+
+	RecordTypeSetComparator uint8 = 0x08
 )
@@ -24,16 +24,58 @@ type AddSSTable struct {
 	SmallestKey []byte
 	LargestKey  []byte
 	FileSize    int64
+
+	// ColumnFamily owns the table; 0 is the default family.
+	ColumnFamily uint32
 }
 
 // RemoveSSTable specifies the file number to remove.
 type RemoveSSTable struct {
-	FileNum uint64
+	FileNum      uint64
+	ColumnFamily uint32
 }
 
 // SetWALCutoff defines the sequence threshold for WAL truncation.
+// Each column family tracks its own cutoff.
 type SetWALCutoff struct {
-	Seq uint64
+	Seq          uint64
+	ColumnFamily uint32
+}
+
+// CreateColumnFamily registers a column family. Comparator names the
+// family's user key ordering; empty means the DB's, as set by SetComparator.
+type CreateColumnFamily struct {
+	ID         uint32
+	Name       string
+	Comparator string
+}
+
+// DropColumnFamily retires a column family and all of its tables.
+type DropColumnFamily struct {
+	ID uint32
+}
+
+// AddBlobFile registers a blob file holding Count records in Size bytes.
+type AddBlobFile struct {
+	FileNum      uint64
+	Count        uint64
+	Size         uint64
+	ColumnFamily uint32
+}
+
+// BlobGarbage records that Count records (Size bytes) of a blob file are no
+// longer referenced. A blob file whose records are all garbage is obsolete.
+type BlobGarbage struct {
+	FileNum      uint64
+	Count        uint64
+	Size         uint64
+	ColumnFamily uint32
+}
+
+// SetComparator names the user key ordering of every table in the DB.
+// Manifests without one were written under the bytewise ordering.
+type SetComparator struct {
+	Name string
 }
 
 func EncodeRecord(rec Record) ([]byte, error) {
@@ -54,14 +96,48 @@ func EncodeRecord(rec Record) ([]byte, error) {
 		payload.Write(r.LargestKey)
 
 		binary.Write(&payload, binary.LittleEndian, r.FileSize)
+		binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
 
 	case RecordTypeRemoveSSTable:
 		r := rec.Data.(RemoveSSTable)
 		binary.Write(&payload, binary.LittleEndian, r.FileNum)
+		binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
 
 	case RecordTypeSetWALCutoff:
 		r := rec.Data.(SetWALCutoff)
 		binary.Write(&payload, binary.LittleEndian, r.Seq)
+		binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
+
+	case RecordTypeCreateColumnFamily:
+		r := rec.Data.(CreateColumnFamily)
+		binary.Write(&payload, binary.LittleEndian, r.ID)
+		binary.Write(&payload, binary.LittleEndian, uint32(len(r.Name)))
+		payload.WriteString(r.Name)
+		binary.Write(&payload, binary.LittleEndian, uint32(len(r.Comparator)))
+		payload.WriteString(r.Comparator)
+
+	case RecordTypeDropColumnFamily:
+		r := rec.Data.(DropColumnFamily)
+		binary.Write(&payload, binary.LittleEndian, r.ID)
+
+	case RecordTypeAddBlobFile:
+		r := rec.Data.(AddBlobFile)
+		binary.Write(&payload, binary.LittleEndian, r.FileNum)
+		binary.Write(&payload, binary.LittleEndian, r.Count)
+		binary.Write(&payload, binary.LittleEndian, r.Size)
+		binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
+
+	case RecordTypeBlobGarbage:
+		r := rec.Data.(BlobGarbage)
+		binary.Write(&payload, binary.LittleEndian, r.FileNum)
+		binary.Write(&payload, binary.LittleEndian, r.Count)
+		binary.Write(&payload, binary.LittleEndian, r.Size)
+		binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
+
+	case RecordTypeSetComparator:
+		r := rec.Data.(SetComparator)
+		binary.Write(&payload, binary.LittleEndian, uint32(len(r.Name)))
+		payload.WriteString(r.Name)
 
 	default:
 		return nil, ErrInvalidRecord
@@ -133,16 +209,86 @@ func DecodeRecord(data []byte) (Record, int, error) {
 
 		binary.Read(rd, binary.LittleEndian, &out.FileSize)
 
+		// Absent in records written before column families; reads as default.
+		binary.Read(rd, binary.LittleEndian, &out.ColumnFamily)
+
 		rec.Data = out
 
 	case RecordTypeRemoveSSTable:
 		var out RemoveSSTable
-		binary.Read(bytes.NewReader(payload), binary.LittleEndian, &out.FileNum)
+		rd := bytes.NewReader(payload)
+		binary.Read(rd, binary.LittleEndian, &out.FileNum)
+		binary.Read(rd, binary.LittleEndian, &out.ColumnFamily)
 		rec.Data = out
 
 	case RecordTypeSetWALCutoff:
 		var out SetWALCutoff
-		binary.Read(bytes.NewReader(payload), binary.LittleEndian, &out.Seq)
+		rd := bytes.NewReader(payload)
+		binary.Read(rd, binary.LittleEndian, &out.Seq)
+		binary.Read(rd, binary.LittleEndian, &out.ColumnFamily)
+		rec.Data = out
+
+	case RecordTypeCreateColumnFamily:
+		var out CreateColumnFamily
+		rd := bytes.NewReader(payload)
+		binary.Read(rd, binary.LittleEndian, &out.ID)
+
+		var n uint32
+		binary.Read(rd, binary.LittleEndian, &n)
+		if int(n) > rd.Len() {
+			return Record{}, 0, ErrInvalidRecord
+		}
+		name := make([]byte, n)
+		rd.Read(name)
+		out.Name = string(name)
+
+		// Absent in records written before per-family comparators.
+		if rd.Len() > 0 {
+			binary.Read(rd, binary.LittleEndian, &n)
+			if int(n) > rd.Len() {
+				return Record{}, 0, ErrInvalidRecord
+			}
+			cmp := make([]byte, n)
+			rd.Read(cmp)
+			out.Comparator = string(cmp)
+		}
+		rec.Data = out
+
+	case RecordTypeDropColumnFamily:
+		var out DropColumnFamily
+		binary.Read(bytes.NewReader(payload), binary.LittleEndian, &out.ID)
+		rec.Data = out
+
+	case RecordTypeAddBlobFile:
+		var out AddBlobFile
+		rd := bytes.NewReader(payload)
+		binary.Read(rd, binary.LittleEndian, &out.FileNum)
+		binary.Read(rd, binary.LittleEndian, &out.Count)
+		binary.Read(rd, binary.LittleEndian, &out.Size)
+		binary.Read(rd, binary.LittleEndian, &out.ColumnFamily)
+		rec.Data = out
+
+	case RecordTypeBlobGarbage:
+		var out BlobGarbage
+		rd := bytes.NewReader(payload)
+		binary.Read(rd, binary.LittleEndian, &out.FileNum)
+		binary.Read(rd, binary.LittleEndian, &out.Count)
+		binary.Read(rd, binary.LittleEndian, &out.Size)
+		binary.Read(rd, binary.LittleEndian, &out.ColumnFamily)
+		rec.Data = out
+
+	case RecordTypeSetComparator:
+		var out SetComparator
+		rd := bytes.NewReader(payload)
+
+		var n uint32
+		binary.Read(rd, binary.LittleEndian, &n)
+		if int(n) > rd.Len() {
+			return Record{}, 0, ErrInvalidRecord
+		}
+		name := make([]byte, n)
+		rd.Read(name)
+		out.Name = string(name)
 		rec.Data = out
 
 	default:
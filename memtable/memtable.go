@@ -3,6 +3,8 @@ package memtable
 import (
 	"bytes"
 	"sync"
+
+	"vern_kv0.8/internal"
 )
 
 // Memtable is an in-memory state
@@ -10,6 +12,9 @@ type Memtable struct {
 	mu       sync.RWMutex
 	skiplist *Skiplist
 	size     int64
+
+	// Range tombstones live apart from point entries, keyed by start.
+	rangeDels *Skiplist
 }
 
 // Entry represents a key-value pair.
@@ -18,11 +23,17 @@ type Entry struct {
 	Value []byte
 }
 
-// New creates a fresh Memtable.
+// New creates a fresh Memtable ordering user keys bytewise.
 func New() *Memtable {
+	return NewWithComparator(internal.Comparator{})
+}
+
+// NewWithComparator creates a fresh Memtable ordered by cmp.
+func NewWithComparator(cmp internal.Comparator) *Memtable {
 	return &Memtable{
-		skiplist: NewSkiplist(),
-		size:     0,
+		skiplist:  NewSkiplistWithComparator(cmp),
+		size:      0,
+		rangeDels: NewSkiplistWithComparator(cmp),
 	}
 }
 
@@ -35,9 +46,27 @@ func (m *Memtable) Insert(key []byte, value []byte) {
 	// Improve approximation logic if needed.
 	estimatedSize := int64(len(key) + len(value) + 16) // Node overhead.
 	m.size += estimatedSize
+
+	if _, typ, _ := internal.ExtractTrailer(key); typ == internal.RecordTypeRangeDelete {
+		m.rangeDels.Insert(key, value)
+		return
+	}
 	m.skiplist.Insert(key, value)
 }
 
+// RangeTombstones returns the memtable's range tombstones ordered by start.
+func (m *Memtable) RangeTombstones() []internal.RangeTombstone {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []internal.RangeTombstone
+	it := m.rangeDels.NewIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		out = append(out, internal.DecodeRangeTombstone(it.Key(), it.Value()))
+	}
+	return out
+}
+
 // Read-only access
 // Get looks for key.
 func (m *Memtable) Get(key []byte) ([]byte, bool) {
@@ -52,6 +81,25 @@ func (m *Memtable) Get(key []byte) ([]byte, bool) {
 	return nil, false
 }
 
+// Versions calls fn for each entry of lookupKey's user key at or below its
+// sequence, newest first, until fn returns false.
+// lookupKey is typically built with internal.SeekKey.
+func (m *Memtable) Versions(lookupKey []byte, fn func(key, value []byte) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userKey := internal.ExtractUserKey(lookupKey)
+	it := m.skiplist.NewIterator()
+	for it.Seek(lookupKey); it.Valid(); it.Next() {
+		if !bytes.Equal(internal.ExtractUserKey(it.Key()), userKey) {
+			return
+		}
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
+	}
+}
+
 // Size returns the number of entries.
 func (m *Memtable) Size() int {
 	m.mu.RLock()
@@ -59,6 +107,13 @@ func (m *Memtable) Size() int {
 	return m.skiplist.Size()
 }
 
+// Empty reports whether the memtable holds no entries or range tombstones.
+func (m *Memtable) Empty() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.skiplist.Size() == 0 && m.rangeDels.Size() == 0
+}
+
 // ApproximateSize returns estimated memory usage.
 func (m *Memtable) ApproximateSize() int {
 	m.mu.RLock()
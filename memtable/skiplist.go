@@ -27,12 +27,17 @@ type Skiplist struct {
 }
 
 func NewSkiplist() *Skiplist {
+	return NewSkiplistWithComparator(internal.Comparator{})
+}
+
+// NewSkiplistWithComparator creates a skiplist ordered by cmp.
+func NewSkiplistWithComparator(cmp internal.Comparator) *Skiplist {
 	return &Skiplist{
 		head: &Node{
 			next: make([]*Node, maxLevel),
 		},
 		level: 1,
-		cmp:   internal.Comparator{},
+		cmp:   cmp,
 	}
 }
 
@@ -98,6 +103,34 @@ func (s *Skiplist) ApproximateSize() int {
 	return s.sizeBytes
 }
 
+// findLessThan returns the last node < key, or nil.
+func (s *Skiplist) findLessThan(key []byte) *Node {
+	current := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && s.cmp.Compare(current.next[i].key, key) < 0 {
+			current = current.next[i]
+		}
+	}
+	if current == s.head {
+		return nil
+	}
+	return current
+}
+
+// findLast returns the last node, or nil if empty.
+func (s *Skiplist) findLast() *Node {
+	current := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for current.next[i] != nil {
+			current = current.next[i]
+		}
+	}
+	if current == s.head {
+		return nil
+	}
+	return current
+}
+
 type iterEntry struct {
 	key   []byte
 	value []byte
@@ -133,6 +166,23 @@ func (it *Iterator) Seek(target []byte) {
 	it.node = current.next[0]
 }
 
+// Position at the last element.
+func (it *Iterator) SeekToLast() {
+	it.node = it.list.findLast()
+}
+
+// Retreat to last node <= target.
+func (it *Iterator) SeekForPrev(target []byte) {
+	it.Seek(target)
+	if !it.Valid() {
+		it.SeekToLast()
+		return
+	}
+	if it.list.cmp.Compare(it.node.key, target) > 0 {
+		it.Prev()
+	}
+}
+
 // Move to next.
 func (it *Iterator) Next() {
 	if it.node != nil {
@@ -140,6 +190,13 @@ func (it *Iterator) Next() {
 	}
 }
 
+// Move to previous.
+func (it *Iterator) Prev() {
+	if it.node != nil {
+		it.node = it.list.findLessThan(it.node.key)
+	}
+}
+
 // Is valid?
 func (it *Iterator) Valid() bool {
 	return it.node != nil
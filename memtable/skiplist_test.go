@@ -178,3 +178,31 @@ func TestRandomLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestSkiplistIteratorReverse(t *testing.T) {
+	sl := NewSkiplist()
+	for _, k := range []string{"a", "c", "e"} {
+		sl.Insert(ikey(k, 1), []byte(k))
+	}
+
+	it := sl.NewIterator()
+	it.SeekToLast()
+
+	var got []string
+	for ; it.Valid(); it.Prev() {
+		got = append(got, string(internal.ExtractUserKey(it.Key())))
+	}
+	if fmt.Sprint(got) != "[e c a]" {
+		t.Fatalf("reverse order: got %v", got)
+	}
+
+	it.SeekForPrev(ikey("d", 1))
+	if !it.Valid() || string(it.Value()) != "c" {
+		t.Fatalf("SeekForPrev(d) should land on c")
+	}
+
+	it.SeekForPrev(ikey("0", 1))
+	if it.Valid() {
+		t.Fatalf("SeekForPrev before first should be invalid")
+	}
+}
@@ -3,6 +3,7 @@ package sstable
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 )
 
 // BlockBuilder constructs a KV block.
@@ -13,6 +14,8 @@ type BlockBuilder struct {
 	counter       int
 	lastUnsafeKey []byte
 	finished      bool
+
+	restartInterval int // Keys between restart points.
 }
 
 const (
@@ -20,8 +23,13 @@ const (
 )
 
 func NewBlockBuilder() *BlockBuilder {
+	return newBlockBuilder(restartInterval)
+}
+
+func newBlockBuilder(interval int) *BlockBuilder {
 	return &BlockBuilder{
-		restarts: []uint32{0}, // First restart point.
+		restarts:        []uint32{0}, // First restart point.
+		restartInterval: interval,
 	}
 }
 
@@ -38,7 +46,7 @@ func (b *BlockBuilder) Add(key, value []byte) {
 
 	// Calculate shared prefix
 	shared := 0
-	if b.counter < restartInterval && b.lastUnsafeKey != nil {
+	if b.counter < b.restartInterval && b.lastUnsafeKey != nil {
 		minLen := len(b.lastUnsafeKey)
 		if len(key) < minLen {
 			minLen = len(key)
@@ -48,7 +56,7 @@ func (b *BlockBuilder) Add(key, value []byte) {
 		}
 	} else {
 		// Restart point: shared prefix is 0
-		if b.counter >= restartInterval {
+		if b.counter >= b.restartInterval {
 			b.restarts = append(b.restarts, uint32(b.buf.Len()))
 			b.counter = 0
 		}
@@ -99,6 +107,8 @@ type BlockIterator struct {
 	value []byte
 	err   error
 	valid bool
+
+	cmp func(a, b []byte) int // Key ordering used by Seek.
 }
 
 func NewBlockIterator(data []byte) *BlockIterator {
@@ -118,9 +128,15 @@ func NewBlockIterator(data []byte) *BlockIterator {
 		data:        data,
 		restarts:    restartsOffset,
 		numRestarts: numRestarts,
+		cmp:         bytes.Compare,
 	}
 }
 
+// SetComparator overrides the bytewise key ordering.
+func (it *BlockIterator) SetComparator(cmp func(a, b []byte) int) {
+	it.cmp = cmp
+}
+
 func (it *BlockIterator) Valid() bool {
 	return it.valid && it.err == nil
 }
@@ -154,7 +170,7 @@ func (it *BlockIterator) Seek(target []byte) {
 			return
 		}
 
-		if bytes.Compare(key, target) < 0 {
+		if it.cmp(key, target) < 0 {
 			left = mid
 		} else {
 			right = mid - 1
@@ -168,7 +184,58 @@ func (it *BlockIterator) Seek(target []byte) {
 		if !it.ParseNext() {
 			return
 		}
-		if bytes.Compare(it.key, target) >= 0 {
+		if it.cmp(it.key, target) >= 0 {
+			return
+		}
+	}
+}
+
+// SeekToLast positions at the last entry.
+func (it *BlockIterator) SeekToLast() {
+	if it.err != nil || it.numRestarts == 0 {
+		it.valid = false
+		return
+	}
+	it.SeekToRestartPoint(it.numRestarts - 1)
+	for it.ParseNext() && it.nextOffset < int(it.restarts) {
+	}
+}
+
+// SeekForPrev positions at the last entry <= target.
+func (it *BlockIterator) SeekForPrev(target []byte) {
+	it.Seek(target)
+	if it.err != nil {
+		return
+	}
+	if !it.Valid() {
+		it.SeekToLast()
+		return
+	}
+	if it.cmp(it.key, target) > 0 {
+		it.Prev()
+	}
+}
+
+// Prev moves to the previous entry.
+// Entries are prefix-compressed, so it rescans from the nearest restart point.
+func (it *BlockIterator) Prev() {
+	if !it.Valid() {
+		return
+	}
+	original := it.offset
+
+	// Last restart point strictly before the current entry.
+	idx := sort.Search(int(it.numRestarts), func(i int) bool {
+		return int(it.GetRestartPoint(uint32(i))) >= original
+	}) - 1
+	if idx < 0 {
+		it.valid = false
+		return
+	}
+
+	it.SeekToRestartPoint(uint32(idx))
+	for it.ParseNext() {
+		if it.nextOffset >= original {
 			return
 		}
 	}
@@ -89,3 +89,41 @@ func TestBlockSeek(t *testing.T) {
 		t.Errorf("Seek past end should be invalid")
 	}
 }
+
+func TestBlockReverse(t *testing.T) {
+	b := NewBlockBuilder()
+
+	// Span several restart points.
+	for i := 0; i < 40; i++ {
+		b.Add([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+	}
+
+	it := NewBlockIterator(b.Finish())
+
+	it.SeekToLast()
+	for i := 39; i >= 0; i-- {
+		want := fmt.Sprintf("key%03d", i)
+		if !it.Valid() || string(it.Key()) != want {
+			t.Fatalf("reverse scan: want %s, got %s", want, it.Key())
+		}
+		it.Prev()
+	}
+	if it.Valid() {
+		t.Errorf("Prev before first entry should be invalid")
+	}
+
+	it.SeekForPrev([]byte("key016a"))
+	if !it.Valid() || string(it.Key()) != "key016" {
+		t.Errorf("SeekForPrev(key016a) want key016, got %s", it.Key())
+	}
+
+	it.SeekForPrev([]byte("key999"))
+	if !it.Valid() || string(it.Key()) != "key039" {
+		t.Errorf("SeekForPrev past end want key039, got %s", it.Key())
+	}
+
+	it.SeekForPrev([]byte("a"))
+	if it.Valid() {
+		t.Errorf("SeekForPrev before first should be invalid")
+	}
+}
@@ -2,11 +2,13 @@ package sstable
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"vern_kv0.8/internal"
 )
@@ -30,13 +32,82 @@ type Builder struct {
 	// Filter support.
 	filterPolicy FilterPolicy
 	keys         [][]byte
+
+	// Prefixes of the keys are added to the filter as well, when set.
+	prefixExtractor PrefixExtractor
+	lastPrefix      []byte
+
+	// Range tombstones, created on first use.
+	rangeDelBlock *BlockBuilder
+
+	// Shortens index keys when set; otherwise they are whole block keys.
+	cmp *internal.Comparator
+
+	compression     int
+	blockSize       int
+	restartInterval int
 }
 
 const (
 	blockSize = 4 * 1024
 )
 
-func NewBuilder(filename string) (*Builder, error) {
+// BuilderOptions tunes the tables a Builder writes.
+type BuilderOptions struct {
+	// Compression is the block codec. Blocks it does not shrink are stored raw.
+	Compression int
+
+	// BlockSize is the uncompressed size at which data blocks are cut.
+	// Zero uses 4KB.
+	BlockSize int
+
+	// RestartInterval is the number of keys between restart points in data
+	// blocks. Zero uses 16.
+	RestartInterval int
+
+	// FilterPolicy builds the table filter. nil uses a 10 bits-per-key bloom
+	// filter; readers must be given the same policy to use it.
+	FilterPolicy FilterPolicy
+}
+
+// DefaultBuilderOptions returns the options NewBuilder uses when given none.
+func DefaultBuilderOptions() *BuilderOptions {
+	return &BuilderOptions{
+		Compression:     ZlibCompression,
+		BlockSize:       blockSize,
+		RestartInterval: restartInterval,
+		FilterPolicy:    NewBloomFilter(10),
+	}
+}
+
+// NewBuilder creates the table filename. It fails with
+// ErrUnsupportedCompression if the options name a codec that cannot be written.
+func NewBuilder(filename string, options ...*BuilderOptions) (*Builder, error) {
+	opts := DefaultBuilderOptions()
+	if len(options) > 0 && options[0] != nil {
+		opts = options[0]
+	}
+	if err := CheckCompression(opts.Compression); err != nil {
+		return nil, err
+	}
+
+	b := &Builder{
+		filterPolicy:    opts.FilterPolicy,
+		keys:            make([][]byte, 0, 1024),
+		compression:     opts.Compression,
+		blockSize:       opts.BlockSize,
+		restartInterval: opts.RestartInterval,
+	}
+	if b.filterPolicy == nil {
+		b.filterPolicy = NewBloomFilter(10)
+	}
+	if b.blockSize <= 0 {
+		b.blockSize = blockSize
+	}
+	if b.restartInterval <= 0 {
+		b.restartInterval = restartInterval
+	}
+
 	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return nil, err
 	}
@@ -46,15 +117,24 @@ func NewBuilder(filename string) (*Builder, error) {
 		return nil, err
 	}
 
-	return &Builder{
-		file:           f,
-		writer:         bufio.NewWriter(f),
-		dataBlock:      NewBlockBuilder(),
-		indexBlock:     NewBlockBuilder(),
-		metaIndexBlock: NewBlockBuilder(),
-		filterPolicy:   NewBloomFilter(10),
-		keys:           make([][]byte, 0, 1024),
-	}, nil
+	b.file = f
+	b.writer = bufio.NewWriter(f)
+	b.dataBlock = newBlockBuilder(b.restartInterval)
+	b.indexBlock = NewBlockBuilder()
+	b.metaIndexBlock = NewBlockBuilder()
+	return b, nil
+}
+
+// SetComparator sets the internal key ordering of the table, letting index
+// entries hold short separators instead of whole keys.
+func (b *Builder) SetComparator(cmp internal.Comparator) {
+	b.cmp = &cmp
+}
+
+// SetPrefixExtractor adds the prefix of every key in p's domain to the filter.
+// The extractor's name is recorded so readers can tell which prefixes it holds.
+func (b *Builder) SetPrefixExtractor(p PrefixExtractor) {
+	b.prefixExtractor = p
 }
 
 // Add appends a key-value pair.
@@ -70,7 +150,7 @@ func (b *Builder) Add(key, value []byte) error {
 
 	// Pending index entry.
 	if b.pendingIndexEntry {
-		sep := findShortestSeparator(b.lastKey, key)
+		sep := b.findShortestSeparator(b.lastKey, key)
 		encodedHandle := make([]byte, 16)
 		b.pendingHandle.EncodeTo(encodedHandle)
 		b.indexBlock.Add(sep, encodedHandle)
@@ -83,11 +163,20 @@ func (b *Builder) Add(key, value []byte) error {
 	copy(b.lastKey, key)
 
 	if b.filterPolicy != nil {
-		b.keys = append(b.keys, internal.ExtractUserKey(key))
+		userKey := internal.ExtractUserKey(key)
+		b.keys = append(b.keys, userKey)
+
+		// Versions and neighbours share prefixes; add each run once.
+		if p := b.prefixExtractor; p != nil && p.InDomain(userKey) {
+			if prefix := p.Transform(userKey); b.lastPrefix == nil || !bytes.Equal(prefix, b.lastPrefix) {
+				b.keys = append(b.keys, prefix)
+				b.lastPrefix = prefix
+			}
+		}
 	}
 	b.numEntries++
 
-	if b.dataBlock.CurrentSize() >= blockSize {
+	if b.dataBlock.CurrentSize() >= b.blockSize {
 		if err := b.flushDataBlock(); err != nil {
 			b.err = err
 			return err
@@ -97,6 +186,23 @@ func (b *Builder) Add(key, value []byte) error {
 	return nil
 }
 
+// AddRangeTombstone records a range tombstone in the range deletion block.
+// key is the tombstone's internal start key and value its end user key.
+// Tombstones must be added in key order.
+func (b *Builder) AddRangeTombstone(key, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.closed {
+		return fmt.Errorf("builder closed")
+	}
+	if b.rangeDelBlock == nil {
+		b.rangeDelBlock = NewBlockBuilder()
+	}
+	b.rangeDelBlock.Add(key, value)
+	return nil
+}
+
 func (b *Builder) Size() uint64 {
 	return b.offset + uint64(b.dataBlock.CurrentSize())
 }
@@ -106,44 +212,10 @@ func (b *Builder) flushDataBlock() error {
 		return nil
 	}
 
-	content := b.dataBlock.Finish()
-	compressed := compress(content)
-
-	var final []byte
-	var cType byte
-
-	if len(compressed) < len(content)-2 {
-		final = compressed
-		cType = byte(ZlibCompression)
-	} else {
-		final = content
-		cType = byte(NoCompression)
-	}
-
-	n, err := b.writer.Write(final)
+	handle, err := b.writeBlock(b.dataBlock.Finish())
 	if err != nil {
 		return err
 	}
-
-	if _, err := b.writer.Write([]byte{cType}); err != nil {
-		return err
-	}
-	n++
-
-	crc := crc32.ChecksumIEEE(final)
-	crc = crc32.Update(crc, crc32.IEEETable, []byte{cType})
-
-	if err := binary.Write(b.writer, binary.LittleEndian, crc); err != nil {
-		return err
-	}
-	n += 4
-
-	handle := BlockHandle{
-		Offset: b.offset,
-		Length: uint64(n),
-	}
-
-	b.offset += uint64(n)
 	b.dataBlock.Reset()
 
 	b.pendingHandle = handle
@@ -169,10 +241,13 @@ func (b *Builder) Close() error {
 	if b.pendingIndexEntry {
 		encodedHandle := make([]byte, 16)
 		b.pendingHandle.EncodeTo(encodedHandle)
-		b.indexBlock.Add(b.lastKey, encodedHandle)
+		b.indexBlock.Add(b.findShortSuccessor(b.lastKey), encodedHandle)
 		b.pendingIndexEntry = false
 	}
 
+	// Metaindex entries, added in key order once every meta block is written.
+	var meta []metaEntry
+
 	// Filter Block.
 	var filterHandle BlockHandle
 	if b.filterPolicy != nil && len(b.keys) > 0 {
@@ -186,77 +261,40 @@ func (b *Builder) Close() error {
 
 		encodedFilterHandle := make([]byte, 16)
 		filterHandle.EncodeTo(encodedFilterHandle)
-		b.metaIndexBlock.Add([]byte(b.filterPolicy.Name()), encodedFilterHandle)
-	}
+		meta = append(meta, metaEntry{b.filterPolicy.Name(), encodedFilterHandle})
 
-	// Meta Index.
-	metaIndexContent := b.metaIndexBlock.Finish()
-
-	compressedMeta := compress(metaIndexContent)
-	var finalMeta []byte
-	var metaType byte
-	if len(compressedMeta) < len(metaIndexContent)-2 {
-		finalMeta = compressedMeta
-		metaType = byte(ZlibCompression)
-	} else {
-		finalMeta = metaIndexContent
-		metaType = byte(NoCompression)
+		if b.prefixExtractor != nil {
+			meta = append(meta, metaEntry{prefixExtractorName, []byte(b.prefixExtractor.Name())})
+		}
 	}
 
-	n, err := b.writer.Write(finalMeta)
-	if err != nil {
-		return err
-	}
-	if _, err := b.writer.Write([]byte{metaType}); err != nil {
-		return err
+	// Range deletion block.
+	if b.rangeDelBlock != nil {
+		rangeDelHandle, err := b.writeBlock(b.rangeDelBlock.Finish())
+		if err != nil {
+			return err
+		}
+		encodedRangeDelHandle := make([]byte, 16)
+		rangeDelHandle.EncodeTo(encodedRangeDelHandle)
+		meta = append(meta, metaEntry{rangeDelBlockName, encodedRangeDelHandle})
 	}
-	n++
 
-	crcMeta := crc32.ChecksumIEEE(finalMeta)
-	crcMeta = crc32.Update(crcMeta, crc32.IEEETable, []byte{metaType})
-	if err := binary.Write(b.writer, binary.LittleEndian, crcMeta); err != nil {
-		return err
+	sort.Slice(meta, func(i, j int) bool { return meta[i].name < meta[j].name })
+	for _, e := range meta {
+		b.metaIndexBlock.Add([]byte(e.name), e.value)
 	}
-	n += 4
 
-	metaIndexHandle := BlockHandle{Offset: b.offset, Length: uint64(n)}
-	b.offset += uint64(n)
-
-	// Index Block.
-	indexContent := b.indexBlock.Finish()
-
-	compressedIndex := compress(indexContent)
-	var finalIndex []byte
-	var indexType byte
-	if len(compressedIndex) < len(indexContent)-2 {
-		finalIndex = compressedIndex
-		indexType = byte(ZlibCompression)
-	} else {
-		finalIndex = indexContent
-		indexType = byte(NoCompression)
-	}
-
-	n, err = b.writer.Write(finalIndex)
+	// Meta Index.
+	metaIndexHandle, err := b.writeBlock(b.metaIndexBlock.Finish())
 	if err != nil {
 		return err
 	}
-	if _, err := b.writer.Write([]byte{indexType}); err != nil {
-		return err
-	}
-	n++
 
-	crcIndex := crc32.ChecksumIEEE(finalIndex)
-	crcIndex = crc32.Update(crcIndex, crc32.IEEETable, []byte{indexType})
-	if err := binary.Write(b.writer, binary.LittleEndian, crcIndex); err != nil {
+	// Index Block.
+	indexHandle, err := b.writeBlock(b.indexBlock.Finish())
+	if err != nil {
 		return err
 	}
-	n += 4
-
-	indexHandle := BlockHandle{
-		Offset: b.offset,
-		Length: uint64(n),
-	}
-	b.offset += uint64(n)
 
 	// Footer.
 	footer := Footer{
@@ -278,8 +316,70 @@ func (b *Builder) Close() error {
 	return b.file.Close()
 }
 
-func findShortestSeparator(a, b []byte) []byte {
-	return a
+// metaEntry is a metaindex entry: a meta block's name and its handle or value.
+type metaEntry struct {
+	name  string
+	value []byte
+}
+
+// writeBlock writes content with a compression type byte and CRC trailer.
+func (b *Builder) writeBlock(content []byte) (BlockHandle, error) {
+	var final []byte
+	var cType byte
+
+	var compressed []byte
+	if b.compression != NoCompression {
+		compressed = compressBlock(b.compression, content)
+	}
+	if compressed != nil && len(compressed) < len(content)-2 {
+		final = compressed
+		cType = byte(b.compression)
+	} else {
+		final = content
+		cType = byte(NoCompression)
+	}
+
+	n, err := b.writer.Write(final)
+	if err != nil {
+		return BlockHandle{}, err
+	}
+
+	if _, err := b.writer.Write([]byte{cType}); err != nil {
+		return BlockHandle{}, err
+	}
+	n++
+
+	crc := crc32.ChecksumIEEE(final)
+	crc = crc32.Update(crc, crc32.IEEETable, []byte{cType})
+
+	if err := binary.Write(b.writer, binary.LittleEndian, crc); err != nil {
+		return BlockHandle{}, err
+	}
+	n += 4
+
+	handle := BlockHandle{
+		Offset: b.offset,
+		Length: uint64(n),
+	}
+	b.offset += uint64(n)
+	return handle, nil
+}
+
+// findShortestSeparator returns an index key for the block ending at a,
+// sorting before b, the first key of the next block.
+func (b *Builder) findShortestSeparator(a, next []byte) []byte {
+	if b.cmp == nil {
+		return a
+	}
+	return b.cmp.FindShortestSeparator(a, next)
+}
+
+// findShortSuccessor returns an index key for the last block, ending at a.
+func (b *Builder) findShortSuccessor(a []byte) []byte {
+	if b.cmp == nil {
+		return a
+	}
+	return b.cmp.FindShortSuccessor(a)
 }
 
 func encodeBlockHandle(offset, size uint64) []byte {
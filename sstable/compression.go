@@ -3,6 +3,7 @@ package sstable
 import (
 	"bytes"
 	"compress/zlib"
+	"errors"
 	"io"
 )
 
@@ -10,9 +11,50 @@ const (
 	NoCompression     = 0
 	ZlibCompression   = 1
 	SnappyCompression = 2
-	ZstdCompression   = 3
+	ZstdCompression   = 3 // Reserved; not implemented.
+	LZ4Compression    = 4
 )
 
+// ErrUnsupportedCompression is returned for a codec this build cannot write.
+var ErrUnsupportedCompression = errors.New("unsupported compression type")
+
+// CheckCompression reports whether tables can be written with codec.
+func CheckCompression(codec int) error {
+	switch codec {
+	case NoCompression, ZlibCompression, SnappyCompression, LZ4Compression:
+		return nil
+	}
+	return ErrUnsupportedCompression
+}
+
+// compressBlock encodes src with codec, which must pass CheckCompression.
+func compressBlock(codec int, src []byte) []byte {
+	switch codec {
+	case ZlibCompression:
+		return compress(src)
+	case SnappyCompression:
+		return snappyEncode(src)
+	case LZ4Compression:
+		return lz4Encode(src)
+	}
+	return src
+}
+
+// decompressBlock decodes a block payload stored with codec.
+func decompressBlock(codec int, src []byte) ([]byte, error) {
+	switch codec {
+	case NoCompression:
+		return src, nil
+	case ZlibCompression:
+		return decompress(src)
+	case SnappyCompression:
+		return snappyDecode(src)
+	case LZ4Compression:
+		return lz4Decode(src)
+	}
+	return nil, ErrUnsupportedCompression
+}
+
 func compress(src []byte) []byte {
 	var b bytes.Buffer
 	w := zlib.NewWriter(&b)
@@ -2,7 +2,12 @@ package sstable
 
 import (
 	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
 	"testing"
+
+	"vern_kv0.8/internal"
 )
 
 func TestCompression(t *testing.T) {
@@ -20,6 +25,113 @@ func TestCompression(t *testing.T) {
 	}
 }
 
+func TestCodecRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 10000)
+	rng.Read(random)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 200000; i++ {
+		fmt.Fprintf(&text, "user:%06d:profile{name=%d}", i%500, rng.Intn(50))
+	}
+
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"short":  []byte("abc"),
+		"run":    bytes.Repeat([]byte{'x'}, 5000),
+		"random": random,
+		"text":   text.Bytes(), // Offsets past 64KB and long literals.
+	}
+	for _, codec := range []int{ZlibCompression, SnappyCompression, LZ4Compression} {
+		for name, in := range inputs {
+			out := compressBlock(codec, in)
+			got, err := decompressBlock(codec, out)
+			if err != nil {
+				t.Fatalf("codec %d, %s: %v", codec, name, err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatalf("codec %d, %s: round trip mismatch", codec, name)
+			}
+			if name == "run" && len(out) > len(in)/10 {
+				t.Fatalf("codec %d: run compressed to %d bytes", codec, len(out))
+			}
+		}
+	}
+}
+
+func TestCodecRejectsCorruptInput(t *testing.T) {
+	in := bytes.Repeat([]byte("abcdefgh"), 100)
+	for _, codec := range []int{SnappyCompression, LZ4Compression} {
+		out := compressBlock(codec, in)
+		if _, err := decompressBlock(codec, out[:len(out)-1]); err == nil {
+			t.Fatalf("codec %d: expected an error for a truncated block", codec)
+		}
+		// Claim more output than the elements produce.
+		bad := append([]byte{0xFF, 0x0F}, out[2:]...)
+		if _, err := decompressBlock(codec, bad); err == nil {
+			t.Fatalf("codec %d: expected an error for a wrong length", codec)
+		}
+	}
+	if _, err := decompressBlock(ZstdCompression, in); err != ErrUnsupportedCompression {
+		t.Fatalf("expected ErrUnsupportedCompression, got %v", err)
+	}
+}
+
+// renamedBloom is a bloom filter written under its own name.
+type renamedBloom struct{ *BloomFilter }
+
+func (renamedBloom) Name() string { return "test.filter" }
+
 func TestEndToEndCompression(t *testing.T) {
-	// Compression is verified via the primitives above.
+	if _, err := NewBuilder(filepath.Join(t.TempDir(), "zstd.sst"), &BuilderOptions{Compression: ZstdCompression}); err != ErrUnsupportedCompression {
+		t.Fatalf("expected ErrUnsupportedCompression, got %v", err)
+	}
+
+	for _, codec := range []int{NoCompression, ZlibCompression, SnappyCompression, LZ4Compression} {
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("codec%d.sst", codec))
+		opts := &BuilderOptions{
+			Compression:     codec,
+			BlockSize:       512,
+			RestartInterval: 4,
+			FilterPolicy:    renamedBloom{NewBloomFilter(10)},
+		}
+		b, err := NewBuilder(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := internal.EncodeInternalKey([]byte(fmt.Sprintf("key%05d", i)), uint64(i+1), internal.RecordTypeValue)
+			b.Add(key, bytes.Repeat([]byte{byte('a' + i%26)}, 20))
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(path, nil, opts.FilterPolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetComparator(internal.Comparator{}.Compare)
+		if r.filterData == nil {
+			t.Fatalf("codec %d: filter written under a custom name was not found", codec)
+		}
+		for _, i := range []int{0, 499, 999} {
+			_, v, err := r.Get(internal.SeekKey([]byte(fmt.Sprintf("key%05d", i)), internal.MaxSequenceNumber))
+			if err != nil || v[0] != byte('a'+i%26) {
+				t.Fatalf("codec %d: key%05d = %q (%v)", codec, i, v, err)
+			}
+		}
+
+		it, err := r.NewIterator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			n++
+		}
+		if n != 1000 {
+			t.Fatalf("codec %d: iterated %d entries", codec, n)
+		}
+		r.Close()
+	}
 }
@@ -1,6 +1,7 @@
 package sstable
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
 	"testing"
@@ -37,6 +38,8 @@ func TestFullSSTable(t *testing.T) {
 		t.Fatalf("NewReader: %v", err)
 	}
 	defer r.Close()
+	// The keys are raw, not internal keys.
+	r.SetComparator(bytes.Compare)
 
 	it, err := r.NewIterator()
 	if err != nil {
@@ -82,4 +85,24 @@ func TestFullSSTable(t *testing.T) {
 			t.Errorf("Seek(%s) want %s, got %s", tt.target, tt.want, it.Key())
 		}
 	}
+
+	// Verify reverse scan across blocks.
+	it.SeekToLast()
+	count = numKeys
+	for it.Valid() {
+		count--
+		want := fmt.Sprintf("k%04d", count)
+		if string(it.Key()) != want {
+			t.Fatalf("Reverse mismatch: want %s, got %s", want, it.Key())
+		}
+		it.Prev()
+	}
+	if count != 0 {
+		t.Errorf("Reverse scan stopped early at %d", count)
+	}
+
+	it.SeekForPrev([]byte("k0100a"))
+	if !it.Valid() || string(it.Key()) != "k0100" {
+		t.Errorf("SeekForPrev(k0100a) want k0100, got %s", it.Key())
+	}
 }
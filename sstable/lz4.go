@@ -0,0 +1,149 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// LZ4 blocks are stored as the uvarint decoded length followed by a raw LZ4
+// block: a run of sequences, each a token byte, extra literal length bytes,
+// the literals, a 2-byte offset and extra match length bytes. The token holds
+// the literal length and the match length minus 4, with 15 meaning more
+// length follows in bytes of 255 ended by a smaller one. The last sequence
+// has literals only.
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // The last bytes are always literals.
+	lz4MFLimit      = 12 // No match starts this close to the end.
+	lz4TableBits    = 12
+	lz4MaxOffset    = 1<<16 - 1
+)
+
+// lz4Encode compresses src into the LZ4 block format.
+func lz4Encode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+
+	// Last position+1 of each hashed 4-byte sequence.
+	var table [1 << lz4TableBits]int32
+
+	anchor := 0
+	for i := 0; i+lz4MFLimit <= len(src); {
+		cur := binary.LittleEndian.Uint32(src[i:])
+		h := hash4(cur, lz4TableBits)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > lz4MaxOffset || binary.LittleEndian.Uint32(src[cand:]) != cur {
+			i++
+			continue
+		}
+
+		length := lz4MinMatch
+		for i+length < len(src)-lz4LastLiterals && src[cand+length] == src[i+length] {
+			length++
+		}
+		dst = lz4EmitSequence(dst, src[anchor:i], i-cand, length)
+		i += length
+		anchor = i
+	}
+
+	lit := src[anchor:]
+	dst = append(dst, lz4Token(len(lit))<<4)
+	dst = lz4AppendLength(dst, len(lit))
+	return append(dst, lit...)
+}
+
+func lz4EmitSequence(dst, lit []byte, offset, length int) []byte {
+	ml := length - lz4MinMatch
+	dst = append(dst, lz4Token(len(lit))<<4|lz4Token(ml))
+	dst = lz4AppendLength(dst, len(lit))
+	dst = append(dst, lit...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	return lz4AppendLength(dst, ml)
+}
+
+// lz4Token returns the 4-bit token field for n.
+func lz4Token(n int) byte {
+	if n >= 15 {
+		return 15
+	}
+	return byte(n)
+}
+
+// lz4AppendLength appends the bytes of n that do not fit the token.
+func lz4AppendLength(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	for n -= 15; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4ReadLength extends a token length of 15 with the bytes that follow.
+func lz4ReadLength(src []byte, n int) (int, []byte, bool) {
+	if n != 15 {
+		return n, src, true
+	}
+	for {
+		if len(src) == 0 {
+			return 0, nil, false
+		}
+		b := src[0]
+		src = src[1:]
+		n += int(b)
+		if b != 255 {
+			return n, src, true
+		}
+	}
+}
+
+// lz4Decode decompresses an LZ4 block.
+func lz4Decode(src []byte) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 || n > math.MaxInt32 {
+		return nil, ErrBlockCorrupt
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+
+	for {
+		if len(src) == 0 {
+			return nil, ErrBlockCorrupt
+		}
+		token := src[0]
+
+		litLen, rest, ok := lz4ReadLength(src[1:], int(token>>4))
+		if !ok || litLen > len(rest) || uint64(len(dst)+litLen) > n {
+			return nil, ErrBlockCorrupt
+		}
+		dst = append(dst, rest[:litLen]...)
+		src = rest[litLen:]
+		if len(src) == 0 {
+			break // Last sequence.
+		}
+
+		if len(src) < 2 {
+			return nil, ErrBlockCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src))
+		matchLen, rest, ok := lz4ReadLength(src[2:], int(token&0x0F))
+		if !ok {
+			return nil, ErrBlockCorrupt
+		}
+		src = rest
+		matchLen += lz4MinMatch
+		if offset == 0 || offset > len(dst) || uint64(len(dst)+matchLen) > n {
+			return nil, ErrBlockCorrupt
+		}
+		// Matches may overlap their own output, so go byte by byte.
+		for j := 0; j < matchLen; j++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	if uint64(len(dst)) != n {
+		return nil, ErrBlockCorrupt
+	}
+	return dst, nil
+}
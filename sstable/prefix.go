@@ -0,0 +1,43 @@
+package sstable
+
+import "fmt"
+
+// prefixExtractorName is the metaindex key naming the extractor whose
+// prefixes were added to the filter. Its value is the name, not a handle.
+const prefixExtractorName = "vern.prefix_extractor"
+
+// PrefixExtractor maps user keys to the prefixes indexed by table filters.
+//
+// For a key p in the domain, every key beginning with p must be in the
+// domain and map to Transform(p); this lets a prefix scan over p skip tables
+// whose filter rules Transform(p) out.
+type PrefixExtractor interface {
+	// Name identifies the extractor. Filters built under another name are ignored.
+	Name() string
+
+	// Transform returns key's prefix. key is in the domain.
+	Transform(key []byte) []byte
+
+	// InDomain reports whether key has a prefix.
+	InDomain(key []byte) bool
+}
+
+// NewFixedPrefixExtractor returns an extractor taking the first n bytes of
+// each key. Shorter keys have no prefix.
+func NewFixedPrefixExtractor(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
+
+type fixedPrefix int
+
+func (p fixedPrefix) Name() string {
+	return fmt.Sprintf("vern.FixedPrefix.%d", int(p))
+}
+
+func (p fixedPrefix) Transform(key []byte) []byte {
+	return key[:p]
+}
+
+func (p fixedPrefix) InDomain(key []byte) bool {
+	return len(key) >= int(p)
+}
@@ -1,12 +1,14 @@
 package sstable
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"os"
 
+	"vern_kv0.8/internal"
 	"vern_kv0.8/internal/cache"
 )
 
@@ -18,10 +20,19 @@ type Reader struct {
 
 	filterPolicy FilterPolicy
 	filterData   []byte
+	prefixName   string // Extractor whose prefixes are in the filter, if any.
 	cache        cache.Cache
+	cmp          func(a, b []byte) int // Internal key ordering; bytewise user keys by default.
+
+	footer       Footer
+	footerLoaded bool // Set once at open; readers are shared across goroutines.
+
+	rangeDels []internal.RangeTombstone // Loaded at open.
 }
 
-func NewReader(path string, cache cache.Cache) (*Reader, error) {
+// NewReader opens the table at path. Its filter is read with filter, or with
+// the default bloom filter policy when none is given.
+func NewReader(path string, cache cache.Cache, filter ...FilterPolicy) (*Reader, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -38,12 +49,22 @@ func NewReader(path string, cache cache.Cache) (*Reader, error) {
 		path:         path,
 		filterPolicy: NewBloomFilter(10), // Expect compatible filter
 		cache:        cache,
+		cmp:          internal.Comparator{}.Compare,
+	}
+	if len(filter) > 0 && filter[0] != nil {
+		r.filterPolicy = filter[0]
 	}
 
 	if err := r.loadFilter(); err != nil {
 		// Filter errors are non-fatal.
 	}
 
+	// Missing range tombstones would resurrect deleted keys, so these are fatal.
+	if err := r.loadRangeTombstones(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
 	return r, nil
 }
 
@@ -52,6 +73,8 @@ func (r *Reader) loadFilter() error {
 	if err != nil {
 		return err
 	}
+	r.footer = footer
+	r.footerLoaded = true
 
 	// Read MetaIndex
 	metaIndexBlock, err := r.ReadBlock(footer.MetaindexHandle)
@@ -60,7 +83,7 @@ func (r *Reader) loadFilter() error {
 	}
 
 	// Find filter.
-	filterName := "vern.filter.bloom"
+	filterName := r.filterPolicy.Name()
 	metaIndexBlock.Seek([]byte(filterName))
 
 	if metaIndexBlock.Valid() && string(metaIndexBlock.Key()) == filterName {
@@ -79,9 +102,58 @@ func (r *Reader) loadFilter() error {
 		r.filterData = data
 	}
 
+	metaIndexBlock.Seek([]byte(prefixExtractorName))
+	if metaIndexBlock.Valid() && string(metaIndexBlock.Key()) == prefixExtractorName {
+		r.prefixName = string(metaIndexBlock.Value())
+	}
+
 	return nil
 }
 
+func (r *Reader) loadRangeTombstones() error {
+	if !r.footerLoaded {
+		footer, err := r.ReadFooter()
+		if err != nil {
+			return err
+		}
+		r.footer = footer
+		r.footerLoaded = true
+	}
+
+	metaIndexBlock, err := r.ReadBlock(r.footer.MetaindexHandle)
+	if err != nil {
+		return err
+	}
+
+	metaIndexBlock.Seek([]byte(rangeDelBlockName))
+	if !metaIndexBlock.Valid() || string(metaIndexBlock.Key()) != rangeDelBlockName {
+		return nil
+	}
+
+	block, err := r.ReadBlock(DecodeBlockHandle(metaIndexBlock.Value()))
+	if err != nil {
+		return err
+	}
+	for block.SeekToFirst(); block.Valid(); block.Next() {
+		key := append([]byte(nil), block.Key()...)
+		value := append([]byte(nil), block.Value()...)
+		r.rangeDels = append(r.rangeDels, internal.DecodeRangeTombstone(key, value))
+	}
+	return block.err
+}
+
+// RangeTombstones returns the table's range tombstones ordered by start.
+func (r *Reader) RangeTombstones() []internal.RangeTombstone {
+	return r.rangeDels
+}
+
+// SetComparator sets the key ordering used by iterator seeks. The default
+// orders internal keys by bytewise user key, then by descending trailer;
+// tables written under another user comparator need its internal.Comparator.
+func (r *Reader) SetComparator(cmp func(a, b []byte) int) {
+	r.cmp = cmp
+}
+
 func (r *Reader) Close() error {
 	return r.file.Close()
 }
@@ -93,6 +165,83 @@ func (r *Reader) MayContain(key []byte) bool {
 	return r.filterPolicy.KeyMayMatch(key, r.filterData)
 }
 
+// PrefixMayMatch reports whether the table may hold keys with prefix, as
+// produced by the extractor named extractor.
+// Tables whose filter was built under another extractor always may.
+func (r *Reader) PrefixMayMatch(extractor string, prefix []byte) bool {
+	if r.prefixName != extractor {
+		return true
+	}
+	return r.MayContain(prefix)
+}
+
+// Get returns the first entry >= key if it shares key's user key.
+// The filter is consulted first, so absent keys usually cost no block reads.
+func (r *Reader) Get(key []byte) (foundKey, value []byte, err error) {
+	err = r.Versions(key, func(k, v []byte) bool {
+		foundKey, value = k, v
+		return false
+	})
+	if err == nil && foundKey == nil {
+		err = ErrNotFound
+	}
+	return foundKey, value, err
+}
+
+// Versions calls fn for each entry >= key sharing key's user key, in order,
+// until fn returns false. The filter is consulted first.
+func (r *Reader) Versions(key []byte, fn func(k, v []byte) bool) error {
+	userKey := internal.ExtractUserKey(key)
+	if !r.MayContain(userKey) {
+		return nil
+	}
+
+	it, err := r.NewIterator()
+	if err != nil {
+		return err
+	}
+	for it.Seek(key); it.Valid(); it.Next() {
+		if !bytes.Equal(internal.ExtractUserKey(it.Key()), userKey) {
+			break
+		}
+		if !fn(it.Key(), it.Value()) {
+			break
+		}
+	}
+	return it.err
+}
+
+// MultiVersions is Versions for many keys, sorted ascending, sharing one iterator.
+// fn receives the index of the key each entry belongs to.
+// Keys landing in the same data block read it once.
+func (r *Reader) MultiVersions(keys [][]byte, fn func(i int, k, v []byte) bool) error {
+	var it *TableIterator
+	for i, key := range keys {
+		userKey := internal.ExtractUserKey(key)
+		if !r.MayContain(userKey) {
+			continue
+		}
+		if it == nil {
+			var err error
+			if it, err = r.NewIterator(); err != nil {
+				return err
+			}
+		}
+		for it.Seek(key); it.Valid(); it.Next() {
+			if !bytes.Equal(internal.ExtractUserKey(it.Key()), userKey) {
+				break
+			}
+			if !fn(i, it.Key(), it.Value()) {
+				break
+			}
+		}
+		if it.err != nil {
+			return it.err
+		}
+	}
+	return nil
+}
+
 func (r *Reader) ReadBlock(handle BlockHandle) (*BlockIterator, error) {
 	// Query cache.
 	var cacheKey string
@@ -130,19 +279,13 @@ func (r *Reader) ReadBlock(handle BlockHandle) (*BlockIterator, error) {
 	cType := content[len(content)-1]
 	payload := content[:len(content)-1]
 
-	var decoded []byte
-	switch int(cType) {
-	case NoCompression:
-		decoded = payload
-	case ZlibCompression:
-		var err error
-		decoded, err = decompress(payload)
-		if err != nil {
-			return nil, err
-		}
-	default:
+	decoded, err := decompressBlock(int(cType), payload)
+	if err == ErrUnsupportedCompression {
 		return nil, fmt.Errorf("unknown compression type: %d", cType)
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Cache decoded block.
 	if r.cache != nil {
@@ -167,15 +310,19 @@ func (r *Reader) ReadFooter() (Footer, error) {
 }
 
 func (r *Reader) NewIterator() (*TableIterator, error) {
-	footer, err := r.ReadFooter()
-	if err != nil {
-		return nil, err
+	footer := r.footer
+	if !r.footerLoaded {
+		var err error
+		if footer, err = r.ReadFooter(); err != nil {
+			return nil, err
+		}
 	}
 
 	indexBlock, err := r.ReadBlock(footer.IndexHandle)
 	if err != nil {
 		return nil, err
 	}
+	indexBlock.SetComparator(r.cmp)
 
 	return &TableIterator{
 		reader: r,
@@ -189,6 +336,9 @@ type TableIterator struct {
 	index  *BlockIterator
 	data   *BlockIterator
 
+	// dataHandle locates data, so seeks within the same block skip the read.
+	dataHandle BlockHandle
+
 	valid bool
 	err   error
 }
@@ -197,6 +347,13 @@ func (it *TableIterator) Valid() bool {
 	return it.valid && it.err == nil
 }
 
+// Err returns the error that invalidated the iterator: a block that could
+// not be read, failed its checksum or did not decompress. A table read to
+// its end without one returns nil.
+func (it *TableIterator) Err() error {
+	return it.err
+}
+
 func (it *TableIterator) Key() []byte {
 	return it.data.Key()
 }
@@ -205,25 +362,53 @@ func (it *TableIterator) Value() []byte {
 	return it.data.Value()
 }
 
+// RangeTombstones returns the range tombstones of the table being iterated.
+func (it *TableIterator) RangeTombstones() []internal.RangeTombstone {
+	return it.reader.RangeTombstones()
+}
+
 func (it *TableIterator) SeekToFirst() {
 	it.index.SeekToFirst()
 	it.loadDataBlock()
 	if it.data != nil {
 		it.data.SeekToFirst()
-		it.valid = it.data.Valid()
-	} else {
-		it.valid = false
 	}
+	it.skipEmptyBlocksForward()
+}
+
+func (it *TableIterator) SeekToLast() {
+	it.index.SeekToLast()
+	it.loadDataBlock()
+	if it.data != nil {
+		it.data.SeekToLast()
+	}
+	it.skipEmptyBlocksBackward()
 }
 
+// Seek positions at the first key >= target.
+// Index entries hold each block's last key, so the first index entry >= target
+// names the only block that can contain it.
 func (it *TableIterator) Seek(key []byte) {
 	it.index.Seek(key)
 	it.loadDataBlock()
 	if it.data != nil {
 		it.data.Seek(key)
-		it.valid = it.data.Valid()
-	} else {
-		it.valid = false
+	}
+	it.skipEmptyBlocksForward()
+}
+
+// SeekForPrev positions at the last key <= target.
+func (it *TableIterator) SeekForPrev(key []byte) {
+	it.Seek(key)
+	if it.err != nil {
+		return
+	}
+	if !it.Valid() {
+		it.SeekToLast()
+		return
+	}
+	if it.reader.cmp(it.Key(), key) > 0 {
+		it.Prev()
 	}
 }
 
@@ -232,19 +417,48 @@ func (it *TableIterator) Next() {
 		return
 	}
 	it.data.Next()
-	if !it.data.Valid() {
+	it.skipEmptyBlocksForward()
+}
+
+func (it *TableIterator) Prev() {
+	if it.data == nil {
+		return
+	}
+	it.data.Prev()
+	it.skipEmptyBlocksBackward()
+}
+
+// skipEmptyBlocksForward moves to the next block while the current one is exhausted.
+func (it *TableIterator) skipEmptyBlocksForward() {
+	for it.data != nil && !it.data.Valid() && it.err == nil {
+		if it.data.err != nil {
+			it.err = it.data.err
+			break
+		}
 		// Advance to next block.
 		it.index.Next()
 		it.loadDataBlock()
 		if it.data != nil {
 			it.data.SeekToFirst()
-			it.valid = it.data.Valid()
-		} else {
-			it.valid = false
 		}
-	} else {
-		it.valid = true
 	}
+	it.valid = it.data != nil && it.data.Valid()
+}
+
+// skipEmptyBlocksBackward moves to the previous block while the current one is exhausted.
+func (it *TableIterator) skipEmptyBlocksBackward() {
+	for it.data != nil && !it.data.Valid() && it.err == nil {
+		if it.data.err != nil {
+			it.err = it.data.err
+			break
+		}
+		it.index.Prev()
+		it.loadDataBlock()
+		if it.data != nil {
+			it.data.SeekToLast()
+		}
+	}
+	it.valid = it.data != nil && it.data.Valid()
 }
 
 func (it *TableIterator) loadDataBlock() {
@@ -256,12 +470,18 @@ func (it *TableIterator) loadDataBlock() {
 
 	// Decode block handle.
 	handle := DecodeBlockHandle(it.index.Value())
+	if it.data != nil && handle == it.dataHandle {
+		return
+	}
 
 	block, err := it.reader.ReadBlock(handle)
 	if err != nil {
 		it.err = err
+		it.data = nil
 		it.valid = false
 		return
 	}
+	block.SetComparator(it.reader.cmp)
 	it.data = block
+	it.dataHandle = handle
 }
@@ -1,6 +1,7 @@
 package sstable
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -88,3 +89,282 @@ func TestPrefixCompression(t *testing.T) {
 		t.Fatalf("Expected %s3", prefix)
 	}
 }
+
+func TestReaderGet(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "get.sst")
+
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	b.Add(internal.EncodeInternalKey([]byte("b"), 5, internal.RecordTypeValue), []byte("b5"))
+	b.Add(internal.EncodeInternalKey([]byte("b"), 3, internal.RecordTypeTombstone), nil)
+	b.Add(internal.EncodeInternalKey([]byte("b"), 2, internal.RecordTypeValue), []byte("b2"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetComparator(internal.Comparator{}.Compare)
+
+	_, v, err := r.Get(internal.SeekKey([]byte("b"), internal.MaxSequenceNumber))
+	if err != nil || string(v) != "b5" {
+		t.Fatalf("expected newest b5, got %q (%v)", v, err)
+	}
+
+	k, _, err := r.Get(internal.SeekKey([]byte("b"), 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, typ, _ := internal.ExtractTrailer(k); typ != internal.RecordTypeTombstone {
+		t.Fatalf("expected tombstone visible at seq 4")
+	}
+
+	if _, _, err := r.Get(internal.SeekKey([]byte("c"), internal.MaxSequenceNumber)); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for absent key, got %v", err)
+	}
+}
+
+func TestRangeTombstoneBlock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rangedel.sst")
+
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	for _, ts := range []internal.RangeTombstone{
+		{Start: []byte("b"), End: []byte("d"), Seq: 7},
+		{Start: []byte("c"), End: []byte("z"), Seq: 3},
+	} {
+		key, value := ts.Encode()
+		if err := b.AddRangeTombstone(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got := r.RangeTombstones()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 range tombstones, got %d", len(got))
+	}
+	if string(got[0].Start) != "b" || string(got[0].End) != "d" || got[0].Seq != 7 {
+		t.Fatalf("unexpected first tombstone %+v", got[0])
+	}
+	if string(got[1].Start) != "c" || string(got[1].End) != "z" || got[1].Seq != 3 {
+		t.Fatalf("unexpected second tombstone %+v", got[1])
+	}
+
+	// Point data is unaffected by the extra block.
+	r.SetComparator(internal.Comparator{}.Compare)
+	if _, v, err := r.Get(internal.SeekKey([]byte("a"), internal.MaxSequenceNumber)); err != nil || string(v) != "a1" {
+		t.Fatalf("expected a1, got %q (%v)", v, err)
+	}
+}
+
+// countingCache counts block lookups, hit or miss.
+type countingCache struct {
+	blocks  map[string][]byte
+	lookups int
+}
+
+func (c *countingCache) Get(key string) []byte {
+	c.lookups++
+	return c.blocks[key]
+}
+
+func (c *countingCache) Put(key string, value []byte) {
+	c.blocks[key] = value
+}
+
+func TestReaderMultiVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "multi.sst")
+
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	b.Add(internal.EncodeInternalKey([]byte("b"), 5, internal.RecordTypeValue), []byte("b5"))
+	b.Add(internal.EncodeInternalKey([]byte("b"), 2, internal.RecordTypeValue), []byte("b2"))
+	b.Add(internal.EncodeInternalKey([]byte("d"), 1, internal.RecordTypeValue), []byte("d1"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &countingCache{blocks: make(map[string][]byte)}
+	r, err := NewReader(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetComparator(internal.Comparator{}.Compare)
+	c.lookups = 0
+
+	keys := [][]byte{
+		internal.SeekKey([]byte("a"), internal.MaxSequenceNumber),
+		internal.SeekKey([]byte("b"), internal.MaxSequenceNumber),
+		internal.SeekKey([]byte("c"), internal.MaxSequenceNumber),
+		internal.SeekKey([]byte("d"), internal.MaxSequenceNumber),
+	}
+	got := make(map[int][]string)
+	err = r.MultiVersions(keys, func(i int, k, v []byte) bool {
+		got[i] = append(got[i], string(v))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got[0]) != 1 || len(got[1]) != 2 || len(got[2]) != 0 || len(got[3]) != 1 {
+		t.Fatalf("unexpected versions %v", got)
+	}
+	if got[1][0] != "b5" || got[1][1] != "b2" {
+		t.Fatalf("expected b versions newest first, got %v", got[1])
+	}
+	// The index block plus the shared data block, read once for all keys.
+	if c.lookups != 2 {
+		t.Fatalf("expected 2 block reads, got %d", c.lookups)
+	}
+}
+
+func TestReaderDefaultComparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.sst")
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bytewise over whole internal keys, "a"+trailer sorts after "ab"+trailer.
+	b.Add(internal.EncodeInternalKey([]byte("a"), 2, internal.RecordTypeValue), []byte("a2"))
+	b.Add(internal.EncodeInternalKey([]byte("a"), 1, internal.RecordTypeValue), []byte("a1"))
+	b.Add(internal.EncodeInternalKey([]byte("ab"), 1, internal.RecordTypeValue), []byte("ab1"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	it, err := r.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Seek(internal.SeekKey([]byte("a"), 1))
+	if !it.Valid() || string(it.Value()) != "a1" {
+		t.Fatalf("Seek(a@1) landed on %q", it.Value())
+	}
+	it.SeekForPrev(internal.SeekKey([]byte("aa"), internal.MaxSequenceNumber))
+	if !it.Valid() || string(it.Value()) != "a1" {
+		t.Fatalf("SeekForPrev(aa) landed on %q", it.Value())
+	}
+}
+
+func TestShortenedIndexKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.sst")
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetComparator(internal.Comparator{})
+
+	// Long keys spread over several blocks, their first bytes far apart.
+	value := make([]byte, 500)
+	var keys [][]byte
+	for i := 0; i < 40; i++ {
+		userKey := append([]byte{byte(0x10 + 4*i)}, fmt.Sprintf("-long-shared-suffix-%03d", i)...)
+		key := internal.EncodeInternalKey(userKey, 1, internal.RecordTypeValue)
+		keys = append(keys, key)
+		b.Add(key, value)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetComparator(internal.Comparator{}.Compare)
+
+	it, err := r.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := 0
+	for it.index.SeekToFirst(); it.index.Valid(); it.index.Next() {
+		if len(internal.ExtractUserKey(it.index.Key())) != 1 {
+			t.Fatalf("expected a one-byte separator, got %q", it.index.Key())
+		}
+		blocks++
+	}
+	if blocks < 2 {
+		t.Fatalf("expected several blocks, got %d", blocks)
+	}
+
+	for _, key := range keys {
+		it.Seek(key)
+		if !it.Valid() || string(it.Key()) != string(key) {
+			t.Fatalf("Seek(%q) missed", internal.ExtractUserKey(key))
+		}
+	}
+}
+
+func TestPrefixFilter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prefix.sst")
+
+	b, err := NewBuilder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor := NewFixedPrefixExtractor(4)
+	b.SetPrefixExtractor(extractor)
+	for i, k := range []string{"aaaa1", "aaaa2", "bbbb1", "cc"} {
+		b.Add(internal.EncodeInternalKey([]byte(k), uint64(i+1), internal.RecordTypeValue), []byte("v"))
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	name := extractor.Name()
+	if !r.PrefixMayMatch(name, []byte("aaaa")) || !r.PrefixMayMatch(name, []byte("bbbb")) {
+		t.Fatal("expected written prefixes to match")
+	}
+	if r.PrefixMayMatch(name, []byte("zzzz")) {
+		t.Fatal("expected absent prefix to be ruled out")
+	}
+	// Filters built by another extractor prove nothing.
+	if !r.PrefixMayMatch(NewFixedPrefixExtractor(3).Name(), []byte("zzz")) {
+		t.Fatal("expected a match under a different extractor")
+	}
+	// Whole keys are still in the filter.
+	if !r.MayContain([]byte("cc")) {
+		t.Fatal("expected whole key in filter")
+	}
+}
@@ -9,8 +9,12 @@ import (
 var (
 	ErrCorruptSSTable = errors.New("corrupt sstable")
 	ErrBlockCorrupt   = errors.New("corrupt block")
+	ErrNotFound       = errors.New("key not found in sstable")
 )
 
+// rangeDelBlockName is the metaindex key of the range deletion block.
+const rangeDelBlockName = "vern.rangedel"
+
 const (
 	MagicNumber uint64 = 0x5645524E_00000008
 	FooterSize         = 16 + 16 + 8
@@ -5,7 +5,6 @@ import (
 	"testing"
 
 	"vern_kv0.8/engine"
-	"vern_kv0.8/wal"
 )
 
 // TestIntegrationBasic verifies Puts, Batch writes, Deletes, and Recovery.
@@ -22,13 +21,10 @@ func TestIntegrationBasic(t *testing.T) {
 	}
 
 	// 2. Batch Write
-	batch := &wal.Batch{
-		Records: []wal.LogicalRecord{
-			{Key: []byte("batch-key1"), Value: []byte("batch-val1"), Type: wal.LogicalTypePut},
-			{Key: []byte("batch-key2"), Value: []byte("batch-val2"), Type: wal.LogicalTypePut},
-			{Key: []byte("key0"), Type: wal.LogicalTypeDelete}, // Overwrite key0 with delete
-		},
-	}
+	batch := engine.NewWriteBatch()
+	batch.Put([]byte("batch-key1"), []byte("batch-val1"))
+	batch.Put([]byte("batch-key2"), []byte("batch-val2"))
+	batch.Delete([]byte("key0")) // Overwrite key0 with delete
 	if err := db.Write(batch); err != nil {
 		t.Fatalf("Batch Write failed: %v", err)
 	}
@@ -10,19 +10,29 @@ import (
 const (
 	recordTypeWriteBatch uint8 = 0x01
 
-	logicalTypePut    uint8 = 0x01
-	logicalTypeDelete uint8 = 0x02
+	logicalTypePut         uint8 = 0x01
+	logicalTypeDelete      uint8 = 0x02
+	logicalTypeMerge       uint8 = 0x03
+	logicalTypeDeleteRange uint8 = 0x04
+	logicalTypePutTTL      uint8 = 0x05
+
+	// columnFamilyFlag marks a type byte followed by a 4-byte column family ID.
+	// Default family records omit it and keep the original layout.
+	columnFamilyFlag uint8 = 0x80
 )
 
 var (
 	errInvalidRecord = errors.New("invalid wal record")
 )
 
-// LogicalRecord represents a PUT or DELETE operation.
+// LogicalRecord represents a PUT, DELETE, MERGE or DELETE_RANGE operation.
 type LogicalRecord struct {
 	Key   []byte
 	Value []byte
 	Type  uint8
+
+	// ColumnFamily is the ID of the target column family; 0 is the default family.
+	ColumnFamily uint32
 }
 
 // Batch represents an atomic update.
@@ -54,10 +64,15 @@ func EncodeRecord(batch Batch) ([]byte, error) {
 			binary.Write(&payload, binary.LittleEndian, uint32(len(r.Value)))
 		}
 
-		payload.WriteByte(r.Type)
+		if r.ColumnFamily != 0 {
+			payload.WriteByte(r.Type | columnFamilyFlag)
+			binary.Write(&payload, binary.LittleEndian, r.ColumnFamily)
+		} else {
+			payload.WriteByte(r.Type)
+		}
 		payload.Write(r.Key)
 
-		if r.Type == logicalTypePut {
+		if r.Type != logicalTypeDelete {
 			payload.Write(r.Value)
 		}
 	}
@@ -124,6 +139,16 @@ func DecodeRecord(data []byte) (Batch, int, error) {
 		typ := payload[offset+8]
 		offset += 9
 
+		var cf uint32
+		if typ&columnFamilyFlag != 0 {
+			if offset+4 > len(payload) {
+				return Batch{}, 0, errInvalidRecord
+			}
+			cf = binary.LittleEndian.Uint32(payload[offset:])
+			typ &^= columnFamilyFlag
+			offset += 4
+		}
+
 		if offset+int(keyLen) > len(payload) {
 			return Batch{}, 0, errInvalidRecord
 		}
@@ -133,7 +158,7 @@ func DecodeRecord(data []byte) (Batch, int, error) {
 		offset += int(keyLen)
 
 		var value []byte
-		if typ == logicalTypePut {
+		if typ != logicalTypeDelete {
 			if offset+int(valLen) > len(payload) {
 				return Batch{}, 0, errInvalidRecord
 			}
@@ -143,9 +168,10 @@ func DecodeRecord(data []byte) (Batch, int, error) {
 		}
 
 		records = append(records, LogicalRecord{
-			Key:   key,
-			Value: value,
-			Type:  typ,
+			Key:          key,
+			Value:        value,
+			Type:         typ,
+			ColumnFamily: cf,
 		})
 	}
 
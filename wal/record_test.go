@@ -72,3 +72,26 @@ func TestPartialRecordFails(t *testing.T) {
 		t.Fatalf("expected failure on partial record")
 	}
 }
+
+func TestEncodeDecodeMergeRecord(t *testing.T) {
+	batch := Batch{
+		SeqStart: 7,
+		Records: []LogicalRecord{
+			{Key: []byte("c"), Value: []byte("+1"), Type: logicalTypeMerge},
+		},
+	}
+
+	raw, err := EncodeRecord(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, _, err := DecodeRecord(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := decoded.Records[0]
+	if r.Type != logicalTypeMerge || !bytes.Equal(r.Value, []byte("+1")) {
+		t.Fatalf("merge record mismatch: %+v", r)
+	}
+}
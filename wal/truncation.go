@@ -1,17 +1,129 @@
 package wal
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
+	"time"
 )
 
+// truncatedFile names the file in a WAL directory holding the newest
+// sequence number whose segment truncation removed.
+const truncatedFile = "TRUNCATED"
+
+// Retention keeps WAL segments after recovery no longer needs them, for
+// readers tailing the log. A segment is kept while either limit holds;
+// zero disables a limit.
+type Retention struct {
+	// Age keeps segments last written less than Age ago.
+	Age time.Duration
+
+	// Size keeps the newest segments while their total size stays within
+	// Size bytes.
+	Size int64
+}
+
 // Truncate safely removes old WAL segments.
 // Persists change by syncing directory.
 func Truncate(walDir string, cutoffSeq uint64) error {
-	entries, err := os.ReadDir(walDir)
+	return TruncateRetaining(walDir, cutoffSeq, Retention{}, time.Now())
+}
+
+// TruncateRetaining is Truncate, except that segments within r as of now
+// are kept. Segments are removed oldest first, so the log stays contiguous.
+//
+// The newest sequence number removed is recorded before any segment is, so a
+// reader that finds a segment missing also finds it in TruncatedSeq.
+func TruncateRetaining(walDir string, cutoffSeq uint64, r Retention, now time.Time) error {
+	deletable, lastSeqs, _, err := splitSegments(walDir, cutoffSeq)
+	if err != nil {
+		return err
+	}
+	deletable = deletable[:len(deletable)-r.retained(deletable, now)]
+	if len(deletable) > 0 {
+		if err := recordTruncation(walDir, walDir, lastSeqs[len(deletable)-1]); err != nil {
+			return err
+		}
+	}
+
+	// Delete deletable segments
+	for _, path := range deletable {
+		_ = os.Remove(path)
+	}
+
+	// Ensure directory durability
+	return syncDir(walDir)
+}
+
+// CopyLiveSegments copies the segments Truncate(walDir, cutoffSeq) would keep
+// into dstDir. Each copy ends at its last whole record, so a copy taken while
+// the log is appended to never ends in a torn write. The segments left out
+// are recorded as truncated in dstDir.
+func CopyLiveSegments(walDir, dstDir string, cutoffSeq uint64) error {
+	skipped, lastSeqs, live, err := splitSegments(walDir, cutoffSeq)
 	if err != nil {
 		return err
 	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		if err := recordTruncation(walDir, dstDir, lastSeqs[len(skipped)-1]); err != nil {
+			return err
+		}
+	} else if seq, ok, err := TruncatedSeq(walDir); err != nil {
+		return err
+	} else if ok {
+		if err := writeTruncatedSeq(dstDir, seq); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range live {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFileSync(filepath.Join(dstDir, filepath.Base(path)), data[:wholeRecords(data)]); err != nil {
+			return err
+		}
+	}
+	return syncDir(dstDir)
+}
+
+// retained returns how many of the newest of segments r keeps.
+func (r Retention) retained(segments []string, now time.Time) int {
+	if r.Age <= 0 && r.Size <= 0 {
+		return 0
+	}
+
+	kept := 0
+	var size int64
+	for i := len(segments) - 1; i >= 0; i-- {
+		info, err := os.Stat(segments[i])
+		if err != nil {
+			break
+		}
+		size += info.Size()
+		young := r.Age > 0 && now.Sub(info.ModTime()) < r.Age
+		small := r.Size > 0 && size <= r.Size
+		if !young && !small {
+			break
+		}
+		kept++
+	}
+	return kept
+}
+
+// ListSegments returns the paths of walDir's segments, oldest first.
+func ListSegments(walDir string) ([]string, error) {
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		return nil, err
+	}
 
 	var segments []string
 	for _, e := range entries {
@@ -24,8 +136,93 @@ func Truncate(walDir string, cutoffSeq uint64) error {
 	}
 
 	sort.Strings(segments)
+	return segments, nil
+}
+
+// SegmentNumber returns the number of the segment at path. The first
+// segment of a log is number 1.
+func SegmentNumber(path string) (uint64, bool) {
+	var n uint64
+	if _, err := fmt.Sscanf(filepath.Base(path), "wal_%06d.log", &n); err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// SegmentFirstSeq returns the first sequence number in the segment at path,
+// read from its first record header alone. It returns false for a segment
+// not yet holding a whole header.
+func SegmentFirstSeq(path string) (uint64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	// [CRC | Length | Type | Flags | pad | SeqStart | Count]
+	var head [4 + 4 + 16]byte
+	if _, err := io.ReadFull(f, head[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if head[8] != recordTypeWriteBatch {
+		return 0, false, nil
+	}
+	return binary.LittleEndian.Uint64(head[12:]), true, nil
+}
+
+// TruncatedSeq returns the newest sequence number truncation has removed from
+// walDir's log. It returns false if no truncation was recorded there.
+func TruncatedSeq(walDir string) (uint64, bool, error) {
+	data, err := os.ReadFile(filepath.Join(walDir, truncatedFile))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, errInvalidRecord
+	}
+	return binary.LittleEndian.Uint64(data), true, nil
+}
+
+// recordTruncation records in dstDir that srcDir's log lost every sequence up
+// to seq, keeping any newer truncation srcDir already recorded.
+func recordTruncation(srcDir, dstDir string, seq uint64) error {
+	prev, ok, err := TruncatedSeq(srcDir)
+	if err != nil {
+		return err
+	}
+	if ok && prev > seq {
+		seq = prev
+	}
+	return writeTruncatedSeq(dstDir, seq)
+}
+
+// writeTruncatedSeq replaces dir's truncation record with seq.
+func writeTruncatedSeq(dir string, seq uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], seq)
+	tmp := filepath.Join(dir, truncatedFile+".tmp")
+	if err := writeFileSync(tmp, buf[:]); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, truncatedFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
 
-	var deletable []string
+// splitSegments sorts walDir's segments into those holding only sequences up
+// to cutoffSeq, which are safe to delete, and the rest. lastSeqs holds the
+// newest sequence of each deletable segment.
+func splitSegments(walDir string, cutoffSeq uint64) (deletable []string, lastSeqs []uint64, live []string, err error) {
+	segments, err := ListSegments(walDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	for i, path := range segments {
 		// Always preserve the last (active) segment to avoid deleting a file the WAL handle is still writing
@@ -35,7 +232,7 @@ func Truncate(walDir string, cutoffSeq uint64) error {
 
 		data, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 
 		var maxSeq uint64
@@ -45,7 +242,7 @@ func Truncate(walDir string, cutoffSeq uint64) error {
 			batch, n, err := DecodeRecord(data[offset:])
 			if err != nil {
 				// Stop on corruption
-				return nil
+				return nil, nil, segments, nil
 			}
 
 			batchMax := batch.SeqStart + uint64(len(batch.Records)) - 1
@@ -58,18 +255,46 @@ func Truncate(walDir string, cutoffSeq uint64) error {
 
 		if maxSeq <= cutoffSeq {
 			deletable = append(deletable, path)
+			lastSeqs = append(lastSeqs, maxSeq)
 		} else {
 			break // prefix rule
 		}
 	}
 
-	// Delete deletable segments
-	for _, path := range deletable {
-		_ = os.Remove(path)
+	return deletable, lastSeqs, segments[len(deletable):], nil
+}
+
+// wholeRecords returns the length of data's prefix made of whole records.
+func wholeRecords(data []byte) int {
+	offset := 0
+	for offset < len(data) {
+		_, n, err := DecodeRecord(data[offset:])
+		if err != nil {
+			break
+		}
+		offset += n
 	}
+	return offset
+}
 
-	// Ensure directory durability
-	dir, err := os.Open(walDir)
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,11 @@
 package wal
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestWALTruncation(t *testing.T) {
@@ -34,6 +36,13 @@ func TestWALTruncation(t *testing.T) {
 	if _, err := os.Stat(filepath.Join(dir, "wal_000002.log")); err != nil {
 		t.Fatalf("expected second segment to remain")
 	}
+
+	if seq, ok, err := TruncatedSeq(dir); err != nil || !ok || seq != 2 {
+		t.Fatalf("TruncatedSeq = %d, %v, %v; want 2", seq, ok, err)
+	}
+	if seq, ok, err := SegmentFirstSeq(filepath.Join(dir, "wal_000002.log")); err != nil || !ok || seq != 3 {
+		t.Fatalf("SegmentFirstSeq = %d, %v, %v; want 3", seq, ok, err)
+	}
 }
 
 // helper
@@ -49,3 +58,92 @@ func mustEncode(seq uint64) []byte {
 	}
 	return b
 }
+
+func TestCopyLiveSegments(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "wal")
+
+	w1, _ := OpenSegment(filepath.Join(dir, "wal_000001.log"))
+	w1.Append(mustEncode(1))
+	w1.Close()
+
+	// The active segment ends in a torn write.
+	w2, _ := OpenSegment(filepath.Join(dir, "wal_000002.log"))
+	w2.Append(mustEncode(2))
+	torn := mustEncode(3)
+	w2.Append(torn[:len(torn)-1])
+	w2.Close()
+
+	if err := CopyLiveSegments(dir, dst, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "wal_000001.log")); !os.IsNotExist(err) {
+		t.Fatalf("expected the flushed segment to be skipped")
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "wal_000002.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(mustEncode(2)) {
+		t.Fatalf("expected only the whole record, got %d bytes", len(data))
+	}
+
+	if seq, ok, err := TruncatedSeq(dst); err != nil || !ok || seq != 1 {
+		t.Fatalf("expected the skipped segment recorded as truncated, got %d, %v, %v", seq, ok, err)
+	}
+
+	// The source is untouched.
+	if _, err := os.Stat(filepath.Join(dir, "wal_000001.log")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := TruncatedSeq(dir); ok {
+		t.Fatalf("expected no truncation recorded in the source")
+	}
+}
+
+func TestTruncateRetaining(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// Segments 1-3 are obsolete; 4 is active. Segment i was written 4-i hours ago.
+	for i := uint64(1); i <= 4; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("wal_%06d.log", i))
+		seg, _ := OpenSegment(path)
+		seg.Append(mustEncode(i))
+		seg.Close()
+		mtime := now.Add(-time.Duration(4-i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := int64(len(mustEncode(1)))
+
+	exists := func(i int) bool {
+		_, err := os.Stat(filepath.Join(dir, fmt.Sprintf("wal_%06d.log", i)))
+		return err == nil
+	}
+
+	// Segment 3 is younger than 90 minutes.
+	if err := TruncateRetaining(dir, 3, Retention{Age: 90 * time.Minute}, now); err != nil {
+		t.Fatal(err)
+	}
+	if exists(1) || exists(2) || !exists(3) || !exists(4) {
+		t.Fatalf("age retention kept the wrong segments")
+	}
+
+	// Room for one obsolete segment by size.
+	if err := TruncateRetaining(dir, 3, Retention{Size: size}, now); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(3) {
+		t.Fatalf("size retention removed the newest obsolete segment")
+	}
+
+	if err := TruncateRetaining(dir, 3, Retention{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if exists(3) || !exists(4) {
+		t.Fatalf("expected only the active segment to remain")
+	}
+}
@@ -13,6 +13,13 @@ const (
 	defaultSegmentSize       = 64 * 1024 * 1024 // 64MB
 	LogicalTypePut     uint8 = 0x01
 	LogicalTypeDelete  uint8 = 0x02
+	LogicalTypeMerge   uint8 = 0x03
+
+	// LogicalTypeDeleteRange records carry the range start as Key and its end as Value.
+	LogicalTypeDeleteRange uint8 = 0x04
+
+	// LogicalTypePutTTL records prefix Value with an 8-byte expiry time.
+	LogicalTypePutTTL uint8 = 0x05
 )
 
 // WAL manages write-ahead log segments.